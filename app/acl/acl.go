@@ -0,0 +1,358 @@
+// Package acl holds the server's users: their passwords, the commands they
+// may run and the keys they may touch. The "default" user always exists;
+// its password follows requirepass, so a server configured only with
+// requirepass behaves as it always has.
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"redis/app/config"
+	"redis/app/glob"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DefaultUser = "default"
+
+// Categories are the command categories rules may name with +@ and -@.
+var Categories = []string{"all", "read", "write", "admin", "dangerous", "fast", "slow", "blocking", "pubsub"}
+
+var ErrDeleteDefault = errors.New("The 'default' user cannot be removed")
+
+// User is a set of credentials and permissions. Connections keep a pointer
+// to their user, so changes made by SetUser apply to them right away.
+type User struct {
+	name string
+
+	mu        sync.RWMutex
+	enabled   bool
+	nopass    bool
+	passwords []string // hex SHA-256
+	keys      []string
+	// commands holds the +/- command and category rules in the order they
+	// were given; the last one matching a command decides.
+	commands []string
+}
+
+func (u *User) Name() string {
+	return u.name
+}
+
+var (
+	mu    sync.RWMutex
+	users = make(map[string]*User)
+)
+
+func init() {
+	users[DefaultUser] = &User{
+		name:     DefaultUser,
+		enabled:  true,
+		nopass:   true,
+		keys:     []string{"*"},
+		commands: []string{"+@all"},
+	}
+	config.Watch("requirepass", func(pass string) {
+		u := Get(DefaultUser)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if pass == "" {
+			u.passwords, u.nopass = nil, true
+		} else {
+			u.passwords, u.nopass = []string{hashPassword(pass)}, false
+		}
+	})
+}
+
+// Get returns the named user, or nil.
+func Get(name string) *User {
+	mu.RLock()
+	defer mu.RUnlock()
+	return users[name]
+}
+
+// Authenticate returns the user if it is enabled and password is one of
+// its passwords.
+func Authenticate(name, password string) (*User, bool) {
+	u := Get(name)
+	if u == nil {
+		return nil, false
+	}
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if !u.enabled {
+		return nil, false
+	}
+	if u.nopass {
+		return u, true
+	}
+	hash := []byte(hashPassword(password))
+	ok := false
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(p)) == 1 {
+			ok = true
+		}
+	}
+	return u, ok
+}
+
+// NoPass reports whether u accepts any password.
+func (u *User) NoPass() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.nopass
+}
+
+// NeedsAuth reports whether a new connection logged in as u has to
+// authenticate before running commands.
+func (u *User) NeedsAuth() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return !u.enabled || !u.nopass
+}
+
+// CanRun reports whether u may run the named command, which belongs to the
+// given categories.
+func (u *User) CanRun(cmd string, categories []string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	allowed := false
+	for _, rule := range u.commands {
+		allow := rule[0] == '+'
+		name := rule[1:]
+		if cat, ok := strings.CutPrefix(name, "@"); ok {
+			if cat == "all" || contains(categories, cat) {
+				allowed = allow
+			}
+		} else if name == cmd {
+			allowed = allow
+		}
+	}
+	return allowed
+}
+
+// CanAccessKey reports whether key matches one of u's key patterns.
+func (u *User) CanAccessKey(key string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	for _, pattern := range u.keys {
+		if glob.Match(pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUser creates the named user if needed and applies rules to it.
+// Either all rules apply or, on error, none do. isCommand validates the
+// names given in +cmd and -cmd rules.
+func SetUser(name string, rules []string, isCommand func(string) bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	u, ok := users[name]
+	if !ok {
+		u = &User{name: name}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	next := User{
+		enabled:   u.enabled,
+		nopass:    u.nopass,
+		passwords: append([]string(nil), u.passwords...),
+		keys:      append([]string(nil), u.keys...),
+		commands:  append([]string(nil), u.commands...),
+	}
+	for _, rule := range rules {
+		if err := next.apply(rule, isCommand); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", rule, err)
+		}
+	}
+	u.enabled, u.nopass = next.enabled, next.nopass
+	u.passwords, u.keys, u.commands = next.passwords, next.keys, next.commands
+	users[name] = u
+	return nil
+}
+
+// apply applies one rule to a user nobody else can see yet.
+func (u *User) apply(rule string, isCommand func(string) bool) error {
+	lower := strings.ToLower(rule)
+	switch {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass, u.passwords = true, nil
+	case lower == "resetpass":
+		u.nopass, u.passwords = false, nil
+	case lower == "allkeys":
+		u.keys = []string{"*"}
+	case lower == "resetkeys":
+		u.keys = nil
+	case lower == "allcommands":
+		u.commands = []string{"+@all"}
+	case lower == "nocommands":
+		u.commands = nil
+	case lower == "reset":
+		*u = User{}
+	case strings.HasPrefix(rule, ">"):
+		u.addPassword(hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		hash := lower[1:]
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(hash)
+	case strings.HasPrefix(rule, "<"):
+		hash := hashPassword(rule[1:])
+		for i, p := range u.passwords {
+			if p == hash {
+				u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("no such password")
+	case strings.HasPrefix(rule, "~"):
+		u.keys = append(u.keys, rule[1:])
+	case strings.HasPrefix(rule, "+@"), strings.HasPrefix(rule, "-@"):
+		cat := lower[2:]
+		if !contains(Categories, cat) {
+			return errors.New("Unknown command category")
+		}
+		if cat == "all" {
+			// +@all and -@all override every rule before them.
+			u.commands = nil
+		}
+		u.commands = append(u.commands, lower)
+	case strings.HasPrefix(rule, "+"), strings.HasPrefix(rule, "-"):
+		if !isCommand(lower[1:]) {
+			return errors.New("Unknown command")
+		}
+		u.commands = append(u.commands, lower)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	if !contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+	u.nopass = false
+}
+
+// Delete removes the named users, reporting how many existed.
+func Delete(names []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if contains(names, DefaultUser) {
+		return 0, ErrDeleteDefault
+	}
+	n := 0
+	for _, name := range names {
+		if _, ok := users[name]; ok {
+			delete(users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Names lists all users alphabetically.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe formats u as one ACL LIST line.
+func (u *User) Describe() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	parts := []string{"user", u.name, u.flag()}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if len(u.keys) == 0 {
+		parts = append(parts, "resetkeys")
+	}
+	for _, k := range u.keys {
+		parts = append(parts, "~"+k)
+	}
+	parts = append(parts, u.commandRules())
+	return strings.Join(parts, " ")
+}
+
+// Properties are what ACL GETUSER reports about a user.
+type Properties struct {
+	Flags     []string
+	Passwords []string
+	Commands  string
+	Keys      string
+}
+
+func (u *User) Properties() Properties {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	p := Properties{
+		Flags:     []string{u.flag()},
+		Passwords: append([]string{}, u.passwords...),
+		Commands:  u.commandRules(),
+	}
+	if u.nopass {
+		p.Flags = append(p.Flags, "nopass")
+	}
+	keys := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		keys[i] = "~" + k
+	}
+	p.Keys = strings.Join(keys, " ")
+	return p
+}
+
+// flag and commandRules expect the caller to hold u.mu.
+func (u *User) flag() string {
+	if u.enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func (u *User) commandRules() string {
+	if len(u.commands) == 0 {
+		return "-@all"
+	}
+	rules := u.commands
+	if rules[0] != "+@all" && rules[0] != "-@all" {
+		rules = append([]string{"-@all"}, rules...)
+	}
+	return strings.Join(rules, " ")
+}
+
+func hashPassword(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
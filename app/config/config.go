@@ -0,0 +1,866 @@
+// Package config holds the server's runtime-tunable settings. Every setting
+// is registered by name so that command-line flags, CONFIG GET and CONFIG
+// SET all go through the same parsing and validation.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"redis/app/glob"
+	"redis/app/logging"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	PolicyNoEviction  = "noeviction"
+	PolicyAllKeysLRU  = "allkeys-lru"
+	PolicyVolatileLRU = "volatile-lru"
+	PolicyAllKeysLFU  = "allkeys-lfu"
+	PolicyVolatileLFU = "volatile-lfu"
+)
+
+var (
+	MaxMemory        atomic.Int64
+	MaxMemorySamples atomic.Int64
+	LFULogFactor     atomic.Int64
+	LFUDecayTime     atomic.Int64
+	maxMemoryPolicy  atomic.Value // string
+	MaxClients       atomic.Int64
+	Port             atomic.Int64
+	Timeout          atomic.Int64
+	Bind             String
+	RequirePass      String
+
+	SlowlogLogSlowerThan atomic.Int64
+	SlowlogMaxLen        atomic.Int64
+
+	Dir            String
+	DBFilename     String
+	AppendOnly     atomic.Bool
+	AppendFilename String
+	// AppendFsync is always, everysec or no.
+	AppendFsync      String
+	AOFLoadTruncated atomic.Bool
+	// LazyfreeLazyUserDel makes DEL free values in the background, as
+	// UNLINK does.
+	LazyfreeLazyUserDel atomic.Bool
+	saveRules           atomic.Value // []SaveRule
+	// StopWritesOnBgsaveError refuses writes while the last background
+	// save failed, so a full disk can't silently lose changes.
+	StopWritesOnBgsaveError atomic.Bool
+	outputBufferLimits      atomic.Value // map[string]OutputBufferLimit
+	commandRenames          atomic.Value // []CommandRename
+	// NotifyKeyspaceEvents holds the event classes as configured, e.g. "KEA".
+	NotifyKeyspaceEvents String
+
+	// An AOF grown by AutoAOFRewritePercentage since its last rewrite,
+	// and at least AutoAOFRewriteMinSize bytes, is rewritten.
+	AutoAOFRewritePercentage atomic.Int64
+	AutoAOFRewriteMinSize    atomic.Int64
+
+	// ListMaxListpackSize bounds the lists kept as a listpack: a positive
+	// value is a number of elements, -1 to -5 a size of 4kb to 64kb.
+	ListMaxListpackSize atomic.Int64
+
+	// ProtoMaxBulkLen, ProtoMaxMultibulkLen and ClientQueryBufferLimit
+	// bound one request from a client: the bytes in an argument, the
+	// number of arguments and the bytes in the whole request.
+	ProtoMaxBulkLen        atomic.Int64
+	ProtoMaxMultibulkLen   atomic.Int64
+	ClientQueryBufferLimit atomic.Int64
+
+	// BusyReplyThreshold is how many milliseconds a script may run before
+	// it is aborted; 0 means no limit.
+	BusyReplyThreshold atomic.Int64
+
+	// EnableExtensions makes the commands we add beyond redis-server's
+	// available.
+	EnableExtensions atomic.Bool
+
+	// ReplicaOf is the master's "host port", empty on a master.
+	ReplicaOf String
+	// ReplicaServeStaleData lets a replica answer from the data it has
+	// while its link to the master is down.
+	ReplicaServeStaleData atomic.Bool
+	// ReplBacklogSize is how many bytes of the replication stream a master
+	// keeps for replicas resuming it after a disconnection.
+	ReplBacklogSize atomic.Int64
+
+	TLSPort        atomic.Int64
+	TLSCertFile    String
+	TLSKeyFile     String
+	TLSCACertFile  String
+	TLSAuthClients String
+
+	// LogLevel is the least severe level logged; LogFile is where to, with
+	// empty meaning stdout.
+	LogLevel slog.LevelVar
+	LogFile  String
+)
+
+// SaveRule asks for a snapshot once Changes writes have happened within
+// Seconds of the last one.
+type SaveRule struct {
+	Seconds, Changes int64
+}
+
+// Client classes for client-output-buffer-limit.
+const (
+	ClassNormal  = "normal"
+	ClassReplica = "replica"
+	ClassPubSub  = "pubsub"
+)
+
+// OutputBufferLimit bounds the replies queued for one client of a class:
+// a client whose pending output reaches Hard bytes, or stays above Soft
+// bytes for SoftSeconds, is disconnected. Zero disables a limit. Only
+// replicas have output queued apart from their connection; a normal
+// client's replies are written by its own goroutine as they are made, so
+// a client that stops reading only ever stalls itself.
+type OutputBufferLimit struct {
+	Hard, Soft, SoftSeconds int64
+}
+
+// ClientOutputBufferLimit returns the limit for a client class.
+func ClientOutputBufferLimit(class string) OutputBufferLimit {
+	return outputBufferLimits.Load().(map[string]OutputBufferLimit)[class]
+}
+
+// CommandRename is one rename-command directive: command From is
+// renamed To, or disabled if To is empty.
+type CommandRename struct {
+	From, To string
+}
+
+// CommandRenames returns the rename-command directives in the order they
+// were given.
+func CommandRenames() []CommandRename {
+	return commandRenames.Load().([]CommandRename)
+}
+
+// SaveRules returns the configured snapshot rules; none means snapshots
+// are only taken on request.
+func SaveRules() []SaveRule {
+	return saveRules.Load().([]SaveRule)
+}
+
+// String is a string setting that is safe for concurrent use.
+type String struct {
+	v atomic.Value
+}
+
+func (s *String) Load() string {
+	v, _ := s.v.Load().(string)
+	return v
+}
+
+func (s *String) Store(v string) {
+	s.v.Store(v)
+}
+
+// MaxMemoryPolicy returns the eviction policy applied once MaxMemory is hit.
+func MaxMemoryPolicy() string {
+	return maxMemoryPolicy.Load().(string)
+}
+
+// IsLFU reports whether the eviction policy ranks keys by access frequency.
+func IsLFU() bool {
+	p := MaxMemoryPolicy()
+	return p == PolicyAllKeysLFU || p == PolicyVolatileLFU
+}
+
+type param struct {
+	get func() string
+	set func(string) error
+	// immutable settings can only be given at startup.
+	immutable bool
+	watchers  []func(string)
+}
+
+// apply sets the parameter and tells its watchers the new value.
+func (p *param) apply(v string) error {
+	if err := p.set(v); err != nil {
+		return err
+	}
+	for _, fn := range p.watchers {
+		fn(v)
+	}
+	return nil
+}
+
+var (
+	paramsMu sync.Mutex
+	params   = make(map[string]*param)
+)
+
+func register(name string, get func() string, set func(string) error) {
+	params[name] = &param{get: get, set: set}
+}
+
+func registerImmutable(name string, get func() string, set func(string) error) {
+	params[name] = &param{get: get, set: set, immutable: true}
+}
+
+func registerImmutableString(name string, s *String) {
+	registerImmutable(name, s.Load, func(v string) error {
+		s.Store(v)
+		return nil
+	})
+}
+
+func init() {
+	maxMemoryPolicy.Store(PolicyNoEviction)
+	MaxMemorySamples.Store(5)
+	LFULogFactor.Store(10)
+	LFUDecayTime.Store(1)
+	MaxClients.Store(10000)
+	SlowlogLogSlowerThan.Store(10000)
+	SlowlogMaxLen.Store(128)
+	DBFilename.Store("dump.rdb")
+	AppendFilename.Store("appendonly.aof")
+	AppendFsync.Store("everysec")
+	AOFLoadTruncated.Store(true)
+	AutoAOFRewritePercentage.Store(100)
+	AutoAOFRewriteMinSize.Store(64 << 20)
+	ListMaxListpackSize.Store(-2)
+	BusyReplyThreshold.Store(5000)
+	ProtoMaxBulkLen.Store(512 << 20)
+	ReplBacklogSize.Store(1 << 20)
+	ProtoMaxMultibulkLen.Store(1024 * 1024)
+	ClientQueryBufferLimit.Store(1 << 30)
+	saveRules.Store([]SaveRule{{3600, 1}, {300, 100}, {60, 10000}})
+	StopWritesOnBgsaveError.Store(true)
+	commandRenames.Store([]CommandRename(nil))
+	outputBufferLimits.Store(map[string]OutputBufferLimit{
+		ClassNormal:  {},
+		ClassReplica: {256 << 20, 64 << 20, 60},
+		ClassPubSub:  {32 << 20, 8 << 20, 60},
+	})
+	if wd, err := os.Getwd(); err == nil {
+		Dir.Store(wd)
+	}
+	ReplicaServeStaleData.Store(true)
+	Port.Store(6379)
+	Bind.Store("0.0.0.0")
+	TLSAuthClients.Store("yes")
+	LogLevel.Set(slog.LevelInfo)
+
+	registerImmutable("port",
+		func() string { return strconv.FormatInt(Port.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 || n > 65535 {
+				return errors.New("argument must be between 0 and 65535 inclusive")
+			}
+			Port.Store(n)
+			return nil
+		})
+	registerImmutableString("bind", &Bind)
+	registerImmutable("tls-port",
+		func() string { return strconv.FormatInt(TLSPort.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 || n > 65535 {
+				return errors.New("argument must be between 0 and 65535 inclusive")
+			}
+			TLSPort.Store(n)
+			return nil
+		})
+	registerImmutableString("tls-cert-file", &TLSCertFile)
+	registerImmutableString("tls-key-file", &TLSKeyFile)
+	registerImmutableString("tls-ca-cert-file", &TLSCACertFile)
+	registerImmutable("tls-auth-clients",
+		func() string { return TLSAuthClients.Load() },
+		func(v string) error {
+			v = strings.ToLower(v)
+			switch v {
+			case "yes", "no", "optional":
+				TLSAuthClients.Store(v)
+				return nil
+			}
+			return errors.New("argument(s) must be one of the following: no, yes, optional")
+		})
+
+	registerImmutableString("logfile", &LogFile)
+	register("loglevel",
+		func() string { return logging.LevelName(LogLevel.Level()) },
+		func(v string) error {
+			l, ok := logging.Levels[strings.ToLower(v)]
+			if !ok {
+				return errors.New("argument(s) must be one of the following: debug, verbose, notice, warning")
+			}
+			LogLevel.Set(l)
+			return nil
+		})
+	register("maxclients",
+		func() string { return strconv.FormatInt(MaxClients.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 {
+				return errors.New("argument must be between 1 and 9223372036854775807 inclusive")
+			}
+			MaxClients.Store(n)
+			return nil
+		})
+	register("timeout",
+		func() string { return strconv.FormatInt(Timeout.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be between 0 and 9223372036854775807 inclusive")
+			}
+			Timeout.Store(n)
+			return nil
+		})
+	register("slowlog-log-slower-than",
+		func() string { return strconv.FormatInt(SlowlogLogSlowerThan.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			SlowlogLogSlowerThan.Store(n)
+			return nil
+		})
+	register("slowlog-max-len",
+		func() string { return strconv.FormatInt(SlowlogMaxLen.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be between 0 and 9223372036854775807 inclusive")
+			}
+			SlowlogMaxLen.Store(n)
+			return nil
+		})
+	register("requirepass",
+		func() string { return RequirePass.Load() },
+		func(v string) error {
+			RequirePass.Store(v)
+			return nil
+		})
+	register("dir",
+		func() string { return Dir.Load() },
+		func(v string) error {
+			abs, err := filepath.Abs(v)
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(abs)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("%s: not a directory", v)
+			}
+			Dir.Store(abs)
+			return nil
+		})
+	register("dbfilename",
+		func() string { return DBFilename.Load() },
+		func(v string) error {
+			if v == "" || filepath.Base(v) != v {
+				return errors.New("dbfilename can't be a path, just a filename")
+			}
+			DBFilename.Store(v)
+			return nil
+		})
+	register("appendonly",
+		func() string { return yesNo(AppendOnly.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			AppendOnly.Store(b)
+			return nil
+		})
+	registerImmutableString("appendfilename", &AppendFilename)
+	registerImmutable("enable-extensions",
+		func() string { return yesNo(EnableExtensions.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			EnableExtensions.Store(b)
+			return nil
+		})
+	register("replica-serve-stale-data",
+		func() string { return yesNo(ReplicaServeStaleData.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			ReplicaServeStaleData.Store(b)
+			return nil
+		})
+	register("repl-backlog-size",
+		func() string { return strconv.FormatInt(ReplBacklogSize.Load(), 10) },
+		func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			if n < 1 {
+				return errors.New("argument must be between 1 and 9223372036854775807 inclusive")
+			}
+			ReplBacklogSize.Store(n)
+			return nil
+		})
+	// Each rename-command adds a directive rather than replacing the
+	// last, so the flag may be repeated; "" as the new name disables the
+	// command.
+	registerImmutable("rename-command",
+		func() string {
+			var parts []string
+			for _, r := range CommandRenames() {
+				to := r.To
+				if to == "" {
+					to = `""`
+				}
+				parts = append(parts, r.From, to)
+			}
+			return strings.Join(parts, " ")
+		},
+		func(v string) error {
+			fields := strings.Fields(v)
+			if len(fields) != 2 {
+				return errors.New("rename-command takes a command name and its new name")
+			}
+			to := fields[1]
+			if to == `""` || to == "''" {
+				to = ""
+			}
+			renames := CommandRenames()
+			commandRenames.Store(append(renames[:len(renames):len(renames)], CommandRename{fields[0], to}))
+			return nil
+		})
+	// REPLICAOF changes this at run time, not CONFIG SET.
+	registerImmutable("replicaof", ReplicaOf.Load, func(v string) error {
+		if fields := strings.Fields(v); len(v) > 0 {
+			if len(fields) != 2 {
+				return errors.New("wrong number of arguments")
+			}
+			if _, err := strconv.ParseUint(fields[1], 10, 16); err != nil {
+				return errors.New("Invalid master port")
+			}
+			v = fields[0] + " " + fields[1]
+		}
+		ReplicaOf.Store(v)
+		return nil
+	})
+	register("appendfsync", AppendFsync.Load, func(v string) error {
+		v = strings.ToLower(v)
+		if v != "always" && v != "everysec" && v != "no" {
+			return errors.New("argument(s) must be one of the following: always, everysec, no")
+		}
+		AppendFsync.Store(v)
+		return nil
+	})
+	register("lazyfree-lazy-user-del",
+		func() string { return yesNo(LazyfreeLazyUserDel.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			LazyfreeLazyUserDel.Store(b)
+			return nil
+		})
+	register("aof-load-truncated",
+		func() string { return yesNo(AOFLoadTruncated.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			AOFLoadTruncated.Store(b)
+			return nil
+		})
+	register("auto-aof-rewrite-percentage",
+		func() string { return strconv.FormatInt(AutoAOFRewritePercentage.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non-negative integer")
+			}
+			AutoAOFRewritePercentage.Store(n)
+			return nil
+		})
+	register("auto-aof-rewrite-min-size",
+		func() string { return strconv.FormatInt(AutoAOFRewriteMinSize.Load(), 10) },
+		func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			AutoAOFRewriteMinSize.Store(n)
+			return nil
+		})
+	register("save",
+		func() string {
+			parts := make([]string, 0, 2*len(SaveRules()))
+			for _, r := range SaveRules() {
+				parts = append(parts, strconv.FormatInt(r.Seconds, 10), strconv.FormatInt(r.Changes, 10))
+			}
+			return strings.Join(parts, " ")
+		},
+		func(v string) error {
+			fields := strings.Fields(v)
+			if len(fields)%2 != 0 {
+				return errors.New("Invalid save parameters")
+			}
+			rules := make([]SaveRule, 0, len(fields)/2)
+			for i := 0; i < len(fields); i += 2 {
+				secs, err1 := strconv.ParseInt(fields[i], 10, 64)
+				changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+				if err1 != nil || err2 != nil || secs < 1 || changes < 0 {
+					return errors.New("Invalid save parameters")
+				}
+				rules = append(rules, SaveRule{secs, changes})
+			}
+			saveRules.Store(rules)
+			return nil
+		})
+	register("stop-writes-on-bgsave-error",
+		func() string { return yesNo(StopWritesOnBgsaveError.Load()) },
+		func(v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			StopWritesOnBgsaveError.Store(b)
+			return nil
+		})
+	register("client-output-buffer-limit",
+		func() string {
+			limits := outputBufferLimits.Load().(map[string]OutputBufferLimit)
+			var parts []string
+			// Named as redis-server names them in CONFIG GET.
+			for _, class := range []struct{ name, key string }{
+				{"normal", ClassNormal}, {"slave", ClassReplica}, {"pubsub", ClassPubSub},
+			} {
+				l := limits[class.key]
+				parts = append(parts, class.name, strconv.FormatInt(l.Hard, 10),
+					strconv.FormatInt(l.Soft, 10), strconv.FormatInt(l.SoftSeconds, 10))
+			}
+			return strings.Join(parts, " ")
+		},
+		func(v string) error {
+			fields := strings.Fields(v)
+			if len(fields)%4 != 0 {
+				return errors.New("Wrong number of arguments in buffer limit configuration.")
+			}
+			limits := make(map[string]OutputBufferLimit)
+			for class, l := range outputBufferLimits.Load().(map[string]OutputBufferLimit) {
+				limits[class] = l
+			}
+			for i := 0; i < len(fields); i += 4 {
+				var class string
+				switch strings.ToLower(fields[i]) {
+				case "normal":
+					class = ClassNormal
+				case "replica", "slave":
+					class = ClassReplica
+				case "pubsub":
+					class = ClassPubSub
+				default:
+					return errors.New("Invalid client class specified in buffer limit configuration.")
+				}
+				hard, err1 := ParseMemory(fields[i+1])
+				soft, err2 := ParseMemory(fields[i+2])
+				secs, err3 := strconv.ParseInt(fields[i+3], 10, 64)
+				if err1 != nil || err2 != nil || err3 != nil || secs < 0 {
+					return errors.New("Error in hard, soft or soft-seconds setting in buffer limit configuration.")
+				}
+				limits[class] = OutputBufferLimit{hard, soft, secs}
+			}
+			outputBufferLimits.Store(limits)
+			return nil
+		})
+	register("notify-keyspace-events",
+		func() string { return NotifyKeyspaceEvents.Load() },
+		func(v string) error {
+			for _, c := range v {
+				if !strings.ContainsRune("KEg$lshzxetmdnA", c) {
+					return errors.New("Invalid event class character. Use 'Ag$lshzxeKEtmdn'.")
+				}
+			}
+			NotifyKeyspaceEvents.Store(v)
+			return nil
+		})
+	listMaxListpackSize := func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		ListMaxListpackSize.Store(n)
+		return nil
+	}
+	listMaxListpackSizeGet := func() string { return strconv.FormatInt(ListMaxListpackSize.Load(), 10) }
+	register("list-max-listpack-size", listMaxListpackSizeGet, listMaxListpackSize)
+	// The name from before listpacks replaced ziplists.
+	register("list-max-ziplist-size", listMaxListpackSizeGet, listMaxListpackSize)
+	busyReplyThreshold := func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("argument must be between 0 and 9223372036854775807 inclusive")
+		}
+		BusyReplyThreshold.Store(n)
+		return nil
+	}
+	busyReplyThresholdGet := func() string { return strconv.FormatInt(BusyReplyThreshold.Load(), 10) }
+	register("busy-reply-threshold", busyReplyThresholdGet, busyReplyThreshold)
+	register("lua-time-limit", busyReplyThresholdGet, busyReplyThreshold)
+	register("proto-max-bulk-len",
+		func() string { return strconv.FormatInt(ProtoMaxBulkLen.Load(), 10) },
+		func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			if n < 1<<20 {
+				return errors.New("argument must be between 1048576 and 9223372036854775807 inclusive")
+			}
+			ProtoMaxBulkLen.Store(n)
+			return nil
+		})
+	register("proto-max-multibulk-len",
+		func() string { return strconv.FormatInt(ProtoMaxMultibulkLen.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 || n > math.MaxInt32 {
+				return errors.New("argument must be between 1 and 2147483647 inclusive")
+			}
+			ProtoMaxMultibulkLen.Store(n)
+			return nil
+		})
+	register("client-query-buffer-limit",
+		func() string { return strconv.FormatInt(ClientQueryBufferLimit.Load(), 10) },
+		func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			if n < 1<<20 {
+				return errors.New("argument must be between 1048576 and 9223372036854775807 inclusive")
+			}
+			ClientQueryBufferLimit.Store(n)
+			return nil
+		})
+	register("maxmemory",
+		func() string { return strconv.FormatInt(MaxMemory.Load(), 10) },
+		func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			MaxMemory.Store(n)
+			return nil
+		})
+	register("maxmemory-policy",
+		func() string { return MaxMemoryPolicy() },
+		func(v string) error {
+			v = strings.ToLower(v)
+			switch v {
+			case PolicyNoEviction, PolicyAllKeysLRU, PolicyVolatileLRU, PolicyAllKeysLFU, PolicyVolatileLFU:
+				maxMemoryPolicy.Store(v)
+				return nil
+			}
+			return errors.New("argument(s) must be one of the following: noeviction, allkeys-lru, volatile-lru, allkeys-lfu, volatile-lfu")
+		})
+	register("maxmemory-samples",
+		func() string { return strconv.FormatInt(MaxMemorySamples.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 || n > 64 {
+				return errors.New("argument must be between 1 and 64 inclusive")
+			}
+			MaxMemorySamples.Store(n)
+			return nil
+		})
+	register("lfu-log-factor",
+		func() string { return strconv.FormatInt(LFULogFactor.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be greater or equal to 0")
+			}
+			LFULogFactor.Store(n)
+			return nil
+		})
+	register("lfu-decay-time",
+		func() string { return strconv.FormatInt(LFUDecayTime.Load(), 10) },
+		func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be greater or equal to 0")
+			}
+			LFUDecayTime.Store(n)
+			return nil
+		})
+}
+
+// Get returns the current value of the named setting.
+func Get(name string) (string, bool) {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	p, ok := params[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return p.get(), true
+}
+
+var (
+	// ErrUnknown is returned for a name that isn't a registered setting.
+	ErrUnknown = errors.New("unknown option")
+	// ErrImmutable is returned by Set for a setting that only SetInitial
+	// may change.
+	ErrImmutable = errors.New("can't set immutable config")
+)
+
+// Set parses value and applies it to the named setting at runtime.
+func Set(name, value string) error {
+	return set(name, value, false)
+}
+
+// SetInitial is Set for startup configuration, where immutable settings
+// may be given too.
+func SetInitial(name, value string) error {
+	return set(name, value, true)
+}
+
+func set(name, value string, initial bool) error {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	p, ok := params[strings.ToLower(name)]
+	if !ok {
+		return ErrUnknown
+	}
+	if p.immutable && !initial {
+		return ErrImmutable
+	}
+	return p.apply(value)
+}
+
+// Watch calls fn with the new value whenever the named setting changes.
+// It is meant to be called from init functions.
+func Watch(name string, fn func(string)) {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	p := params[name]
+	p.watchers = append(p.watchers, fn)
+}
+
+// SetMany applies name/value pairs as one change: if any of them fails,
+// the ones already applied are rolled back and the failing name is
+// returned with the error.
+func SetMany(pairs []string) (string, error) {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		p, ok := params[name]
+		if !ok {
+			return pairs[i], ErrUnknown
+		}
+		if p.immutable {
+			return pairs[i], ErrImmutable
+		}
+		if seen[name] {
+			return pairs[i], errors.New("duplicate parameter")
+		}
+		seen[name] = true
+	}
+
+	old := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		p := params[strings.ToLower(pairs[i])]
+		prev := p.get()
+		if err := p.apply(pairs[i+1]); err != nil {
+			for j := len(old) - 1; j >= 0; j-- {
+				params[strings.ToLower(pairs[2*j])].apply(old[j])
+			}
+			return pairs[i], err
+		}
+		old = append(old, prev)
+	}
+	return "", nil
+}
+
+// Match returns the settings whose names match a glob pattern, in
+// alphabetical order.
+func Match(pattern string) []string {
+	var names []string
+	for _, name := range Names() {
+		if glob.Match(pattern, name, true) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func parseYesNo(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// Names lists every registered setting in alphabetical order.
+func Names() []string {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseMemory parses a byte count with an optional redis.conf style unit:
+// k/m/g are powers of 1000 and kb/mb/gb powers of 1024.
+func ParseMemory(v string) (int64, error) {
+	s := strings.ToLower(v)
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
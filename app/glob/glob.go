@@ -0,0 +1,115 @@
+// Package glob matches strings against the glob-style patterns used by
+// KEYS, SCAN MATCH, CONFIG GET and friends: * and ? wildcards, [...]
+// classes with ranges and ^ negation, and backslash escapes.
+package glob
+
+// Match reports whether s matches pattern. With nocase, ASCII letters
+// compare case-insensitively.
+func Match(pattern, s string, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern[1:], s[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0], nocase)
+			if !ok {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || !equal(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class whose body starts pattern (just
+// past the '['), returning the pattern after the closing ']'. An
+// unterminated class runs to the end of the pattern.
+func matchClass(pattern string, c byte, nocase bool) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if equal(pattern[1], c, nocase) {
+				match = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if inRange(lo, hi, c, nocase) {
+				match = true
+			}
+			pattern = pattern[3:]
+		default:
+			if equal(pattern[0], c, nocase) {
+				match = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return match != negate, pattern
+}
+
+func inRange(lo, hi, c byte, nocase bool) bool {
+	if lo <= c && c <= hi {
+		return true
+	}
+	if nocase {
+		lc := lower(c)
+		return lower(lo) <= lc && lc <= lower(hi)
+	}
+	return false
+}
+
+func equal(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
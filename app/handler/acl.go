@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"redis/app/acl"
+	"redis/app/resp"
+	"strings"
+)
+
+func handleACL(c *client, args []string) {
+	sub := strings.ToUpper(args[1])
+	switch sub {
+	case "SETUSER":
+		if len(args) < 3 {
+			c.reply(resp.Error(wrongArity("ACL|SETUSER")))
+			return
+		}
+		isCommand := func(name string) bool {
+			_, ok := lookupCommand(name)
+			return ok
+		}
+		if err := acl.SetUser(args[2], args[3:], isCommand); err != nil {
+			c.reply(resp.Error("ERR " + err.Error()))
+			return
+		}
+		c.reply(resp.SimpleString("OK"))
+	case "GETUSER":
+		if len(args) != 3 {
+			c.reply(resp.Error(wrongArity("ACL|GETUSER")))
+			return
+		}
+		u := acl.Get(args[2])
+		if u == nil {
+			c.reply(resp.Null{})
+			return
+		}
+		p := u.Properties()
+		c.reply(resp.Map{
+			{Key: resp.BulkString("flags"), Value: resp.BulkStrings(p.Flags)},
+			{Key: resp.BulkString("passwords"), Value: resp.BulkStrings(p.Passwords)},
+			{Key: resp.BulkString("commands"), Value: resp.BulkString(p.Commands)},
+			{Key: resp.BulkString("keys"), Value: resp.BulkString(p.Keys)},
+		})
+	case "DELUSER":
+		if len(args) < 3 {
+			c.reply(resp.Error(wrongArity("ACL|DELUSER")))
+			return
+		}
+		n, err := acl.Delete(args[2:])
+		if err != nil {
+			c.reply(resp.Error("ERR " + err.Error()))
+			return
+		}
+		// Clients logged in as a deleted user lose their connection, as
+		// in redis-server.
+		deleted := make(map[string]bool)
+		for _, name := range args[2:] {
+			deleted[name] = true
+		}
+		for _, other := range c.srv.clientList() {
+			if deleted[other.user.Name()] && acl.Get(other.user.Name()) == nil {
+				c.kill(other)
+			}
+		}
+		c.reply(resp.Integer(n))
+	case "LIST", "USERS":
+		if len(args) != 2 {
+			c.reply(resp.Error(wrongArity("ACL|" + sub)))
+			return
+		}
+		names := acl.Names()
+		if sub == "LIST" {
+			for i, name := range names {
+				names[i] = acl.Get(name).Describe()
+			}
+		}
+		c.reply(resp.BulkStrings(names))
+	case "WHOAMI":
+		if len(args) != 2 {
+			c.reply(resp.Error(wrongArity("ACL|WHOAMI")))
+			return
+		}
+		c.reply(resp.BulkString(c.user.Name()))
+	case "CAT":
+		if len(args) != 2 {
+			c.reply(resp.Error(wrongArity("ACL|CAT")))
+			return
+		}
+		c.reply(resp.BulkStrings(acl.Categories))
+	default:
+		c.reply(resp.Error(unknownSubcommand("ACL", args[1])))
+	}
+}
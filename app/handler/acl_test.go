@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"redis/app/acl"
+	"redis/app/resp"
+	"testing"
+)
+
+// setUser runs ACL SETUSER and deletes the user again when the test ends,
+// since users outlive the server that made them.
+func setUser(t *testing.T, c *testClient, name string, rules ...string) {
+	t.Helper()
+	t.Cleanup(func() { acl.Delete([]string{name}) })
+	c.expect(ok(), append([]string{"ACL", "SETUSER", name}, rules...)...)
+}
+
+func TestACLPermissions(t *testing.T) {
+	s := newTestServer(t)
+	admin := dial(t, s)
+	setUser(t, admin, "reader", "on", ">pw", "~app:*", "+@read")
+	admin.expect(ok(), "SET", "app:1", "v")
+	admin.expect(ok(), "SET", "other", "v")
+
+	c := dial(t, s)
+	c.expect(ok(), "AUTH", "reader", "pw")
+	c.expect(bulk("v"), "GET", "app:1")
+	for _, tc := range []struct {
+		want resp.Value
+		args []string
+	}{
+		// A command outside the user's categories.
+		{resp.Error("NOPERM User reader has no permissions to run the 'set' command"), []string{"SET", "app:1", "w"}},
+		{resp.Error("NOPERM User reader has no permissions to run the 'del' command"), []string{"DEL", "app:1"}},
+		// A key outside the user's patterns, for a command it may run.
+		{resp.Error("NOPERM No permissions to access a key"), []string{"GET", "other"}},
+		{resp.Error("NOPERM No permissions to access a key"), []string{"LRANGE", "list", "0", "-1"}},
+	} {
+		c.expect(tc.want, tc.args...)
+	}
+	admin.expect(bulk("v"), "GET", "app:1")
+
+	// Changes apply to connections already logged in.
+	admin.expect(ok(), "ACL", "SETUSER", "reader", "+set")
+	c.expect(ok(), "SET", "app:1", "w")
+	admin.expect(ok(), "ACL", "SETUSER", "reader", "-@all")
+	c.expect(resp.Error("NOPERM User reader has no permissions to run the 'get' command"), "GET", "app:1")
+
+	// A disabled user can't log in, whatever the password.
+	admin.expect(ok(), "ACL", "SETUSER", "reader", "off")
+	dial(t, s).expect(resp.Error(errWrongPass), "AUTH", "reader", "pw")
+}
+
+// TestACLGetUser checks ACL GETUSER reports back what ACL SETUSER set.
+func TestACLGetUser(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	setUser(t, c, "alice", "on", ">pw", "~app:*", "~cache:*", "+@read", "-get", "+set")
+	c.expect(resp.Map{
+		{Key: bulk("flags"), Value: resp.BulkStrings([]string{"on"})},
+		{Key: bulk("passwords"), Value: resp.BulkStrings([]string{hashOf("pw")})},
+		{Key: bulk("commands"), Value: bulk("-@all +@read -get +set")},
+		{Key: bulk("keys"), Value: bulk("~app:* ~cache:*")},
+	}, "ACL", "GETUSER", "alice")
+
+	c.expect(ok(), "ACL", "SETUSER", "alice", "reset", "nopass", "allkeys", "allcommands")
+	c.expect(resp.Map{
+		{Key: bulk("flags"), Value: resp.BulkStrings([]string{"off", "nopass"})},
+		{Key: bulk("passwords"), Value: resp.BulkStrings([]string{})},
+		{Key: bulk("commands"), Value: bulk("+@all")},
+		{Key: bulk("keys"), Value: bulk("~*")},
+	}, "ACL", "GETUSER", "alice")
+	c.expect(resp.Null{}, "ACL", "GETUSER", "nobody")
+}
+
+// TestACLSetUserIsAtomic checks a bad rule rejects the whole SETUSER.
+func TestACLSetUserIsAtomic(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	setUser(t, c, "bob", "on", ">pw")
+	for _, tc := range []struct {
+		rule, err string
+	}{
+		{"+@nope", "ERR Error in ACL SETUSER modifier '+@nope': Unknown command category"},
+		{"+nope", "ERR Error in ACL SETUSER modifier '+nope': Unknown command"},
+		{"#abc", "ERR Error in ACL SETUSER modifier '#abc': The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters"},
+		{"<other", "ERR Error in ACL SETUSER modifier '<other': no such password"},
+		{"bogus", "ERR Error in ACL SETUSER modifier 'bogus': Syntax error"},
+	} {
+		c.expect(resp.Error(tc.err), "ACL", "SETUSER", "bob", "off", "~*", tc.rule)
+	}
+	c.expect(resp.BulkStrings([]string{
+		"user bob on #" + hashOf("pw") + " resetkeys -@all",
+		"user default on nopass ~* +@all",
+	}), "ACL", "LIST")
+}
+
+func hashOf(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,390 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"redis/app/acl"
+	"redis/app/config"
+	"redis/app/resp"
+	"redis/app/store"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// aof is the append-only file. The store's propagator hands it every
+// change as a command, under the store's lock, and it only buffers them
+// there; the buffer is written out when the command that caused it
+// finishes, before its reply can reach the client, and fsynced according
+// to appendfsync.
+type aof struct {
+	log  *slog.Logger
+	mu   sync.Mutex
+	file *os.File // nil while appendonly is off
+	buf  []byte
+	// unsynced is set once data has been written but not fsynced.
+	unsynced bool
+	// While the file is being rewritten from a snapshot, changes are also
+	// collected in pending, to be applied after the snapshot's.
+	rewriting bool
+	pending   []propagated
+	// size is the file's current size and baseSize its size after the
+	// last rewrite, for auto-aof-rewrite-percentage.
+	size, baseSize int64
+	lastRewriteOK  bool
+}
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+type propagated struct {
+	seq  uint64
+	args []string
+}
+
+// propagate is the store's Propagator. It hands each change to the AOF
+// and to replicas.
+func (s *Server) propagate(seq uint64, args []string) {
+	s.seq.Store(seq)
+	data := resp.AppendCommand(nil, args)
+	a := &s.aof
+	a.mu.Lock()
+	if a.rewriting {
+		a.pending = append(a.pending, propagated{seq, args})
+	}
+	if a.file != nil {
+		a.buf = append(a.buf, data...)
+	}
+	a.mu.Unlock()
+	s.replicate(seq, data)
+}
+
+// flushAOF writes out buffered changes, fsyncing them right away with
+// appendfsync always.
+func (s *Server) flushAOF() {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flush(config.AppendFsync.Load() == "always")
+}
+
+// flush expects the caller to hold mu.
+func (a *aof) flush(sync bool) {
+	if a.file == nil {
+		return
+	}
+	if len(a.buf) > 0 {
+		if _, err := a.file.Write(a.buf); err != nil {
+			a.log.Warn("Error writing to the AOF file", "err", err)
+			return
+		}
+		a.size += int64(len(a.buf))
+		a.buf = a.buf[:0]
+		a.unsynced = true
+	}
+	if sync && a.unsynced {
+		if err := a.file.Sync(); err != nil {
+			a.log.Warn("Error fsyncing the AOF file", "err", err)
+			return
+		}
+		a.unsynced = false
+	}
+}
+
+// aofSyncLoop fsyncs the AOF once a second for appendfsync everysec.
+func (s *Server) aofSyncLoop() {
+	defer s.background.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			a := &s.aof
+			a.mu.Lock()
+			a.flush(config.AppendFsync.Load() == "everysec")
+			a.mu.Unlock()
+		}
+	}
+}
+
+func aofPath() string {
+	return filepath.Join(config.Dir.Load(), config.AppendFilename.Load())
+}
+
+// startAOF turns appending on. The file is first rewritten from a
+// snapshot of the keyspace, so it holds everything even when appendonly
+// was off until now.
+func (s *Server) startAOF() error {
+	s.aof.mu.Lock()
+	on := s.aof.file != nil
+	s.aof.mu.Unlock()
+	if on {
+		return nil
+	}
+	return s.rewriteAOF(true)
+}
+
+// rewriteAOF replaces the AOF with the shortest command stream that
+// recreates the keyspace: the commands for a snapshot followed by the
+// changes made while they were being written. If appending is on, or
+// attach asks for it, appending continues in the new file.
+func (s *Server) rewriteAOF(attach bool) error {
+	if err := s.beginAOFRewrite(); err != nil {
+		return err
+	}
+	return s.finishAOFRewrite(s.db.Snapshot(), attach)
+}
+
+// beginAOFRewrite claims the one rewrite that may run at a time and
+// starts collecting changes for it.
+func (s *Server) beginAOFRewrite() error {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting {
+		return errRewriteInProgress
+	}
+	a.rewriting = true
+	return nil
+}
+
+// finishAOFRewrite writes snap, taken after beginAOFRewrite, and moves it
+// into place with the changes made since it was taken.
+func (s *Server) finishAOFRewrite(snap *store.Snapshot, attach bool) error {
+	a := &s.aof
+	f, tmp, err := writeSnapshotAOF(snap)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pending := a.pending
+	a.rewriting, a.pending = false, nil
+	if err == nil {
+		err = finishAOF(f, tmp, pending, snap.Seq)
+	}
+	a.lastRewriteOK = err == nil
+	if err != nil {
+		return err
+	}
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	a.size, a.baseSize = size, size
+	if a.file == nil && !attach {
+		return f.Close()
+	}
+	if a.file != nil {
+		// Whatever is still buffered for the old file happened after the
+		// snapshot, so it is in the new file already.
+		a.file.Close()
+	}
+	a.file, a.buf, a.unsynced = f, a.buf[:0], false
+	return nil
+}
+
+// bgrewriteAOF runs rewriteAOF in the background.
+func (s *Server) bgrewriteAOF() error {
+	if err := s.beginAOFRewrite(); err != nil {
+		return err
+	}
+	s.log.Info("Background append only file rewriting started")
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		if err := s.finishAOFRewrite(s.db.Snapshot(), false); err != nil {
+			s.log.Warn("Background AOF rewrite failed", "err", err)
+			return
+		}
+		s.log.Info("Background AOF rewrite terminated with success")
+	}()
+	return nil
+}
+
+// rewriteAOFIfNeeded starts a rewrite once the AOF has grown by
+// auto-aof-rewrite-percentage since the last one.
+func (s *Server) rewriteAOFIfNeeded() {
+	a := &s.aof
+	a.mu.Lock()
+	percentage := config.AutoAOFRewritePercentage.Load()
+	due := a.file != nil && !a.rewriting && percentage > 0 &&
+		a.size >= config.AutoAOFRewriteMinSize.Load() &&
+		(a.size-a.baseSize)*100 >= a.baseSize*percentage
+	a.mu.Unlock()
+	if due {
+		s.log.Info("Starting automatic rewriting of AOF", "size", a.size, "base_size", a.baseSize)
+		s.bgrewriteAOF()
+	}
+}
+
+func handleBGRewriteAOF(c *client, _ []string) {
+	if err := c.srv.bgrewriteAOF(); err != nil {
+		c.reply(resp.Error(err.Error()))
+		return
+	}
+	c.reply(resp.SimpleString("Background append only file rewriting started"))
+}
+
+// writeSnapshotAOF writes the commands that recreate snap to a temporary
+// file.
+func writeSnapshotAOF(snap *store.Snapshot) (*os.File, string, error) {
+	tmp := filepath.Join(config.Dir.Load(), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't open the append-only file %s: %w", tmp, err)
+	}
+	w := bufio.NewWriter(f)
+	var cmd []byte
+	for _, args := range snapshotCommands(snap) {
+		cmd = resp.AppendCommand(cmd[:0], args)
+		w.Write(cmd)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, "", fmt.Errorf("error writing the append-only file: %w", err)
+	}
+	return f, tmp, nil
+}
+
+// finishAOF appends the changes made after the snapshot at seq and moves
+// the file into place. The caller holds mu, so no further change can slip
+// in between.
+func finishAOF(f *os.File, tmp string, pending []propagated, seq uint64) error {
+	var buf []byte
+	for _, p := range pending {
+		if p.seq > seq {
+			buf = resp.AppendCommand(buf, p.args)
+		}
+	}
+	_, err := f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, aofPath())
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error writing the append-only file: %w", err)
+	}
+	return nil
+}
+
+// snapshotCommands lists the commands that recreate snap.
+func snapshotCommands(snap *store.Snapshot) [][]string {
+	var cmds [][]string
+	for _, e := range snap.Entries {
+		switch v := e.Value.(type) {
+		case string:
+			cmds = append(cmds, []string{"SET", e.Key, v})
+		case []string:
+			cmds = append(cmds, append([]string{"RPUSH", e.Key}, v...))
+		}
+		if !e.ExpireAt.IsZero() {
+			cmds = append(cmds, []string{"PEXPIREAT", e.Key, strconv.FormatInt(e.ExpireAt.UnixMilli(), 10)})
+		}
+	}
+	return cmds
+}
+
+// stopAOF turns appending off, writing out what is buffered first.
+func (s *Server) stopAOF() {
+	a := &s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	a.flush(true)
+	a.file.Close()
+	a.file, a.buf = nil, nil
+}
+
+// loadAOF replays the append-only file through the command table. A
+// command cut short at the end of the file, as a crash mid-write leaves
+// behind, is dropped and truncated away if aof-load-truncated allows it.
+// Any command replying with an error fails the load, since the file only
+// holds commands that succeeded when they were first run.
+func (s *Server) loadAOF(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	counter := &countingReader{r: f}
+	reader := bufio.NewReader(counter)
+	c := s.replayClient()
+	requests := newRequestReader(reader)
+	var valid int64
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		args, err := requests.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			if !config.AOFLoadTruncated.Load() {
+				return errors.New("Unexpected end of file reading the append only file. You can: 1) Make a backup of your AOF file, then use ./redis-check-aof --fix <filename>. 2) Alternatively you can set the 'aof-load-truncated' configuration option to yes and restart the server.")
+			}
+			s.log.Warn("Short read while loading the AOF file", "path", path)
+			if err := f.Truncate(valid); err != nil {
+				return fmt.Errorf("error truncating the AOF file: %w", err)
+			}
+			s.log.Warn("AOF loaded anyway because aof-load-truncated is enabled", "valid_bytes", valid)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Bad file format reading the append only file: %w", err)
+		}
+		if len(args) > 0 {
+			if _, ok := c.lookup(args[0]); !ok {
+				return fmt.Errorf("Unknown command '%s' reading the append only file", args[0])
+			}
+			c.errReply = ""
+			c.dispatch(args)
+			if c.errReply != "" {
+				return fmt.Errorf("Error replaying '%s' from the append only file: %s", args[0], c.errReply)
+			}
+		}
+		valid = counter.n - int64(reader.Buffered())
+	}
+	s.log.Info("DB loaded from append only file", "seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// replayClient is a connectionless client, logged in as the default user,
+// whose replies go nowhere.
+func (s *Server) replayClient() *client {
+	return &client{
+		out:           resp.NewEncoder(io.Discard),
+		db:            s.db,
+		srv:           s,
+		log:           s.log.With("client", "aof"),
+		createdAt:     time.Now(),
+		lastActive:    time.Now(),
+		user:          acl.Get(acl.DefaultUser),
+		authenticated: true,
+		replay:        true,
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
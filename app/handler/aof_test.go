@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// aofServer starts a server with appendonly on in dir, replaying the AOF
+// there if there is one, and returns LoadData's error if it fails.
+func aofServer(t *testing.T, dir string) (*Server, error) {
+	t.Helper()
+	s := newUnstartedServer(t, clock.Real)
+	setConfig(t, "appendonly", "yes", "appendfsync", "always", "dir", dir)
+	if err := s.LoadData(); err != nil {
+		s.CloseListeners()
+		return nil, err
+	}
+	serve(t, s)
+	return s, nil
+}
+
+func mustAOFServer(t *testing.T, dir string) *Server {
+	t.Helper()
+	s, err := aofServer(t, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// writeAOF writes commands to the AOF in dir, followed by tail as is.
+func writeAOF(t *testing.T, dir string, commands [][]string, tail string) string {
+	t.Helper()
+	var data []byte
+	for _, args := range commands {
+		data = resp.AppendCommand(data, args)
+	}
+	path := filepath.Join(dir, config.AppendFilename.Load())
+	if err := os.WriteFile(path, append(data, tail...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestAOFReplay writes through one server and checks a second one loading
+// the same AOF ends up with the same keyspace.
+func TestAOFReplay(t *testing.T) {
+	dir := t.TempDir()
+	c := dial(t, mustAOFServer(t, dir))
+	c.expect(ok(), "SET", "s", "hello")
+	c.expect(resp.Integer(11), "APPEND", "s", " world")
+	c.expect(resp.Integer(11), "SETRANGE", "s", "0", "H")
+	c.expect(resp.Integer(4), "RPUSH", "l", "a", "b", "c", "d")
+	c.expect(resp.Integer(5), "LPUSH", "l", "z")
+	c.expect(bulk("z"), "LPOP", "l")
+	c.expect(bulk("a"), "LPOP", "l")
+	c.expect(ok(), "SET", "gone", "v")
+	c.expect(resp.Integer(1), "DEL", "gone")
+	c.expect(ok(), "SET", "unlinked", "v")
+	c.expect(resp.Integer(1), "UNLINK", "unlinked")
+	c.expect(ok(), "SET", "ttl", "v", "EX", "1000")
+	c.expect(ok(), "SET", "kept", "v", "EX", "1000")
+	c.expect(resp.Integer(1), "PERSIST", "kept")
+
+	c = dial(t, mustAOFServer(t, dir))
+	c.expect(bulk("Hello world"), "GET", "s")
+	c.expect(resp.BulkStrings([]string{"b", "c", "d"}), "LRANGE", "l", "0", "-1")
+	c.expect(resp.Null{}, "GET", "gone")
+	c.expect(resp.Null{}, "GET", "unlinked")
+	c.expect(resp.Integer(-1), "TTL", "kept")
+	if ttl, _ := c.do("TTL", "ttl").(resp.Integer); ttl < 990 || ttl > 1000 {
+		t.Errorf("TTL after replay is %d, want about 1000", ttl)
+	}
+}
+
+// TestAOFReplayIgnoresLimits loads an AOF under limits a client's writes
+// would hit: no memory to spare, failing saves and replica mode. Replay
+// must apply every command regardless and evict nothing.
+func TestAOFReplayIgnoresLimits(t *testing.T) {
+	commands := [][]string{{"SET", "a", "1"}, {"RPUSH", "l", "x", "y"}, {"SET", "b", strings.Repeat("x", 1000)}, {"DEL", "a"}}
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, s *Server)
+	}{
+		{"noeviction", func(t *testing.T, s *Server) {
+			setConfig(t, "maxmemory", "1", "maxmemory-policy", "noeviction")
+		}},
+		{"allkeys-lru", func(t *testing.T, s *Server) {
+			setConfig(t, "maxmemory", "1", "maxmemory-policy", "allkeys-lru")
+		}},
+		{"failing saves", func(t *testing.T, s *Server) {
+			setConfig(t, "save", "3600 1", "stop-writes-on-bgsave-error", "yes")
+			s.lastBgsaveOK.Store(false)
+		}},
+		{"replica", func(t *testing.T, s *Server) {
+			s.replicaOf.Store("127.0.0.1 1")
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeAOF(t, dir, commands, "")
+			s := newUnstartedServer(t, clock.Real)
+			t.Cleanup(s.CloseListeners)
+			setConfig(t, "appendonly", "yes", "dir", dir)
+			tc.setup(t, s)
+			if err := s.LoadData(); err != nil {
+				t.Fatal(err)
+			}
+			if n := s.db.Len(); n != 2 {
+				t.Errorf("%d keys after replay, want 2", n)
+			}
+			if n := s.db.Stats().EvictedKeys; n != 0 {
+				t.Errorf("%d keys evicted during replay", n)
+			}
+		})
+	}
+}
+
+// TestAOFReplayFailsOnError checks a command that fails during replay
+// fails the load instead of being skipped.
+func TestAOFReplayFailsOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeAOF(t, dir, [][]string{{"RPUSH", "l", "x"}, {"APPEND", "l", "y"}, {"SET", "after", "v"}}, "")
+	_, err := aofServer(t, dir)
+	if err == nil || !strings.Contains(err.Error(), "'APPEND'") || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("got %v, want APPEND's WRONGTYPE error", err)
+	}
+}
+
+// TestAOFTruncatedTail loads an AOF whose last command was cut short.
+// With aof-load-truncated yes it is dropped and cut from the file;
+// with no the load fails.
+func TestAOFTruncatedTail(t *testing.T) {
+	commands := [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}}
+	full := len(resp.AppendCommand(resp.AppendCommand(nil, commands[0]), commands[1]))
+	const tail = "*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$1"
+
+	dir := t.TempDir()
+	path := writeAOF(t, dir, commands, tail)
+	setConfig(t, "aof-load-truncated", "no")
+	if _, err := aofServer(t, dir); err == nil || !strings.Contains(err.Error(), "Unexpected end of file") {
+		t.Errorf("with aof-load-truncated no: got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(full+len(tail)) {
+		t.Errorf("the failed load changed the file: %v, %v", info.Size(), err)
+	}
+
+	setConfig(t, "aof-load-truncated", "yes")
+	c := dial(t, mustAOFServer(t, dir))
+	c.expect(bulk("1"), "GET", "a")
+	c.expect(bulk("2"), "GET", "b")
+	c.expect(resp.Null{}, "GET", "c")
+	c.expect(ok(), "SET", "d", "4")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), string(resp.AppendCommand(resp.AppendCommand(nil, commands[0]), commands[1]))) ||
+		string(data[full:]) != string(resp.AppendCommand(nil, []string{"SET", "d", "4"})) {
+		t.Errorf("after the load the file is %q, want the partial command cut and SET d appended", data)
+	}
+}
+
+// TestAOFSkipsNoOpWrites checks write commands that change nothing leave
+// the AOF alone.
+func TestAOFSkipsNoOpWrites(t *testing.T) {
+	dir := t.TempDir()
+	c := dial(t, mustAOFServer(t, dir))
+	c.expect(ok(), "SET", "s", "v")
+	c.expect(resp.Integer(1), "RPUSH", "l", "x")
+	path := filepath.Join(dir, config.AppendFilename.Load())
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.Integer(0), "DEL", "missing")
+	c.expect(resp.Null{}, "LPOP", "missing")
+	c.expect(resp.Integer(0), "EXPIRE", "missing", "10")
+	c.expect(resp.Integer(0), "PERSIST", "s")
+	c.expect(resp.Integer(0), "UNLINK", "missing")
+	c.expect(resp.Integer(0), "PEXPIRE", "missing", "10")
+	c.expect(resp.Integer(0), "SETRANGE", "missing", "0", "")
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("no-op writes appended %q", after[len(before):])
+	}
+}
+
+// TestAOFRewriteKeepsLateWrites makes writes after the rewrite's snapshot
+// is taken. They must be in the new file exactly once: lost, the lists
+// come up short; doubled, they repeat an element.
+func TestAOFRewriteKeepsLateWrites(t *testing.T) {
+	dir := t.TempDir()
+	s := mustAOFServer(t, dir)
+	c := dial(t, s)
+	c.expect(resp.Integer(1), "RPUSH", "l", "1")
+	if err := s.beginAOFRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.Integer(2), "RPUSH", "l", "2")
+	snap := s.db.Snapshot()
+	c.expect(resp.Integer(3), "RPUSH", "l", "3")
+	c.expect(ok(), "SET", "late", "v")
+	if err := s.finishAOFRewrite(snap, false); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.Integer(4), "RPUSH", "l", "4")
+
+	c = dial(t, mustAOFServer(t, dir))
+	c.expect(resp.BulkStrings([]string{"1", "2", "3", "4"}), "LRANGE", "l", "0", "-1")
+	c.expect(bulk("v"), "GET", "late")
+}
+
+// TestAOFRewriteUnderLoad rewrites the AOF again and again while writers
+// push to their own lists, then replays it and checks each list holds
+// what its writer pushed.
+func TestAOFRewriteUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	s := mustAOFServer(t, dir)
+	const writers = 4
+	var stop atomic.Bool
+	var writes atomic.Int64
+	pushed := make([]int, writers)
+	var wg sync.WaitGroup
+	for w := range writers {
+		c := dial(t, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; !stop.Load(); i++ {
+				if _, err := c.try("RPUSH", fmt.Sprint("l:", w), strconv.Itoa(i)); err != nil {
+					t.Error(err)
+					return
+				}
+				pushed[w] = i
+				writes.Add(1)
+			}
+		}()
+	}
+
+	c := dial(t, s)
+	for i := range 5 {
+		eventually(t, "the writers to get going", func() bool { return writes.Load() >= int64(200*(i+1)) })
+		c.expect(resp.SimpleString("Background append only file rewriting started"), "BGREWRITEAOF")
+		eventually(t, "the rewrite to finish", func() bool { return c.info("persistence", "aof_rewrite_in_progress") == "0" })
+		if status := c.info("persistence", "aof_last_bgrewrite_status"); status != "ok" {
+			t.Fatalf("aof_last_bgrewrite_status:%s", status)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	c = dial(t, mustAOFServer(t, dir))
+	for w := range writers {
+		want := make([]string, pushed[w])
+		for i := range want {
+			want[i] = strconv.Itoa(i + 1)
+		}
+		got, _ := c.do("LRANGE", fmt.Sprint("l:", w), "0", "-1").(resp.Array)
+		if !sameValue(got, resp.BulkStrings(want)) {
+			t.Errorf("writer %d pushed 1 to %d, the replayed list has %d elements: %.200s", w, pushed[w], len(got), show(got))
+		}
+	}
+}
+
+// TestBGRewriteAOFWhileRewriting checks only one rewrite runs at a time.
+func TestBGRewriteAOFWhileRewriting(t *testing.T) {
+	s := mustAOFServer(t, t.TempDir())
+	c := dial(t, s)
+	if err := s.beginAOFRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.Error(errRewriteInProgress.Error()), "BGREWRITEAOF")
+	if got := c.info("persistence", "aof_rewrite_in_progress"); got != "1" {
+		t.Errorf("aof_rewrite_in_progress:%s during a rewrite", got)
+	}
+	c.expect(ok(), "SET", "k", "v")
+	if err := s.finishAOFRewrite(s.db.Snapshot(), false); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.SimpleString("Background append only file rewriting started"), "BGREWRITEAOF")
+	eventually(t, "the rewrite to finish", func() bool { return c.info("persistence", "aof_rewrite_in_progress") == "0" })
+}
+
+// TestAutoAOFRewrite grows the AOF past auto-aof-rewrite-min-size, first
+// with auto-aof-rewrite-percentage 0, which turns automatic rewrites off,
+// then with 100.
+func TestAutoAOFRewrite(t *testing.T) {
+	setConfig(t, "auto-aof-rewrite-percentage", "0", "auto-aof-rewrite-min-size", "4kb")
+	s := mustAOFServer(t, t.TempDir())
+	c := dial(t, s)
+	value := strings.Repeat("v", 100)
+	for range 100 {
+		c.expect(ok(), "SET", "k", value)
+	}
+	size, _ := strconv.Atoi(c.info("persistence", "aof_current_size"))
+	if size < 10000 {
+		t.Fatalf("aof_current_size:%d after 100 SETs", size)
+	}
+	time.Sleep(3 * time.Second / serverHz)
+	if base := c.info("persistence", "aof_base_size"); base != "0" {
+		t.Fatalf("aof_base_size:%s, rewritten with auto-aof-rewrite-percentage 0", base)
+	}
+
+	c.expect(ok(), "CONFIG", "SET", "auto-aof-rewrite-percentage", "100")
+	eventually(t, "an automatic rewrite", func() bool { return c.info("persistence", "aof_base_size") != "0" })
+	if size, _ := strconv.Atoi(c.info("persistence", "aof_current_size")); size > 1000 {
+		t.Errorf("aof_current_size:%d after the rewrite, want one SET's worth", size)
+	}
+	c.expect(bulk(value), "GET", "k")
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"redis/app/acl"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+)
+
+const (
+	errNoAuth      = "NOAUTH Authentication required."
+	errWrongPass   = "WRONGPASS invalid username-password pair or user is disabled."
+	errNoPassword  = "ERR Client sent AUTH, but no password is set"
+	errHelloNoAuth = "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"
+	errNoProto     = "NOPROTO unsupported protocol version"
+)
+
+// login authenticates the connection as user, reporting whether the
+// credentials were valid.
+func (c *client) login(user, password string) bool {
+	u, ok := acl.Authenticate(user, password)
+	if !ok {
+		return false
+	}
+	c.user = u
+	c.authenticated = true
+	return true
+}
+
+func handleAuth(c *client, args []string) {
+	if len(args) > 3 {
+		c.reply(resp.Error(syntaxError()))
+		return
+	}
+	user, password := acl.DefaultUser, args[1]
+	if len(args) == 3 {
+		user, password = args[1], args[2]
+	} else if acl.Get(acl.DefaultUser).NoPass() {
+		c.reply(resp.Error(errNoPassword))
+		return
+	}
+	if !c.login(user, password) {
+		c.reply(resp.Error(errWrongPass))
+		return
+	}
+	c.reply(resp.SimpleString("OK"))
+}
+
+// handleHello implements HELLO [protover [AUTH user pass] [SETNAME name]].
+func handleHello(c *client, args []string) {
+	proto := c.out.Proto
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.reply(resp.Error("ERR Protocol version is not an integer or out of range"))
+			return
+		}
+		if n != 2 && n != 3 {
+			c.reply(resp.Error(errNoProto))
+			return
+		}
+		proto = n
+	}
+
+	var user, password, name string
+	var hasAuth, hasName bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && i+2 < len(args):
+			user, password, hasAuth = args[i+1], args[i+2], true
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			name, hasName = args[i+1], true
+			i++
+		default:
+			c.reply(resp.Error("ERR Syntax error in HELLO option '" + args[i] + "'"))
+			return
+		}
+	}
+
+	if hasAuth {
+		if !c.login(user, password) {
+			c.reply(resp.Error(errWrongPass))
+			return
+		}
+	}
+	if !c.authenticated {
+		c.reply(resp.Error(errHelloNoAuth))
+		return
+	}
+	if hasName {
+		if !validClientName(name) {
+			c.reply(resp.Error(errClientName))
+			return
+		}
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+
+	c.out.Proto = proto
+	c.reply(resp.Map{
+		{Key: resp.BulkString("server"), Value: resp.BulkString("redis")},
+		{Key: resp.BulkString("version"), Value: resp.BulkString(redisVersion)},
+		{Key: resp.BulkString("proto"), Value: resp.Integer(proto)},
+		{Key: resp.BulkString("id"), Value: resp.Integer(c.id)},
+		{Key: resp.BulkString("mode"), Value: resp.BulkString("standalone")},
+		{Key: resp.BulkString("role"), Value: resp.BulkString("master")},
+		{Key: resp.BulkString("modules"), Value: resp.Array{}},
+	})
+}
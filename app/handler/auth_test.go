@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"testing"
+)
+
+// TestAuth checks that with requirepass set a new connection can run
+// nothing but AUTH and friends until it authenticates, and that a wrong
+// password leaves it unauthenticated.
+func TestAuth(t *testing.T) {
+	setConfig(t, "requirepass", "secret")
+	s := newTestServer(t)
+	c := dial(t, s)
+	c.expect(resp.Error(errNoAuth), "SET", "k", "v")
+	c.expect(resp.Error(errNoAuth), "GET", "k")
+	c.expect(resp.Error(errWrongPass), "AUTH", "wrong")
+	c.expect(resp.Error(errWrongPass), "AUTH", "nobody", "secret")
+	c.expect(resp.Error(errNoAuth), "GET", "k")
+	c.expect(resp.Error(syntaxError()), "AUTH", "default", "secret", "extra")
+	c.expect(ok(), "AUTH", "secret")
+	c.expect(resp.Null{}, "GET", "k") // the SET sent before AUTH didn't run
+	c.expect(ok(), "SET", "k", "v")
+
+	// The two-argument form logs in as the default user too.
+	c = dial(t, s)
+	c.expect(ok(), "AUTH", "default", "secret")
+	c.expect(bulk("v"), "GET", "k")
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Error(errNoPassword), "AUTH", "anything")
+	// With a username the default user's nopass accepts any password.
+	c.expect(ok(), "AUTH", "default", "anything")
+}
+
+// TestHelloAuth checks HELLO refuses an unauthenticated connection unless
+// it carries AUTH, and that a failed AUTH leaves the protocol as it was.
+func TestHelloAuth(t *testing.T) {
+	setConfig(t, "requirepass", "secret")
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Error(errHelloNoAuth), "HELLO", "3")
+	c.expect(resp.Error(errWrongPass), "HELLO", "3", "AUTH", "default", "wrong")
+	c.expect(resp.Error(errNoAuth), "GET", "k")
+	if reply := c.do("HELLO", "3", "AUTH", "default", "secret"); !isMap(reply) {
+		t.Fatalf("HELLO 3 AUTH = %s, want a map", show(reply))
+	}
+	c.expect(resp.Null{}, "GET", "k")
+}
+
+// TestRequirepassAtRuntime checks that setting requirepass leaves
+// connections already logged in alone but applies to new ones.
+func TestRequirepassAtRuntime(t *testing.T) {
+	setConfig(t, "requirepass", "")
+	s := newTestServer(t)
+	before := dial(t, s)
+	before.expect(ok(), "CONFIG", "SET", "requirepass", "secret")
+	before.expect(ok(), "SET", "k", "v")
+	after := dial(t, s)
+	after.expect(resp.Error(errNoAuth), "GET", "k")
+	after.expect(ok(), "AUTH", "secret")
+	after.expect(bulk("v"), "GET", "k")
+}
+
+func isMap(v resp.Value) bool {
+	_, ok := v.(resp.Map)
+	return ok
+}
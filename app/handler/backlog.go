@@ -0,0 +1,51 @@
+package handler
+
+// backlog keeps the latest part of the replication stream, so that a
+// replica whose link dropped can pick up where it was instead of syncing
+// from a snapshot again. It is a ring: once full, each write overwrites
+// the oldest bytes.
+type backlog struct {
+	buf []byte
+	// next is where the next byte goes in buf and held how many of buf's
+	// bytes are part of the stream yet.
+	next, held int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{buf: make([]byte, size)}
+}
+
+// write adds data to the end of the stream.
+func (b *backlog) write(data []byte) {
+	if len(data) >= len(b.buf) {
+		copy(b.buf, data[len(data)-len(b.buf):])
+		b.next, b.held = 0, len(b.buf)
+		return
+	}
+	n := copy(b.buf[b.next:], data)
+	copy(b.buf, data[n:])
+	b.next = (b.next + len(data)) % len(b.buf)
+	b.held = min(b.held+len(data), len(b.buf))
+}
+
+// last returns a copy of the last n bytes of the stream, or false if the
+// backlog doesn't hold that many.
+func (b *backlog) last(n int) ([]byte, bool) {
+	if n > b.held {
+		return nil, false
+	}
+	out := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	copied := copy(out, b.buf[start:])
+	copy(out[copied:], b.buf)
+	return out, true
+}
+
+// resized returns a backlog of size bytes holding as much of the end of
+// b's stream as fits.
+func (b *backlog) resized(size int) *backlog {
+	data, _ := b.last(min(b.held, size))
+	nb := newBacklog(size)
+	nb.write(data)
+	return nb
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"redis/app/resp"
+	"redis/app/store"
+	"strconv"
+	"time"
+)
+
+func handleBLPop(c *client, args []string) {
+	key := args[1]
+	timeout, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		c.reply(resp.Error(invalidTimeout()))
+		return
+	}
+	// ParseFloat accepts inf and nan, and a huge timeout would overflow
+	// the conversion to a Duration.
+	if math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		c.reply(resp.Error(timeoutOutOfRange()))
+		return
+	}
+	if timeout < 0 {
+		c.reply(resp.Error(negativeTimeout()))
+		return
+	}
+	if timeout > float64(math.MaxInt64)/float64(time.Second) {
+		c.reply(resp.Error(timeoutOutOfRange()))
+		return
+	}
+
+	value, req, err := c.db.PopOrWait(key, time.Duration(timeout*float64(time.Second)))
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	if req == nil {
+		replyBLPop(c, key, value)
+		return
+	}
+	if c.script {
+		// Scripts can't block, so they find the timeout already reached.
+		c.db.CancelWait(req)
+		c.reply(resp.NullArray{})
+		return
+	}
+
+	// Replies to commands pipelined ahead of this one must not be held
+	// back for as long as we block.
+	c.out.Flush()
+	c.waiting = req
+	c.blocked.Store(true)
+	c.mu.Lock()
+	c.blockedOn = key
+	c.mu.Unlock()
+	parkedAt := time.Now()
+	defer func() {
+		c.blocked.Store(false)
+		c.blockedTime += time.Since(parkedAt)
+		// An unblock sent as we were leaving must not end the next
+		// blocking pop early.
+		c.mu.Lock()
+		c.blockedOn = ""
+		select {
+		case <-c.unblock:
+		default:
+		}
+		c.mu.Unlock()
+	}()
+	gone, stopWatching := watchDisconnect(c.conn, c.reader)
+
+	// A nil timer channel never fires, which is what timeout 0 means.
+	var timer <-chan time.Time
+	if req.Timeout > 0 {
+		timer = c.srv.clock.After(req.Timeout)
+	}
+	var served, unblockedWithError bool
+	select {
+	case value = <-req.Ch:
+		served = true
+	case <-timer:
+		// A pusher may have dequeued us, and so already sent an element,
+		// in the window between the timer firing and taking the lock.
+		value, served = c.db.CancelWait(req)
+	case unblockedWithError = <-c.unblock:
+		value, served = c.db.CancelWait(req)
+	case <-gone:
+		// The connection is done for; its teardown withdraws the wait.
+		stopWatching()
+		return
+	case <-c.srv.closing:
+		stopWatching()
+		return
+	}
+	c.waiting = nil
+	// The watcher may be flushing from its own goroutine until stopped.
+	stopWatching()
+	switch {
+	case served:
+		replyBLPop(c, key, value)
+	case unblockedWithError:
+		c.reply(resp.Error(errUnblocked))
+	default:
+		// A timeout is a null array, not a null bulk string: the reply
+		// is an array when there is one.
+		c.reply(resp.NullArray{})
+	}
+}
+
+const errUnblocked = "UNBLOCKED client unblocked via CLIENT UNBLOCK"
+
+// wake ends the blocking pop c is parked in, as if it timed out or with
+// an UNBLOCKED error, and reports whether it was parked in one.
+func (c *client) wake(withError bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blockedOn == "" {
+		return false
+	}
+	select {
+	case c.unblock <- withError:
+	default:
+		// Already woken and on its way out.
+	}
+	return true
+}
+
+// abandonWait withdraws the blocked pop of a client that won't get a
+// reply.
+func (c *client) abandonWait() {
+	req := c.waiting
+	if req == nil {
+		return
+	}
+	c.waiting = nil
+	// Withdrawing and giving back are one step, so no other client sees
+	// the list without the element in between.
+	c.db.Atomic(func(tx store.Store) {
+		if value, ok := tx.CancelWait(req); ok {
+			// Nobody is left to read this element; give it back.
+			tx.LPush(req.Key, value)
+		}
+	})
+}
+
+func replyBLPop(c *client, key, value string) {
+	c.reply(resp.BulkStrings([]string{key, value}))
+}
+
+// watchDisconnect reports on gone when the client hangs up while blocked.
+// It peeks rather than reads, so any pipelined command that arrives stays
+// buffered for the connection loop. stop must be called before the reader
+// is used again; it interrupts the pending peek and waits for it to return.
+// Blocked clients are exempt from the idle timeout, so the peek itself has
+// no deadline.
+func watchDisconnect(conn net.Conn, reader *bufio.Reader) (<-chan struct{}, func()) {
+	conn.SetReadDeadline(time.Time{})
+	gone := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(gone)
+		}
+	}()
+	stop := func() {
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+	return gone, stop
+}
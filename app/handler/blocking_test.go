@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"redis/app/clock"
+	"redis/app/resp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitBlocked waits until n clients are parked in blocking pops.
+func waitBlocked(t *testing.T, s *Server, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.db.Stats().BlockedClients != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked, want %d", s.db.Stats().BlockedClients, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockedClients connects n clients and parks each in BLPOP key 0, in
+// order.
+func blockedClients(t *testing.T, s *Server, key string, n int) []*testClient {
+	t.Helper()
+	var waiters []*testClient
+	for i := 0; i < n; i++ {
+		c := dial(t, s)
+		if err := c.send("BLPOP", key, "0"); err != nil {
+			t.Fatal(err)
+		}
+		waitBlocked(t, s, int64(i+1))
+		waiters = append(waiters, c)
+	}
+	return waiters
+}
+
+func blpopReply(key, value string) resp.Value {
+	return resp.Array{bulk(key), bulk(value)}
+}
+
+func TestPushServesEveryBlockedClient(t *testing.T) {
+	s := newTestServer(t)
+	waiters := blockedClients(t, s, "k", 3)
+	c := dial(t, s)
+	c.expect(resp.Integer(0), "RPUSH", "k", "a", "b", "c")
+	// Served in the order they blocked, one element each.
+	for i, want := range []string{"a", "b", "c"} {
+		got, err := waiters[i].read()
+		if err != nil {
+			t.Fatalf("waiter %d: %v", i, err)
+		}
+		if !sameValue(got, blpopReply("k", want)) {
+			t.Errorf("waiter %d got %s, want %s", i, show(got), show(blpopReply("k", want)))
+		}
+	}
+	c.expect(resp.Integer(0), "LLEN", "k")
+}
+
+func TestDisconnectedWaiterIsPruned(t *testing.T) {
+	s := newTestServer(t)
+	gone := blockedClients(t, s, "k", 1)[0]
+	gone.conn.Close()
+	waitBlocked(t, s, 0)
+
+	c := dial(t, s)
+	c.expect(resp.Integer(1), "RPUSH", "k", "a")
+	c.expect(resp.StringArray{"a"}, "LRANGE", "k", "0", "-1")
+}
+
+func TestWaiterLeavingAfterItWasServed(t *testing.T) {
+	s := newTestServer(t)
+	waiters := blockedClients(t, s, "k", 2)
+	// The first waiter hangs up; the push must go to the second one.
+	waiters[0].conn.Close()
+	waitBlocked(t, s, 1)
+	c := dial(t, s)
+	c.expect(resp.Integer(0), "RPUSH", "k", "a")
+	got, err := waiters[1].read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameValue(got, blpopReply("k", "a")) {
+		t.Errorf("got %s", show(got))
+	}
+}
+
+func TestPushWithFewerElementsThanWaiters(t *testing.T) {
+	s := newTestServer(t)
+	waiters := blockedClients(t, s, "q", 3)
+	c := dial(t, s)
+	c.expect(resp.Integer(0), "RPUSH", "q", "a", "b")
+	for i, want := range []string{"a", "b"} {
+		got, err := waiters[i].read()
+		if err != nil {
+			t.Fatalf("waiter %d: %v", i, err)
+		}
+		if !sameValue(got, blpopReply("q", want)) {
+			t.Errorf("waiter %d got %s, want %s", i, show(got), show(blpopReply("q", want)))
+		}
+	}
+	// The third is still waiting, on an empty list.
+	waitBlocked(t, s, 1)
+	c.expect(resp.Integer(0), "LLEN", "q")
+	c.expect(resp.Integer(0), "LPUSH", "q", "c")
+	if got, err := waiters[2].read(); err != nil || !sameValue(got, blpopReply("q", "c")) {
+		t.Errorf("waiter 2 got %v, %v", got, err)
+	}
+}
+
+// TestBLPOPTimeoutOnManualClock gives BLPOP a 1000 second timeout, which
+// the test's clock reaches at once.
+func TestBLPOPTimeoutOnManualClock(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	s := newTestServerClock(t, clk)
+	c := dial(t, s)
+	if err := c.send("BLPOP", "q", "1000"); err != nil {
+		t.Fatal(err)
+	}
+	waitBlocked(t, s, 1)
+	clk.Advance(999 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if n := s.db.Stats().BlockedClients; n != 1 {
+		t.Fatal("BLPOP gave up before its timeout")
+	}
+	clk.Advance(time.Second)
+	if got, err := c.read(); err != nil || !sameValue(got, resp.NullArray{}) {
+		t.Errorf("BLPOP = %v, %v, want a null array", got, err)
+	}
+	waitBlocked(t, s, 0)
+}
+
+func TestBLPOPTimeoutOutOfRange(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	for _, timeout := range []string{"1e12", "9223372037", "inf", "-inf", "+Inf", "nan", "NaN"} {
+		c.expect(resp.Error(timeoutOutOfRange()), "BLPOP", "q", timeout)
+	}
+	c.expect(resp.Error(negativeTimeout()), "BLPOP", "q", "-1")
+	// The longest timeout that fits in a Duration is accepted.
+	c.expect(resp.Integer(1), "RPUSH", "q", "a")
+	c.expect(blpopReply("q", "a"), "BLPOP", "q", "9223372036")
+}
+
+// TestClientUnblock checks CLIENT UNBLOCK ends a BLPOP with the reply of a
+// timeout or with an UNBLOCKED error, and leaves the client free to block
+// again.
+func TestClientUnblock(t *testing.T) {
+	s := newTestServer(t)
+	c := dial(t, s)
+	for _, tc := range []struct {
+		reason []string
+		want   resp.Value
+	}{
+		{nil, resp.NullArray{}},
+		{[]string{"TIMEOUT"}, resp.NullArray{}},
+		{[]string{"error"}, resp.Error(errUnblocked)},
+		{[]string{"ERROR"}, resp.Error(errUnblocked)},
+	} {
+		w := dial(t, s)
+		id, _ := w.do("CLIENT", "ID").(resp.Integer)
+		unblock := append([]string{"CLIENT", "UNBLOCK", strconv.FormatInt(int64(id), 10)}, tc.reason...)
+		c.expect(resp.Integer(0), unblock...)
+
+		if err := w.send("BLPOP", "k", "0"); err != nil {
+			t.Fatal(err)
+		}
+		waitBlocked(t, s, 1)
+		c.expect(resp.Integer(1), unblock...)
+		if got, err := w.read(); err != nil || !sameValue(got, tc.want) {
+			t.Errorf("%v: BLPOP got %s, %v, want %s", tc.reason, show(got), err, show(tc.want))
+		}
+		waitBlocked(t, s, 0)
+		c.expect(resp.Integer(0), unblock...)
+
+		// The next BLPOP waits for a push as usual.
+		if err := w.send("BLPOP", "k", "0"); err != nil {
+			t.Fatal(err)
+		}
+		waitBlocked(t, s, 1)
+		c.expect(resp.Integer(0), "RPUSH", "k", "v")
+		if got, err := w.read(); err != nil || !sameValue(got, blpopReply("k", "v")) {
+			t.Errorf("%v: the next BLPOP got %s, %v", tc.reason, show(got), err)
+		}
+	}
+
+	c.expect(resp.Integer(0), "CLIENT", "UNBLOCK", "999999")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"CLIENT", "UNBLOCK", "x"}, notAnInteger()},
+		{[]string{"CLIENT", "UNBLOCK", "1", "LATER"}, "ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR"},
+		{[]string{"CLIENT", "UNBLOCK", "1", "TIMEOUT", "x"}, wrongArity("CLIENT|UNBLOCK")},
+	} {
+		c.expect(resp.Error(tc.want), tc.args...)
+	}
+}
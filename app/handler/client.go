@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/acl"
+	"redis/app/resp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const errClientName = "ERR Client names cannot contain spaces, newlines or special characters."
+
+// clientSubcommands are CLIENT's; the container adds HELP.
+var clientSubcommands = []*command{
+	{name: "id", handler: handleClientID, arity: 2, flags: flagAdmin | flagFast,
+		help: []string{"Return the ID of the current connection."}},
+	{name: "info", handler: handleClientInfo, arity: 2, flags: flagAdmin | flagFast,
+		help: []string{"Return information about the current client connection."}},
+	{name: "kill", handler: handleClientKill, arity: -3, flags: flagAdmin,
+		usage: "<ip:port> | <option> <value> [<option> <value> [...]]", help: []string{
+			"Kill the connection made from <ip:port>, or the connections matching",
+			"every option given. Options are:",
+			"* ADDR <ip:port>",
+			"  Kill connections made from the specified address.",
+			"* LADDR <ip:port>",
+			"  Kill connections made to the specified local address.",
+			"* ID <client-id>",
+			"  Kill connections by client id.",
+			"* SKIPME (YES|NO)",
+			"  Skip killing current connection (default: yes).",
+		}},
+	{name: "list", handler: handleClientList, arity: -2, flags: flagAdmin,
+		usage: "[options ...]", help: []string{
+			"Return information about client connections. Options:",
+			"* ID <client-id> [<client-id> ...]",
+			"  Return clients with the specified IDs only.",
+		}},
+	{name: "unblock", handler: handleClientUnblock, arity: -3, flags: flagAdmin | flagFast,
+		usage: "<clientid> [TIMEOUT|ERROR]", help: []string{"Unblock the specified blocked client."}},
+	{name: "getname", handler: handleClientGetName, arity: 2, flags: flagAdmin | flagFast,
+		help: []string{"Return the name of the current connection."}},
+	{name: "setname", handler: handleClientSetName, arity: 3, flags: flagAdmin | flagFast,
+		usage: "<name>", help: []string{"Assign the name <name> to the current connection."}},
+}
+
+func handleClientID(c *client, _ []string) {
+	c.reply(resp.Integer(c.id))
+}
+
+func handleClientInfo(c *client, _ []string) {
+	c.reply(resp.BulkString(c.info(time.Now()) + "\n"))
+}
+
+func handleClientGetName(c *client, _ []string) {
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+	if name == "" {
+		c.reply(resp.Null{})
+		return
+	}
+	c.reply(resp.BulkString(name))
+}
+
+func handleClientSetName(c *client, args []string) {
+	if !validClientName(args[2]) {
+		c.reply(resp.Error(errClientName))
+		return
+	}
+	c.mu.Lock()
+	c.name = args[2]
+	c.mu.Unlock()
+	c.reply(resp.SimpleString("OK"))
+}
+
+// handleReset puts the connection back the way it was when it was
+// accepted.
+func handleReset(c *client, _ []string) {
+	c.reset()
+	c.reply(resp.SimpleString("RESET"))
+}
+
+// handleQuit answers OK and closes the connection once the reply is
+// sent. Any arguments are ignored, as redis-server ignores them.
+func handleQuit(c *client, _ []string) {
+	c.reply(resp.SimpleString("OK"))
+	c.closeAfterReply = true
+}
+
+// reset drops the client's per-connection state: its name, protocol
+// version and login.
+func (c *client) reset() {
+	c.mu.Lock()
+	c.name = ""
+	c.mu.Unlock()
+	c.out.Proto = 2
+	c.user = acl.Get(acl.DefaultUser)
+	c.authenticated = !c.user.NeedsAuth()
+}
+
+// validClientName allows what redis-server does: printable ASCII other
+// than space. The empty name clears the current one.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// handleClientList implements CLIENT LIST [ID id ...].
+func handleClientList(c *client, args []string) {
+	var ids map[int64]bool
+	if len(args) > 2 {
+		if strings.ToUpper(args[2]) != "ID" || len(args) == 3 {
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+		ids = make(map[int64]bool)
+		for _, arg := range args[3:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				c.reply(resp.Error("ERR Invalid client ID"))
+				return
+			}
+			ids[id] = true
+		}
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	for _, other := range c.srv.clientList() {
+		if ids != nil && !ids[other.id] {
+			continue
+		}
+		b.WriteString(other.info(now))
+		b.WriteByte('\n')
+	}
+	c.reply(resp.BulkString(b.String()))
+}
+
+// handleClientKill implements both the old CLIENT KILL ip:port form and
+// the filter form, CLIENT KILL [ID id] [ADDR ip:port] [LADDR ip:port]
+// [SKIPME yes|no].
+func handleClientKill(c *client, args []string) {
+	if len(args) == 3 {
+		for _, other := range c.srv.clientList() {
+			if other.conn.RemoteAddr().String() == args[2] {
+				c.kill(other)
+				c.reply(resp.SimpleString("OK"))
+				return
+			}
+		}
+		c.reply(resp.Error("ERR No such client"))
+		return
+	}
+	if len(args) < 3 || len(args)%2 != 0 {
+		c.reply(resp.Error(syntaxError()))
+		return
+	}
+
+	var id int64
+	var addr, laddr string
+	skipMe := true
+	for i := 2; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				c.reply(resp.Error("ERR client-id should be greater than 0"))
+				return
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				c.reply(resp.Error(syntaxError()))
+				return
+			}
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+	}
+
+	killed := 0
+	for _, other := range c.srv.clientList() {
+		switch {
+		case id != 0 && other.id != id,
+			addr != "" && other.conn.RemoteAddr().String() != addr,
+			laddr != "" && other.conn.LocalAddr().String() != laddr,
+			skipMe && other == c:
+			continue
+		}
+		c.kill(other)
+		killed++
+	}
+	c.reply(resp.Integer(killed))
+}
+
+// handleClientUnblock implements CLIENT UNBLOCK id [TIMEOUT|ERROR]. It
+// only wakes blocking pops; a client in WAIT stays put, as in
+// redis-server.
+func handleClientUnblock(c *client, args []string) {
+	if len(args) > 4 {
+		c.reply(resp.Error(wrongArity("CLIENT|UNBLOCK")))
+		return
+	}
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	withError := false
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			c.reply(resp.Error("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR"))
+			return
+		}
+	}
+	for _, other := range c.srv.clientList() {
+		if other.id == id {
+			if other.wake(withError) {
+				c.reply(resp.Integer(1))
+				return
+			}
+			break
+		}
+	}
+	c.reply(resp.Integer(0))
+}
+
+// kill disconnects victim. Closing the socket is enough to wake a victim
+// parked in a blocking command; the current client instead finishes
+// sending its reply first.
+func (c *client) kill(victim *client) {
+	if victim == c {
+		c.closeAfterReply = true
+		return
+	}
+	victim.conn.Close()
+}
+
+// info formats the client the way CLIENT LIST and CLIENT INFO do, with
+// the key a blocked client waits on as blocking-keys.
+func (c *client) info(now time.Time) string {
+	c.mu.Lock()
+	name, lastCmd, lastActive, blockedOn := c.name, c.lastCmd, c.lastActive, c.blockedOn
+	c.mu.Unlock()
+	flags := "N"
+	if c.blocked.Load() {
+		flags = "b"
+	}
+	if lastCmd == "" {
+		lastCmd = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 cmd=%s blocking-keys=%s",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), name,
+		int64(now.Sub(c.createdAt)/time.Second), int64(now.Sub(lastActive)/time.Second),
+		flags, lastCmd, blockedOn)
+}
+
+// clientList returns the connected clients ordered by id.
+func (s *Server) clientList() []*client {
+	s.mu.Lock()
+	list := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		list = append(list, c)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net"
+	"redis/app/resp"
+	"testing"
+	"time"
+)
+
+// TestQuit checks QUIT answers +OK and then the server hangs up, without
+// running anything pipelined after it, even before AUTH.
+func TestQuit(t *testing.T) {
+	setConfig(t, "requirepass", "secret")
+	s := newTestServer(t)
+	for _, quit := range []string{"*1\r\n$4\r\nQUIT\r\n", "*2\r\n$4\r\nquit\r\n$6\r\nignore\r\n"} {
+		c := dial(t, s)
+		if _, err := c.conn.Write([]byte(quit + "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.readRaw(5); string(got) != "+OK\r\n" {
+			t.Errorf("%q got %q", quit, got)
+		}
+		if rest, err := io.ReadAll(c.dec.Reader()); err != nil || len(rest) != 0 {
+			t.Errorf("after %q the server sent %q, %v, want EOF", quit, rest, err)
+		}
+	}
+	c := dial(t, s)
+	c.expect(ok(), "AUTH", "secret")
+	c.expect(resp.Null{}, "GET", "k")
+}
+
+// TestAbruptClose cuts connections off halfway through a command. The
+// server must drop the partial command without answering or running it,
+// and forget the connection.
+func TestAbruptClose(t *testing.T) {
+	s := newTestServer(t)
+	partial := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$10\r\nhalf"
+
+	// Half-closed: the server sees EOF mid-argument and hangs up too.
+	c := dial(t, s)
+	if _, err := c.conn.Write([]byte(partial)); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.(*net.TCPConn).CloseWrite()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if rest, err := io.ReadAll(c.dec.Reader()); err != nil || len(rest) != 0 {
+		t.Errorf("after a half close the server sent %q, %v, want EOF", rest, err)
+	}
+
+	// Closed outright, with the command unfinished.
+	c = dial(t, s)
+	if _, err := c.conn.Write([]byte(partial)); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.Close()
+
+	eventually(t, "the server to drop both connections", func() bool { return s.ConnectedClients() == 0 })
+	c = dial(t, s)
+	c.expect(resp.Null{}, "GET", "k")
+	c.expect(resp.SimpleString("PONG"), "PING")
+	if _, err := c.conn.Write([]byte("*1\r\n$4\r\nPI")); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.(*net.TCPConn).CloseWrite()
+	if _, err := c.read(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
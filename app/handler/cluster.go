@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strconv"
+	"strings"
+)
+
+// We never run in cluster mode, but cluster-aware clients probe with
+// CLUSTER before falling back to a plain connection, so the subcommands
+// they use answer truthfully for a single node that serves no slots.
+
+// clusterSlots is the number of hash slots the keyspace is split into.
+const clusterSlots = 16384
+
+var clusterHelp = []string{
+	"CLUSTER <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"COUNTKEYSINSLOT <slot>",
+	"    Return the number of keys in <slot>.",
+	"INFO",
+	"    Return information about the cluster.",
+	"KEYSLOT <key>",
+	"    Return the hash slot for <key>.",
+	"MYID",
+	"    Return the node id.",
+	"SHARDS",
+	"    Return information about slot range mappings and the nodes serving them.",
+	"SLOTS",
+	"    Return information about slots range mappings.",
+	"HELP",
+	"    Print this help.",
+}
+
+func handleCluster(c *client, args []string) {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "INFO" && len(args) == 2:
+		c.reply(resp.BulkString(clusterInfo()))
+	case sub == "MYID" && len(args) == 2:
+		c.reply(resp.BulkString(c.srv.runID))
+	case (sub == "SLOTS" || sub == "SHARDS") && len(args) == 2:
+		c.reply(resp.Array{})
+	case sub == "KEYSLOT" && len(args) == 3:
+		c.reply(resp.Integer(keySlot(args[2])))
+	case sub == "COUNTKEYSINSLOT" && len(args) == 3:
+		slot, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || slot < 0 || slot >= clusterSlots {
+			c.reply(resp.Error("ERR Invalid slot"))
+			return
+		}
+		n := 0
+		c.db.ForEach(func(key string) bool {
+			if keySlot(key) == int(slot) {
+				n++
+			}
+			return true
+		})
+		c.reply(resp.Integer(n))
+	case sub == "HELP" && len(args) == 2:
+		arr := make(resp.Array, len(clusterHelp))
+		for i, line := range clusterHelp {
+			arr[i] = resp.SimpleString(line)
+		}
+		c.reply(arr)
+	default:
+		c.reply(resp.Error(unknownSubcommand("CLUSTER", args[1])))
+	}
+}
+
+// clusterInfo is CLUSTER INFO's report for a node outside any cluster.
+func clusterInfo() string {
+	var b strings.Builder
+	for _, f := range []infoField{
+		{"cluster_enabled", "0"},
+		{"cluster_state", "ok"},
+		{"cluster_slots_assigned", "0"},
+		{"cluster_slots_ok", "0"},
+		{"cluster_slots_pfail", "0"},
+		{"cluster_slots_fail", "0"},
+		{"cluster_known_nodes", "1"},
+		{"cluster_size", "0"},
+		{"cluster_current_epoch", "0"},
+		{"cluster_my_epoch", "0"},
+	} {
+		b.WriteString(f.name + ":" + f.value + "\r\n")
+	}
+	return b.String()
+}
+
+// keySlot maps key to its hash slot the way cluster clients do: the CRC16
+// of the key, or of the part between the first "{" and the next "}" if
+// that isn't empty, modulo the slot count.
+func keySlot(key string) int {
+	if open := strings.IndexByte(key, '{'); open >= 0 {
+		if n := strings.IndexByte(key[open+1:], '}'); n > 0 {
+			key = key[open+1 : open+1+n]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 is the CCITT/XMODEM variant: polynomial 0x1021, zero initial
+// value, no reflection.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// handleReadOnly serves READONLY and READWRITE, which cluster clients send
+// to choose whether a replica may serve their reads. Outside a cluster
+// there's nothing to choose.
+func handleReadOnly(c *client, args []string) {
+	c.reply(resp.SimpleString("OK"))
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"sort"
+	"strings"
+)
+
+// flagNames gives the name COMMAND reports for each flag, in the order the
+// flag constants are declared.
+var flagNames = []string{"write", "readonly", "denyoom", "blocking", "admin", "pubsub", "fast", "no_auth", "noscript", "stale"}
+
+func handleCommand(c *client, args []string) {
+	if len(args) == 1 {
+		names := commandNames()
+		arr := make(resp.Array, len(names))
+		for i, name := range names {
+			arr[i] = commands[name].info()
+		}
+		c.reply(arr)
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "COUNT":
+		if len(args) != 2 {
+			c.reply(resp.Error(wrongArity("COMMAND|COUNT")))
+			return
+		}
+		c.reply(resp.Integer(len(commandNames())))
+	case "INFO":
+		names := args[2:]
+		if len(names) == 0 {
+			names = commandNames()
+		}
+		arr := make(resp.Array, len(names))
+		for i, name := range names {
+			if cmd, ok := lookupCommand(name); ok {
+				arr[i] = cmd.info()
+			} else {
+				arr[i] = resp.NullArray{}
+			}
+		}
+		c.reply(arr)
+	case "DOCS":
+		names := args[2:]
+		if len(names) == 0 {
+			names = commandNames()
+		}
+		var m resp.Map
+		for _, name := range names {
+			if cmd, ok := lookupCommand(name); ok {
+				m = append(m, resp.KeyValue{Key: resp.BulkString(cmd.name), Value: resp.Map{}})
+			}
+		}
+		c.reply(m)
+	default:
+		c.reply(resp.Error(unknownSubcommand("COMMAND", args[1])))
+	}
+}
+
+// commandNames lists the commands lookupCommand finds, in alphabetical
+// order.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if _, ok := lookupCommand(name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// info describes cmd in COMMAND INFO's reply format: name, arity, flags,
+// first key, last key, step, then ACL categories, tips and key specs,
+// which we don't track and report empty, and the subcommands of a
+// container described the same way.
+func (cmd *command) info() resp.Array {
+	flags := resp.Array{}
+	for i, name := range flagNames {
+		if cmd.flags&(1<<i) != 0 {
+			flags = append(flags, resp.SimpleString(name))
+		}
+	}
+	subcommands := resp.Array{}
+	for _, sub := range cmd.subcommands {
+		subcommands = append(subcommands, sub.info())
+	}
+	return resp.Array{
+		resp.BulkString(cmd.name),
+		resp.Integer(cmd.arity),
+		flags,
+		resp.Integer(cmd.firstKey),
+		resp.Integer(cmd.lastKey),
+		resp.Integer(cmd.step),
+		resp.Array{},
+		resp.Array{},
+		resp.Array{},
+		subcommands,
+	}
+}
@@ -0,0 +1,422 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/config"
+	"redis/app/resp"
+	"strings"
+	"time"
+)
+
+type commandFlag uint32
+
+const (
+	flagWrite    commandFlag = 1 << iota // may modify the keyspace
+	flagReadonly                         // only reads the keyspace
+	flagDenyOOM                          // refused while over maxmemory
+	flagBlocking                         // may park the connection
+	flagAdmin                            // server administration
+	flagPubSub                           // allowed in subscriber mode
+	flagFast                             // O(1) or O(log N)
+	flagNoAuth                           // allowed before AUTH
+	flagNoScript                         // not allowed from scripts
+	flagStale                            // allowed on a replica with its master down
+)
+
+// command describes one entry of the command table. arity follows
+// redis-server's convention and counts the command name itself: a positive
+// value is the exact number of arguments, a negative one the minimum.
+// firstKey, lastKey and step locate key arguments (lastKey -1 meaning the
+// last argument); all zero means the command takes no keys.
+type command struct {
+	// id indexes the command in commandTable and in the server's
+	// per-command stats.
+	id   int
+	name string
+	// upper is name in capitals, the other spelling clients commonly
+	// send, kept so that argString can intern it too.
+	upper    string
+	handler  func(c *client, args []string)
+	arity    int
+	flags    commandFlag
+	firstKey int
+	lastKey  int
+	step     int
+	// categories are the ACL categories the flags put the command in.
+	categories []string
+	// extension marks a command redis-server doesn't have. It only exists
+	// when enable-extensions is set.
+	extension bool
+
+	// subcommands make the command a container like CONFIG, whose second
+	// argument picks the subcommand that runs in its place. A subcommand's
+	// name is its container's and its own joined by "|", and its arity
+	// counts the container's name. register adds HELP to every container.
+	subcommands []*command
+	// sub maps the subcommands' own names to their entries.
+	sub map[string]*command
+	// usage and help describe a subcommand in its container's HELP reply:
+	// the arguments it takes and what it does, one line per string.
+	usage string
+	help  []string
+}
+
+func (cmd *command) has(f commandFlag) bool {
+	return cmd.flags&f != 0
+}
+
+func (cmd *command) arityOK(n int) bool {
+	if cmd.arity >= 0 {
+		return n == cmd.arity
+	}
+	return n >= -cmd.arity
+}
+
+const errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+// commands is filled in by init rather than declared as a literal so that
+// handlers may refer to the table without an initialization cycle.
+var commands = make(map[string]*command)
+
+// commandTable holds the same entries as commands in registration order.
+var commandTable []*command
+
+// builtin maps the names commands are registered under to their entries.
+// Unlike commands it ignores rename-command: the store propagates writes
+// under these names, so the AOF and the replication stream use them.
+var builtin = make(map[string]*command)
+
+func register(cmd *command) {
+	registerEntry(cmd)
+	commands[strings.ToLower(cmd.name)] = cmd
+	builtin[strings.ToLower(cmd.name)] = cmd
+}
+
+// registerEntry gives cmd and its subcommands their ids and stats slots.
+func registerEntry(cmd *command) {
+	cmd.categories = cmd.aclCategories()
+	cmd.upper = strings.ToUpper(cmd.name)
+	cmd.id = len(commandTable)
+	commandTable = append(commandTable, cmd)
+	if cmd.subcommands == nil {
+		return
+	}
+	cmd.subcommands = append(cmd.subcommands, &command{
+		name:    "help",
+		handler: func(c *client, _ []string) { c.reply(cmd.helpReply()) },
+		arity:   2,
+		flags:   flagFast | flagStale,
+		help:    []string{"Print this help."},
+	})
+	cmd.sub = make(map[string]*command, len(cmd.subcommands))
+	for _, sub := range cmd.subcommands {
+		cmd.sub[sub.name] = sub
+		sub.name = cmd.name + "|" + sub.name
+		registerEntry(sub)
+	}
+}
+
+// resolve returns the command args run, cmd itself or the subcommand
+// they name if cmd is a container, or the error to refuse them with if
+// they don't fit its arity or name no subcommand.
+func (cmd *command) resolve(args []string) (*command, string) {
+	if !cmd.arityOK(len(args)) {
+		return cmd, wrongArity(cmd.name)
+	}
+	if cmd.sub == nil {
+		return cmd, ""
+	}
+	sub, ok := cmd.sub[strings.ToLower(args[1])]
+	if !ok {
+		return cmd, unknownSubcommand(cmd.name, args[1])
+	}
+	if !sub.arityOK(len(args)) {
+		return sub, wrongArity(sub.name)
+	}
+	return sub, ""
+}
+
+// helpReply is a container's HELP reply, listing its subcommands in the
+// order they were registered.
+func (cmd *command) helpReply() resp.Array {
+	arr := resp.Array{resp.SimpleString(strings.ToUpper(cmd.name) + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")}
+	for _, sub := range cmd.subcommands {
+		_, line, _ := strings.Cut(sub.name, "|")
+		line = strings.ToUpper(line)
+		if sub.usage != "" {
+			line += " " + sub.usage
+		}
+		arr = append(arr, resp.SimpleString(line))
+		for _, h := range sub.help {
+			arr = append(arr, resp.SimpleString("    "+h))
+		}
+	}
+	return arr
+}
+
+// RenameCommands applies the rename-command directives to the table
+// clients look commands up in. It must run before any command does.
+func RenameCommands() error {
+	for _, r := range config.CommandRenames() {
+		if err := renameCommand(r.From, r.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameCommand renames the builtin command from to to, or disables it
+// if to is empty. Its subcommands follow, so errors and COMMAND INFO
+// name them "to|sub".
+func renameCommand(from, to string) error {
+	cmd, ok := builtin[strings.ToLower(from)]
+	if !ok {
+		return fmt.Errorf("no such command '%s' to rename", from)
+	}
+	lower := strings.ToLower(to)
+	if other, ok := commands[lower]; ok && other != cmd {
+		return fmt.Errorf("can't rename '%s' to '%s': a command by that name already exists", from, to)
+	}
+	delete(commands, strings.ToLower(cmd.name))
+	if lower == "" {
+		return nil
+	}
+	cmd.name, cmd.upper = lower, strings.ToUpper(lower)
+	commands[lower] = cmd
+	for name, sub := range cmd.sub {
+		sub.name = lower + "|" + name
+		sub.upper = strings.ToUpper(sub.name)
+	}
+	return nil
+}
+
+func (cmd *command) aclCategories() []string {
+	var cats []string
+	for _, f := range []struct {
+		flag commandFlag
+		cat  string
+	}{
+		{flagReadonly, "read"}, {flagWrite, "write"}, {flagAdmin, "admin"},
+		{flagAdmin, "dangerous"}, {flagBlocking, "blocking"}, {flagPubSub, "pubsub"},
+	} {
+		if cmd.has(f.flag) {
+			cats = append(cats, f.cat)
+		}
+	}
+	if cmd.has(flagFast) {
+		return append(cats, "fast")
+	}
+	return append(cats, "slow")
+}
+
+// keys returns the key arguments of a call to cmd.
+func (cmd *command) keys(args []string) []string {
+	if cmd.firstKey == 0 || cmd.firstKey >= len(args) {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	last = min(last, len(args)-1)
+	var keys []string
+	for i := cmd.firstKey; i <= last; i += cmd.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func init() {
+	for _, cmd := range []*command{
+		{name: "ping", handler: handlePing, arity: -1, flags: flagFast | flagPubSub | flagStale},
+		{name: "echo", handler: handleEcho, arity: 2, flags: flagFast},
+		{name: "set", handler: handleSet, arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+		{name: "get", handler: handleGet, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "append", handler: handleAppend, arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "setrange", handler: handleSetRange, arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+		{name: "getrange", handler: handleGetRange, arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1},
+		{name: "lcs", handler: handleLCS, arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 2, step: 1},
+		{name: "strlen", handler: handleStrLen, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "del", handler: handleDel, arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1},
+		{name: "unlink", handler: handleUnlink, arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: -1, step: 1},
+		{name: "scan", handler: handleScan, arity: -2, flags: flagReadonly},
+		{name: "touch", handler: handleTouch, arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, step: 1},
+		{name: "expire", handler: func(c *client, args []string) { handleExpire(c, args, time.Second) }, arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "pexpire", handler: func(c *client, args []string) { handleExpire(c, args, time.Millisecond) }, arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "expireat", handler: func(c *client, args []string) { handleExpireAt(c, args, time.Second) }, arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "pexpireat", handler: func(c *client, args []string) { handleExpireAt(c, args, time.Millisecond) }, arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "persist", handler: handlePersist, arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "ttl", handler: func(c *client, args []string) { handleTTL(c, args, time.Second) }, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "pttl", handler: func(c *client, args []string) { handleTTL(c, args, time.Millisecond) }, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "dump", handler: handleDump, arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1},
+		{name: "restore", handler: handleRestore, arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+		{name: "sort", handler: handleSort, arity: -2, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+		{name: "sort_ro", handler: handleSortRO, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1},
+		{name: "memory", handler: handleMemory, arity: -2, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1},
+		{name: "object", arity: -2, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1, subcommands: objectSubcommands},
+		{name: "config", arity: -2, flags: flagAdmin | flagStale, subcommands: configSubcommands},
+		{name: "client", arity: -2, flags: flagAdmin, subcommands: clientSubcommands},
+		{name: "acl", handler: handleACL, arity: -2, flags: flagAdmin},
+		{name: "auth", handler: handleAuth, arity: -2, flags: flagFast | flagNoAuth | flagNoScript | flagStale},
+		{name: "hello", handler: handleHello, arity: -1, flags: flagFast | flagNoAuth | flagNoScript | flagStale},
+		{name: "quit", handler: handleQuit, arity: -1, flags: flagFast | flagNoAuth | flagNoScript | flagStale},
+		{name: "reset", handler: handleReset, arity: 1, flags: flagFast | flagNoAuth | flagNoScript},
+		{name: "command", handler: handleCommand, arity: -1},
+		{name: "debug", handler: handleDebug, arity: -2, flags: flagAdmin},
+		{name: "cluster", handler: handleCluster, arity: -2},
+		{name: "readonly", handler: handleReadOnly, arity: 1, flags: flagFast | flagStale},
+		{name: "readwrite", handler: handleReadOnly, arity: 1, flags: flagFast | flagStale},
+		{name: "info", handler: handleInfo, arity: -1, flags: flagFast | flagStale},
+		{name: "slowlog", handler: handleSlowlog, arity: -2, flags: flagAdmin},
+		{name: "save", handler: handleSave, arity: 1, flags: flagAdmin},
+		{name: "bgsave", handler: handleBGSave, arity: -1, flags: flagAdmin},
+		{name: "bgrewriteaof", handler: handleBGRewriteAOF, arity: 1, flags: flagAdmin},
+		{name: "lastsave", handler: handleLastSave, arity: 1, flags: flagFast},
+		{name: "replconf", handler: handleReplconf, arity: -1, flags: flagAdmin | flagStale},
+		{name: "psync", handler: handlePSync, arity: -3, flags: flagAdmin},
+		{name: "wait", handler: handleWait, arity: 3, flags: flagBlocking | flagNoScript},
+		{name: "replicaof", handler: handleReplicaOf, arity: 3, flags: flagAdmin | flagStale},
+		{name: "slaveof", handler: handleReplicaOf, arity: 3, flags: flagAdmin | flagStale},
+		{name: "eval", handler: handleEval, arity: -3, flags: flagNoScript},
+		{name: "evalsha", handler: handleEvalSha, arity: -3, flags: flagNoScript},
+		{name: "script", handler: handleScript, arity: -2, flags: flagNoScript},
+		{name: "shutdown", handler: handleShutdown, arity: -1, flags: flagAdmin | flagStale},
+		{name: "lpush", handler: handleLPush, arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "rpush", handler: handleRPush, arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "lrange", handler: handleLRange, arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1},
+		{name: "llen", handler: handleLLen, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "lpop", handler: handleLPop, arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1},
+		{name: "blpop", handler: handleBLPop, arity: 3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: 1, step: 1},
+		{name: "setifttl", handler: handleSetIfTTL, arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, extension: true},
+	} {
+		register(cmd)
+	}
+}
+
+// lookupCommand finds the table entry for a command name in any case.
+// Extensions aren't found unless enabled.
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := findCommand(commands, name)
+	if !ok || !cmd.enabled() {
+		return nil, false
+	}
+	return cmd, true
+}
+
+// lookup finds the command c means by name. The master and AOF replay
+// name commands as they were registered, whatever they are renamed to.
+func (c *client) lookup(name string) (*command, bool) {
+	if !c.master && !c.replay {
+		return lookupCommand(name)
+	}
+	cmd, ok := findCommand(builtin, name)
+	if !ok || !cmd.enabled() {
+		return nil, false
+	}
+	return cmd, true
+}
+
+// findCommand looks name up in table ignoring ASCII case. A name of up to
+// maxCommandNameLen bytes is lowered on the stack, so the lookup doesn't
+// allocate whichever way the client spelled it.
+func findCommand[T ~string | ~[]byte](table map[string]*command, name T) (*command, bool) {
+	if len(name) > maxCommandNameLen {
+		cmd, ok := table[strings.ToLower(string(name))]
+		return cmd, ok
+	}
+	var lower [maxCommandNameLen]byte
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if 'A' <= ch && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		lower[i] = ch
+	}
+	cmd, ok := table[string(lower[:len(name)])]
+	return cmd, ok
+}
+
+func (cmd *command) enabled() bool {
+	return !cmd.extension || config.EnableExtensions.Load()
+}
+
+// refusal returns the error that keeps c from running cmd with args, or ""
+// if it may: missing authentication or permissions, no memory for it, a
+// write while saves are failing, a write sent to a replica, or any but a
+// few commands sent to a replica that mustn't serve stale data while its
+// master is down. The master and the AOF replay are exempt, and nothing is
+// evicted for them: what they send was accepted once already.
+func (c *client) refusal(cmd *command, args []string) string {
+	if c.master || c.replay {
+		return ""
+	}
+	if !cmd.has(flagNoAuth) {
+		if !c.authenticated {
+			return errNoAuth
+		}
+		// ACL rules name containers, which cover their subcommands.
+		name, _, _ := strings.Cut(cmd.name, "|")
+		if !c.user.CanRun(name, cmd.categories) {
+			return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", c.user.Name(), cmd.name)
+		}
+		for _, key := range cmd.keys(args) {
+			if !c.user.CanAccessKey(key) {
+				return "NOPERM No permissions to access a key"
+			}
+		}
+	}
+	if cmd.has(flagDenyOOM) && !c.db.FreeMemoryIfNeeded() {
+		return errOOM
+	}
+	if cmd.has(flagWrite) && c.srv.savesFailing() {
+		return errMisconf
+	}
+	if cmd.has(flagWrite) && c.srv.isReplica() {
+		return errReadOnly
+	}
+	if !cmd.has(flagStale) && c.srv.isReplica() && !config.ReplicaServeStaleData.Load() && !c.srv.masterLinkUp() {
+		return errMasterDown
+	}
+	return ""
+}
+
+// dispatch validates a request against the command table and runs it.
+func (c *client) dispatch(args []string) {
+	cmd, ok := c.lookup(args[0])
+	var msg string
+	if ok {
+		cmd, msg = cmd.resolve(args)
+	}
+	c.mu.Lock()
+	c.lastActive = time.Now()
+	if ok {
+		c.lastCmd = cmd.name
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.reply(resp.Error(unknownCommand(args[0], args[1:])))
+		return
+	}
+	if msg != "" {
+		c.srv.recordRejected(cmd)
+		c.reply(resp.Error(msg))
+		return
+	}
+	if msg := c.refusal(cmd, args); msg != "" {
+		c.srv.recordRejected(cmd)
+		c.reply(resp.Error(msg))
+		return
+	}
+	c.srv.totalCommands.Add(1)
+	start := time.Now()
+	c.blockedTime = 0
+	c.errReply = ""
+	c.execute(cmd, args)
+	duration := time.Since(start) - c.blockedTime
+	c.srv.recordCall(cmd, duration, c.errReply != "")
+	if c.errReply != "" {
+		c.log.Debug("Command failed", "cmd", cmd.name, "err", string(c.errReply))
+	}
+	c.srv.slowlog.record(c, args, duration)
+	c.srv.flushAOF()
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"maps"
+	"redis/app/resp"
+	"strings"
+	"testing"
+)
+
+// renameForTest renames a builtin command the way rename-command does and
+// puts the global table back as it was when the test ends.
+func renameForTest(t *testing.T, from, to string) {
+	t.Helper()
+	saved := maps.Clone(commands)
+	names := make(map[*command][2]string)
+	for _, cmd := range commandTable {
+		names[cmd] = [2]string{cmd.name, cmd.upper}
+	}
+	t.Cleanup(func() {
+		commands = saved
+		for cmd, n := range names {
+			cmd.name, cmd.upper = n[0], n[1]
+		}
+	})
+	if err := renameCommand(from, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameCommandRenamesSubcommands(t *testing.T) {
+	renameForTest(t, "config", "cfg")
+	for name, sub := range builtin["config"].sub {
+		if want := "cfg|" + name; sub.name != want {
+			t.Errorf("subcommand %s is named %s, want %s", name, sub.name, want)
+		}
+	}
+
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Error("ERR wrong number of arguments for 'cfg|get' command"), "CFG", "GET")
+	c.expect(resp.Error("ERR unknown subcommand 'NOPE'. Try CFG HELP."), "CFG", "NOPE")
+	help, ok := c.do("CFG", "HELP").(resp.Array)
+	if !ok || len(help) == 0 || !sameValue(help[0], resp.SimpleString("CFG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")) {
+		t.Errorf("CFG HELP = %s", show(help))
+	}
+	if _, ok := c.do("CONFIG", "GET", "port").(resp.Error); !ok {
+		t.Error("CONFIG still runs under its old name")
+	}
+}
+
+// TestObjectHelp checks a HELP reply line for line: the container's
+// usage, then each subcommand with its arguments and indented help, in
+// the order registered, ending with HELP itself.
+func TestObjectHelp(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	want := resp.Array{
+		resp.SimpleString("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+		resp.SimpleString("ENCODING <key>"),
+		resp.SimpleString("    Return the kind of internal representation used in order to store the value"),
+		resp.SimpleString("    associated with a <key>."),
+		resp.SimpleString("FREQ <key>"),
+		resp.SimpleString("    Return the access frequency index of the <key>. The returned integer is"),
+		resp.SimpleString("    proportional to the logarithm of the recent access frequency of the key."),
+		resp.SimpleString("IDLETIME <key>"),
+		resp.SimpleString("    Return the idle time of the <key>, that is the approximated number of"),
+		resp.SimpleString("    seconds elapsed since the last access to the key."),
+		resp.SimpleString("HELP"),
+		resp.SimpleString("    Print this help."),
+	}
+	c.expect(want, "OBJECT", "HELP")
+	c.expect(want, "object", "help")
+}
+
+// TestContainerSubcommands checks every container lists each of its
+// subcommands in HELP and refuses unknown ones and bad arities with the
+// errors redis-server gives.
+func TestContainerSubcommands(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	for _, name := range []string{"object", "config", "client"} {
+		upper := strings.ToUpper(name)
+		help, _ := c.do(upper, "HELP").(resp.Array)
+		lines := make(map[string]bool)
+		for _, v := range help {
+			line, _ := v.(resp.SimpleString)
+			word, _, _ := strings.Cut(string(line), " ")
+			lines[word] = true
+		}
+		for sub := range builtin[name].sub {
+			if !lines[strings.ToUpper(sub)] {
+				t.Errorf("%s HELP doesn't list %s", upper, sub)
+			}
+		}
+
+		c.expect(resp.Error("ERR unknown subcommand 'nosuch'. Try "+upper+" HELP."), upper, "nosuch")
+		c.expect(resp.Error("ERR unknown subcommand 'NoSuch'. Try "+upper+" HELP."), name, "NoSuch")
+		c.expect(resp.Error("ERR wrong number of arguments for '"+name+"' command"), upper)
+		c.expect(resp.Error("ERR wrong number of arguments for '"+name+"|help' command"), upper, "HELP", "extra")
+	}
+}
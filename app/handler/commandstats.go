@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"math/bits"
+	"redis/app/store"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of power-of-two microsecond buckets a
+// command's latency histogram has; the last one takes everything slower.
+const latencyBuckets = 40
+
+// latencyPercentiles are the ones INFO latencystats reports.
+var latencyPercentiles = []float64{50, 99, 99.9}
+
+// commandStats are the counters of one command table entry. They are only
+// ever updated with atomic adds, so recording a call takes no lock.
+type commandStats struct {
+	calls    atomic.Int64
+	usec     atomic.Int64
+	rejected atomic.Int64
+	failed   atomic.Int64
+	// latency counts calls by the bit length of their duration in
+	// microseconds.
+	latency [latencyBuckets]atomic.Int64
+}
+
+// newCommandStats returns counters for every command, indexed by
+// command.id.
+func newCommandStats() []commandStats {
+	return make([]commandStats, len(commandTable))
+}
+
+// recordCall counts a call of cmd that ran for d, and failed if it replied
+// with an error.
+func (s *Server) recordCall(cmd *command, d time.Duration, failed bool) {
+	st := &s.cmdStats[cmd.id]
+	usec := d.Microseconds()
+	st.calls.Add(1)
+	st.usec.Add(usec)
+	if failed {
+		st.failed.Add(1)
+	}
+	st.latency[min(bits.Len64(uint64(usec)), latencyBuckets-1)].Add(1)
+}
+
+// recordRejected counts a call of cmd refused before it ran.
+func (s *Server) recordRejected(cmd *command) {
+	s.cmdStats[cmd.id].rejected.Add(1)
+}
+
+func (s *Server) resetCommandStats() {
+	for i := range s.cmdStats {
+		st := &s.cmdStats[i]
+		st.calls.Store(0)
+		st.usec.Store(0)
+		st.rejected.Store(0)
+		st.failed.Store(0)
+		for j := range st.latency {
+			st.latency[j].Store(0)
+		}
+	}
+}
+
+// percentile estimates the latency below which p percent of calls fell,
+// as the upper bound of the bucket that call lands in.
+func (st *commandStats) percentile(p float64, calls int64) float64 {
+	rank := int64(p / 100 * float64(calls))
+	var seen int64
+	for i := range st.latency {
+		seen += st.latency[i].Load()
+		if seen > rank || seen == calls {
+			if i == 0 {
+				return 0
+			}
+			return float64(uint64(1) << i)
+		}
+	}
+	return 0
+}
+
+// usedCommands returns the commands called or rejected since the stats
+// were last reset, sorted by name.
+func (s *Server) usedCommands() []*command {
+	var used []*command
+	for _, cmd := range commandTable {
+		st := &s.cmdStats[cmd.id]
+		if st.calls.Load() > 0 || st.rejected.Load() > 0 {
+			used = append(used, cmd)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].name < used[j].name })
+	return used
+}
+
+func infoCommandstats(c *client, _ store.Stats) []infoField {
+	var fields []infoField
+	for _, cmd := range c.srv.usedCommands() {
+		st := &c.srv.cmdStats[cmd.id]
+		calls, usec := st.calls.Load(), st.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fields = append(fields, infoField{"cmdstat_" + cmd.name,
+			fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+				calls, usec, perCall, st.rejected.Load(), st.failed.Load())})
+	}
+	return fields
+}
+
+func infoLatencystats(c *client, _ store.Stats) []infoField {
+	var fields []infoField
+	for _, cmd := range c.srv.usedCommands() {
+		st := &c.srv.cmdStats[cmd.id]
+		calls := st.calls.Load()
+		if calls == 0 {
+			continue
+		}
+		parts := make([]string, len(latencyPercentiles))
+		for i, p := range latencyPercentiles {
+			parts[i] = "p" + strconv.FormatFloat(p, 'f', -1, 64) + "=" +
+				strconv.FormatFloat(st.percentile(p, calls), 'f', 3, 64)
+		}
+		fields = append(fields, infoField{"latency_percentiles_usec_" + cmd.name, strings.Join(parts, ",")})
+	}
+	return fields
+}
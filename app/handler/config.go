@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"redis/app/config"
+	"redis/app/resp"
+)
+
+// configSubcommands are CONFIG's; the container adds HELP.
+var configSubcommands = []*command{
+	{name: "get", handler: handleConfigGet, arity: -3, flags: flagAdmin | flagStale,
+		usage: "<pattern>", help: []string{"Return parameters matching the glob-like <pattern> and their values."}},
+	{name: "set", handler: handleConfigSet, arity: -4, flags: flagAdmin | flagStale,
+		usage: "<directive> <value>", help: []string{"Set the configuration <directive> to <value>."}},
+	{name: "resetstat", handler: handleConfigResetStat, arity: 2, flags: flagAdmin | flagStale,
+		help: []string{"Reset statistics reported by the INFO command."}},
+	{name: "rewrite", handler: handleConfigRewrite, arity: 2, flags: flagAdmin | flagStale,
+		help: []string{"Rewrite the configuration file."}},
+}
+
+func handleConfigGet(c *client, args []string) {
+	seen := make(map[string]bool)
+	var m resp.Map
+	for _, pattern := range args[2:] {
+		for _, name := range config.Match(pattern) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			value, _ := config.Get(name)
+			m = append(m, resp.KeyValue{Key: resp.BulkString(name), Value: resp.BulkString(value)})
+		}
+	}
+	c.reply(m)
+}
+
+func handleConfigSet(c *client, args []string) {
+	if len(args)%2 != 0 {
+		c.reply(resp.Error(wrongArity("CONFIG|SET")))
+		return
+	}
+	aofWasOn := config.AppendOnly.Load()
+	if name, err := config.SetMany(args[2:]); err != nil {
+		if errors.Is(err, config.ErrUnknown) {
+			c.reply(resp.Error(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)))
+			return
+		}
+		c.reply(resp.Error(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err)))
+		return
+	}
+	if on := config.AppendOnly.Load(); on != aofWasOn {
+		if !on {
+			c.srv.stopAOF()
+		} else if err := c.srv.startAOF(); err != nil {
+			config.AppendOnly.Store(false)
+			c.reply(resp.Error("ERR CONFIG SET failed (possibly related to argument 'appendonly') - " + err.Error()))
+			return
+		}
+	}
+	c.reply(resp.SimpleString("OK"))
+}
+
+func handleConfigResetStat(c *client, _ []string) {
+	c.srv.resetStats()
+	c.reply(resp.SimpleString("OK"))
+}
+
+func handleConfigRewrite(c *client, _ []string) {
+	// Settings only ever come from flags, so there is no file to rewrite.
+	c.reply(resp.Error("ERR The server is running without a config file"))
+}
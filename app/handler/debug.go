@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const errDebugNotSupported = "ERR DEBUG subcommand not supported"
+
+// handleDebug implements the DEBUG subcommands test suites rely on.
+func handleDebug(c *client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "SLEEP":
+		if len(args) != 3 {
+			c.reply(resp.Error(wrongArity("DEBUG|SLEEP")))
+			return
+		}
+		secs, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || secs < 0 {
+			c.reply(resp.Error("ERR value is not a valid float"))
+			return
+		}
+		// Like redis-server, stall everyone, not just this connection.
+		c.db.Stall(time.Duration(secs * float64(time.Second)))
+		c.reply(resp.SimpleString("OK"))
+	case "SET-ACTIVE-EXPIRE":
+		if len(args) != 3 || (args[2] != "0" && args[2] != "1") {
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+		c.srv.activeExpire.Store(args[2] == "1")
+		c.reply(resp.SimpleString("OK"))
+	case "OBJECT":
+		if len(args) != 3 {
+			c.reply(resp.Error(wrongArity("DEBUG|OBJECT")))
+			return
+		}
+		info, ok := c.db.Info(args[2])
+		value, _ := c.db.Peek(args[2])
+		if !ok {
+			c.reply(resp.Error("ERR no such key"))
+			return
+		}
+		length, err := rdb.SerializedLength(value)
+		if err != nil {
+			c.reply(resp.Error("ERR " + err.Error()))
+			return
+		}
+		c.reply(resp.SimpleString(fmt.Sprintf("refcount:1 encoding:%s serializedlength:%d lru_seconds_idle:%d",
+			info.Encoding, length, int64(info.Idle/time.Second))))
+	case "CHANGE-REPL-ID":
+		c.srv.changeReplID()
+		c.reply(resp.SimpleString("OK"))
+	case "STRINGMATCH-LEN", "JMAP":
+		c.reply(resp.SimpleString("OK"))
+	default:
+		c.reply(resp.Error(errDebugNotSupported))
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/clock"
+	"redis/app/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSetActiveExpire turns the sweeper off, lets keys expire, and checks
+// they stay in the keyspace until it is turned back on.
+func TestSetActiveExpire(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	s := newTestServerClock(t, clk)
+	c := dial(t, s)
+	c.expect(ok(), "DEBUG", "SET-ACTIVE-EXPIRE", "0")
+	for i := 0; i < 10; i++ {
+		c.expect(ok(), "SET", fmt.Sprint("k", i), "v", "PX", "10")
+	}
+	clk.Advance(time.Second)
+	// Several cron ticks, any of which would have swept them.
+	time.Sleep(5 * time.Second / serverHz)
+	if n := s.db.Len(); n != 10 {
+		t.Fatalf("%d keys left with the sweeper off, want all 10", n)
+	}
+
+	c.expect(ok(), "DEBUG", "SET-ACTIVE-EXPIRE", "1")
+	eventually(t, "the sweeper to remove the keys", func() bool { return s.db.Len() == 0 })
+	c.expect(resp.Error(syntaxError()), "DEBUG", "SET-ACTIVE-EXPIRE", "2")
+}
+
+func TestDebugObject(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	c := dial(t, newTestServerClock(t, clk))
+	c.expect(ok(), "SET", "int", "12345")
+	c.expect(ok(), "SET", "str", "hello")
+	c.expect(ok(), "SET", "long", strings.Repeat("a", 1000))
+	c.expect(resp.Integer(3), "RPUSH", "list", "a", "b", "c")
+	clk.Advance(5 * time.Second)
+
+	// serializedlength is the length DUMP's payload gives the value: an
+	// int-encoded integer, a length-prefixed string, an LZF-compressed
+	// one, and a listpack.
+	for _, tc := range []struct {
+		key, encoding string
+		length        func(int) bool
+	}{
+		{"int", "int", func(n int) bool { return n == 3 }},
+		{"str", "embstr", func(n int) bool { return n == 6 }},
+		{"long", "raw", func(n int) bool { return n > 0 && n < 100 }},
+		{"list", "listpack", func(n int) bool { return n > 3 }},
+	} {
+		reply, _ := c.do("DEBUG", "OBJECT", tc.key).(resp.SimpleString)
+		fields := make(map[string]string)
+		for _, f := range strings.Fields(string(reply)) {
+			name, value, _ := strings.Cut(f, ":")
+			fields[name] = value
+		}
+		if fields["encoding"] != tc.encoding {
+			t.Errorf("%s: encoding %q, want %q (%s)", tc.key, fields["encoding"], tc.encoding, reply)
+		}
+		var length int
+		if _, err := fmt.Sscan(fields["serializedlength"], &length); err != nil || !tc.length(length) {
+			t.Errorf("%s: serializedlength %q (%s)", tc.key, fields["serializedlength"], reply)
+		}
+		if fields["lru_seconds_idle"] != "5" {
+			t.Errorf("%s: lru_seconds_idle %q, want 5", tc.key, fields["lru_seconds_idle"])
+		}
+	}
+	c.expect(resp.Error("ERR no such key"), "DEBUG", "OBJECT", "missing")
+}
+
+// TestDebugSleep checks DEBUG SLEEP holds up other clients, not only the
+// one that sent it.
+func TestDebugSleep(t *testing.T) {
+	s := newTestServer(t)
+	sleeper, other := dial(t, s), dial(t, s)
+	if err := sleeper.send("DEBUG", "SLEEP", "0.2"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	other.expect(resp.Null{}, "GET", "k")
+	if took := time.Since(start); took < 100*time.Millisecond {
+		t.Errorf("GET during DEBUG SLEEP 0.2 took only %v", took)
+	}
+	if got, err := sleeper.read(); err != nil || !sameValue(got, ok()) {
+		t.Errorf("DEBUG SLEEP = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func handleDump(c *client, args []string) {
+	value, ok := c.db.Dump(args[1])
+	if !ok {
+		c.reply(resp.Null{})
+		return
+	}
+	payload, err := rdb.Dump(value)
+	if err != nil {
+		c.reply(resp.Error("ERR " + err.Error()))
+		return
+	}
+	c.reply(resp.BulkString(payload))
+}
+
+// handleRestore implements RESTORE key ttl payload [REPLACE] [ABSTTL].
+func handleRestore(c *client, args []string) {
+	var replace, absTTL bool
+	for _, opt := range args[4:] {
+		switch strings.ToUpper(opt) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+	}
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	if ttl < 0 {
+		c.reply(resp.Error("ERR Invalid TTL value, must be >= 0"))
+		return
+	}
+
+	value, err := rdb.Restore([]byte(args[3]))
+	if errors.Is(err, rdb.ErrDumpPayload) {
+		c.reply(resp.Error("ERR " + err.Error()))
+		return
+	}
+	if elems, ok := value.([]string); err != nil || ok && len(elems) == 0 {
+		c.reply(resp.Error("ERR Bad data format"))
+		return
+	}
+
+	var deadline time.Time
+	switch {
+	case ttl == 0:
+	case absTTL:
+		deadline = time.UnixMilli(ttl)
+	default:
+		deadline = c.srv.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	if err := c.db.Restore(args[1], value, deadline, replace); err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.SimpleString("OK"))
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"testing"
+)
+
+func TestRestoreChecksTheChecksum(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "k", "v")
+	payload, isBulk := c.do("DUMP", "k").(resp.BulkString)
+	if !isBulk {
+		t.Fatal("DUMP didn't return a bulk string")
+	}
+	zeroed := append([]byte(nil), payload...)
+	clear(zeroed[len(zeroed)-8:])
+	c.expect(resp.Error("ERR DUMP payload version or checksum are wrong"), "RESTORE", "copy", "0", string(zeroed))
+	c.expect(resp.Null{}, "GET", "copy")
+
+	c.expect(ok(), "RESTORE", "copy", "0", string(payload))
+	c.expect(bulk("v"), "GET", "copy")
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error replies are formatted exactly like redis-server's so that client
+// libraries matching on prefixes and messages behave the same against us.
+
+func wrongArity(cmd string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+func unknownCommand(cmd string, args []string) string {
+	var b strings.Builder
+	for _, arg := range args {
+		if b.Len() >= 128 {
+			break
+		}
+		remaining := 128 - b.Len()
+		if len(arg) > remaining {
+			arg = arg[:remaining]
+		}
+		fmt.Fprintf(&b, "'%s' ", arg)
+	}
+	if len(cmd) > 128 {
+		cmd = cmd[:128]
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", cmd, b.String())
+}
+
+func notAnInteger() string {
+	return "ERR value is not an integer or out of range"
+}
+
+func wrongType() string {
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+
+func syntaxError() string {
+	return "ERR syntax error"
+}
+
+func notPositive() string {
+	return "ERR value is out of range, must be positive"
+}
+
+func invalidTimeout() string {
+	return "ERR timeout is not a float or out of range"
+}
+
+func negativeTimeout() string {
+	return "ERR timeout is negative"
+}
+
+func timeoutOutOfRange() string {
+	return "ERR timeout is out of range"
+}
+
+func invalidExpireTime(cmd string) string {
+	return fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd))
+}
+
+func unknownSubcommand(cmd, sub string) string {
+	return fmt.Sprintf("ERR unknown subcommand '%s'. Try %s HELP.", sub, strings.ToUpper(cmd))
+}
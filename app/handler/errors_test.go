@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strings"
+	"testing"
+)
+
+// TestErrorReplies checks common failures against the exact text
+// redis-server 7 replies with, which clients match on.
+func TestErrorReplies(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "str", "v")
+	c.expect(resp.Integer(1), "RPUSH", "list", "a")
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET"}, "ERR wrong number of arguments for 'get' command"},
+		{[]string{"ECHO"}, "ERR wrong number of arguments for 'echo' command"},
+		{[]string{"LPUSH", "list"}, "ERR wrong number of arguments for 'lpush' command"},
+		{[]string{"NOSUCH"}, "ERR unknown command 'NOSUCH', with args beginning with: "},
+		{[]string{"nosuch", "a", "b"}, "ERR unknown command 'nosuch', with args beginning with: 'a' 'b' "},
+		{[]string{"NOSUCH", strings.Repeat("x", 200)}, "ERR unknown command 'NOSUCH', with args beginning with: '" + strings.Repeat("x", 128) + "' "},
+		{[]string{"LPUSH", "str", "x"}, "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{[]string{"GET", "list"}, "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{[]string{"EXPIRE", "str", "soon"}, "ERR value is not an integer or out of range"},
+		{[]string{"LPOP", "list", "-1"}, "ERR value is out of range, must be positive"},
+		{[]string{"BLPOP", "list", "later"}, "ERR timeout is not a float or out of range"},
+		{[]string{"BLPOP", "list", "-1"}, "ERR timeout is negative"},
+		{[]string{"SET", "k", "v", "PX", "0"}, "ERR invalid expire time in 'set' command"},
+		{[]string{"CONFIG", "GET"}, "ERR wrong number of arguments for 'config|get' command"},
+		{[]string{"CONFIG", "NOSUCH"}, "ERR unknown subcommand 'NOSUCH'. Try CONFIG HELP."},
+	} {
+		c.expect(resp.Error(tc.want), tc.args...)
+	}
+}
@@ -2,381 +2,426 @@ package handler
 
 import (
 	"bufio"
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
+	"math"
 	"net"
+	"redis/app/acl"
+	"redis/app/config"
+	"redis/app/logging"
+	"redis/app/resp"
+	"redis/app/store"
 	"redis/app/types"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var store = make(map[string]types.Entry)
-var rPlush = make(map[string][]string)
+// client is the state of one connection. Replies are buffered in out and
+// reach the socket whenever the reader has to wait for more input, so a
+// pipeline of commands gets its replies in as few writes as possible.
+type client struct {
+	out    *resp.Encoder
+	conn   net.Conn // nil for the client replaying the AOF
+	reader *bufio.Reader
+	db     store.Store
+	srv    *Server
+	// log tags every record with the client it is about.
+	log *slog.Logger
+
+	id        int64
+	createdAt time.Time
+	// blocked is set while the client is parked in a blocking command.
+	blocked atomic.Bool
+	// waiting is the pop the client is parked in. It stays set if the
+	// client goes away while parked, for the teardown to withdraw.
+	waiting *types.BlockingRequest
+	// blockedTime is how long the current command spent parked; the slow
+	// log only counts the rest.
+	blockedTime time.Duration
+	// unblock wakes the client from a blocking pop for CLIENT UNBLOCK,
+	// carrying whether to reply with an error rather than as if the
+	// timeout was reached.
+	unblock chan bool
+	// errReply is the error the current command replied with, if any.
+	errReply resp.Error
+	// user is who the connection is logged in as; until authenticated is
+	// set, and if the user needs a password, only AUTH-like commands run.
+	user          *acl.User
+	authenticated bool
+	// closeAfterReply ends the connection once the current reply is sent.
+	closeAfterReply bool
+	// replicaPort is the port given by REPLCONF listening-port. replica is
+	// set once the connection is a replica; it gets no more replies, only
+	// the replication stream.
+	replicaPort string
+	replica     *replica
+	// master marks the client applying the stream from our master: it
+	// may write on a replica, skips permission checks and gets no
+	// replies.
+	master bool
+	// replay marks the client applying the append only file at startup.
+	replay bool
+	// script marks the client a script's redis.call runs commands as; its
+	// replies are kept in scriptReply instead of being sent.
+	script      bool
+	scriptReply resp.Value
+	// hook is the context of the command running through hooks, which
+	// its replies are recorded in.
+	hook *CommandContext
+
+	// mu guards the fields below, which CLIENT LIST reads from other
+	// connections.
+	mu         sync.Mutex
+	name       string
+	lastCmd    string
+	lastActive time.Time
+	// blockedOn is the key of the blocking pop the client is parked in.
+	blockedOn string
+}
 
-func HandleConnection(conn net.Conn) {
+func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
+	out := resp.NewEncoder(conn)
+	id := s.nextClientID.Add(1)
+	c := &client{
+		out:    out,
+		conn:   conn,
+		reader: bufio.NewReader(flushingReader{conn: conn, out: out}),
+		db:     s.db,
+		srv:    s,
+		log:    s.log.With("client", id, "addr", conn.RemoteAddr().String()),
+
+		id:         id,
+		createdAt:  time.Now(),
+		lastActive: time.Now(),
+		unblock:    make(chan bool, 1),
+	}
+	c.reset()
+	s.addClient(c)
+	defer s.removeClient(c)
+	requests := newRequestReader(c.reader)
+	requests.client = true
+	c.log.Log(context.Background(), logging.LevelVerbose, "Accepted connection")
+	defer c.log.Log(context.Background(), logging.LevelVerbose, "Client closed connection")
 
 	for {
-		args, err := parseArgs(conn, reader)
+		// An idle client gets the configured timeout to start its next
+		// command, and once started the command must arrive in full within
+		// commandReadTimeout, so a stalled partial frame can't hold the
+		// connection open.
+		if c.replica != nil {
+			// Replicas only send acks, and aren't subject to timeout.
+			c.conn.SetReadDeadline(time.Time{})
+		} else {
+			c.conn.SetReadDeadline(idleDeadline())
+		}
+		// Checked after setting the deadline, since that may have replaced
+		// the expired one shutdown uses to wake us.
+		if s.shuttingDown() {
+			c.out.Flush()
+			return
+		}
+		if _, err := c.reader.Peek(1); err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(commandReadTimeout))
+		args, err := requests.next()
 		if err != nil {
-			writeError(conn, err.Error())
-			continue
+			// A malformed request leaves the stream desynchronized, so
+			// report it and drop the connection. Anything else (EOF, reset,
+			// closed socket) just ends the session.
+			var perr *protocolError
+			if errors.As(err, &perr) {
+				c.log.Log(context.Background(), logging.LevelVerbose, "Protocol error from client",
+					"err", perr.msg, "input", perr.dump())
+				if perr.limit {
+					s.queryBufferDisconnections.Add(1)
+				}
+				c.reply(resp.Error("ERR " + perr.Error()))
+				c.out.Flush()
+			}
+			return
 		}
 		if len(args) == 0 {
-			writeError(conn, "empty command")
 			continue
 		}
 
-		switch strings.ToUpper(args[0]) {
-		case "PING":
-			handlePing(conn)
-		case "ECHO":
-			handleEcho(conn, args)
-		case "SET":
-			handleSet(conn, args)
-		case "GET":
-			handleGet(conn, args)
-		case "LPUSH":
-			handleLPush(conn, args)
-		case "RPUSH":
-			handleRPush(conn, args)
-		case "LRANGE":
-			handleLRange(conn, args)
-		case "LLEN":
-			handleLLen(conn, args)
-		case "LPOP":
-			handleLPop(conn, args)
-		case "BLPOP":
-			handleBLPop(conn, args)
-		default:
-			writeError(conn, fmt.Sprintf("unknown command '%s'", args[0]))
+		c.dispatch(args)
+		if c.closeAfterReply {
+			c.out.Flush()
+			return
 		}
 	}
 }
 
-func handlePing(conn net.Conn) {
-	writeSimpleString(conn, "PONG")
-}
+// commandReadTimeout bounds how long reading one command may take once its
+// first byte has arrived.
+const commandReadTimeout = 30 * time.Second
 
-func handleEcho(conn net.Conn, args []string) {
-	if len(args) != 2 {
-		writeError(conn, "wrong number of arguments for 'ECHO'")
-		return
+// idleDeadline returns the read deadline for a client waiting between
+// commands: the zero time if the timeout setting is 0.
+func idleDeadline() time.Time {
+	timeout := config.Timeout.Load()
+	if timeout == 0 {
+		return time.Time{}
 	}
-	writeSimpleString(conn, args[1])
+	return time.Now().Add(time.Duration(timeout) * time.Second)
 }
 
-func handleSet(conn net.Conn, args []string) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'SET'")
-		return
-	}
-	key := args[1]
-	val := args[2]
-	var expiry time.Time
-
-	if len(args) >= 5 && strings.ToUpper(args[3]) == "PX" {
-		ms, err := strconv.Atoi(args[4])
-		if err != nil {
-			writeError(conn, "PX value must be integer")
-			return
-		}
-		expiry = time.Now().Add(time.Duration(ms) * time.Millisecond)
-	}
-
-	store[key] = types.Entry{Value: val, ExpiryTime: expiry}
-	writeSimpleString(conn, "OK")
+// flushingReader flushes pending replies before every read from the
+// socket. bufio.Reader only reads once its buffer is drained, so replies
+// accumulate while pipelined commands are still buffered and go out
+// together before we block for more. A failed flush surfaces as a read
+// error, which ends the connection loop.
+type flushingReader struct {
+	conn net.Conn
+	out  *resp.Encoder
 }
 
-func handleGet(conn net.Conn, args []string) {
-	if len(args) != 2 {
-		writeError(conn, "wrong number of arguments for 'GET'")
-		return
+func (r flushingReader) Read(p []byte) (int, error) {
+	if err := r.out.Flush(); err != nil {
+		return 0, err
 	}
-	key := args[1]
-	entry, ok := store[key]
-	if !ok || (entry.ExpiryTime != (time.Time{}) && time.Now().After(entry.ExpiryTime)) {
-		delete(store, key)
-		writeNull(conn)
-		return
-	}
-	writeBulkString(conn, entry.Value)
+	return r.conn.Read(p)
 }
-func handleLPush(conn net.Conn, args []string) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'LPUSH'")
-		return
-	}
 
-	key := args[1]
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	for i := 2; i < len(args); i++ {
-		rPlush[key] = append([]string{args[i]}, rPlush[key]...)
+func handlePing(c *client, args []string) {
+	switch len(args) {
+	case 1:
+		c.reply(resp.SimpleString("PONG"))
+	case 2:
+		c.reply(resp.BulkString(args[1]))
+	default:
+		c.reply(resp.Error(wrongArity("PING")))
 	}
-
-	// Wake up blocked BLPOP clients if any
-	wakeUpFirstBlocking(key)
-
-	writeInteger(conn, len(rPlush[key]))
 }
 
-func handleRPush(conn net.Conn, args []string) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'RPUSH'")
-		return
-	}
-
-	key := args[1]
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	for i := 2; i < len(args); i++ {
-		rPlush[key] = append(rPlush[key], args[i])
-	}
-
-	wakeUpFirstBlocking(key)
-
-	writeInteger(conn, len(rPlush[key]))
+func handleEcho(c *client, args []string) {
+	c.reply(resp.BulkString(args[1]))
 }
 
-
-func handleLRange(conn net.Conn, args []string) {
-	if len(args) != 4 {
-		writeError(conn, "wrong number of arguments for 'LRANGE'")
-		return
-	}
-	key := args[1]
-	start, err1 := strconv.Atoi(args[2])
-	end, err2 := strconv.Atoi(args[3])
-	if err1 != nil || err2 != nil {
-		writeError(conn, "invalid start or end index")
-		return
+// handleSet implements SET key value [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds]. The options are
+// all checked before the key is written, so a malformed SET stores
+// nothing.
+func handleSet(c *client, args []string) {
+	var expireOpt, expireArg string
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "EX", "PX", "EXAT", "PXAT":
+			// Only one expiry is allowed, and it must have its operand.
+			if expireOpt != "" || i+1 == len(args) {
+				c.reply(resp.Error(syntaxError()))
+				return
+			}
+			expireOpt, expireArg = opt, args[i+1]
+			i++
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
 	}
-	list := rPlush[key]
 
-	if start < 0 {
-		start = len(list) + start
-		if start < 0 {
-			start = 0
+	var expiry time.Time
+	if expireOpt != "" {
+		n, err := strconv.ParseInt(expireArg, 10, 64)
+		if err != nil {
+			c.reply(resp.Error(notAnInteger()))
+			return
 		}
-	}
-	if end < 0 {
-		end = len(list) + end
-		if end < 0 {
-			end = 0
+		var ok bool
+		if expiry, ok = setExpiry(expireOpt, n, c.srv.clock.Now()); !ok {
+			c.reply(resp.Error(invalidExpireTime("SET")))
+			return
 		}
 	}
-	if start >= len(list) || start > end {
-		conn.Write([]byte("*0\r\n"))
-		return
+
+	c.db.Set(args[1], args[2], store.SetOptions{ExpireAt: expiry})
+	c.reply(resp.SimpleString("OK"))
+}
+
+// setExpiry turns SET's expiry option opt with operand n into a deadline,
+// or reports false if n isn't positive or the deadline doesn't fit in
+// unix milliseconds.
+func setExpiry(opt string, n int64, now time.Time) (time.Time, bool) {
+	if n <= 0 {
+		return time.Time{}, false
 	}
-	if end >= len(list) {
-		end = len(list) - 1
+	unit := time.Millisecond
+	if opt == "EX" || opt == "EXAT" {
+		unit = time.Second
 	}
-	sublist := list[start : end+1]
-	conn.Write([]byte(fmt.Sprintf("*%d\r\n", len(sublist))))
-	for _, item := range sublist {
-		writeBulkString(conn, item)
+	var base int64
+	if opt == "EX" || opt == "PX" {
+		base = now.UnixMilli()
 	}
+	ms, ok := expireMillis(n, unit, base)
+	return time.UnixMilli(ms), ok
 }
 
-func handleLLen(conn net.Conn, args []string) {
-	if len(args) != 2 {
-		writeError(conn, "wrong number of arguments for 'LLEN'")
-		return
+// expireMillis converts n seconds or milliseconds, as unit says, to
+// milliseconds and adds them to base. It reports false if the result
+// doesn't fit in an int64, which redis-server refuses as an invalid
+// expire time rather than letting it wrap into the past.
+func expireMillis(n int64, unit time.Duration, base int64) (int64, bool) {
+	if unit == time.Second {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if (base > 0 && n > math.MaxInt64-base) || (base < 0 && n < math.MinInt64-base) {
+		return 0, false
 	}
-	writeInteger(conn, len(rPlush[args[1]]))
+	return n + base, true
 }
 
-func handleLPop(conn net.Conn, args []string) {
-	if len(args) < 2 {
-		writeError(conn, "wrong number of arguments for 'LPOP'")
+// handleSetIfTTL implements SETIFTTL key value threshold-ms ttl-ms, an
+// extension for refreshing cached values without a stampede: only the
+// first client to find the key missing or within threshold-ms of expiring
+// rewrites it, with a fresh ttl-ms.
+func handleSetIfTTL(c *client, args []string) {
+	threshold, err1 := strconv.ParseInt(args[3], 10, 64)
+	ttl, err2 := strconv.ParseInt(args[4], 10, 64)
+	if err1 != nil || err2 != nil {
+		c.reply(resp.Error(notAnInteger()))
 		return
 	}
-	key := args[1]
-	list := rPlush[key]
-	if len(list) == 0 {
-		writeNull(conn)
+	if threshold < 0 || ttl <= 0 {
+		c.reply(resp.Error(invalidExpireTime("SETIFTTL")))
 		return
 	}
-	if len(args) == 3 {
-		count, err := strconv.Atoi(args[2])
-		if err != nil || count <= 0 {
-			count = 1
-		}
-		if count > len(list) {
-			count = len(list)
-		}
-		rPlush[key] = list[count:]
-		conn.Write([]byte(fmt.Sprintf("*%d\r\n", count)))
-		for i := 0; i < count; i++ {
-			writeBulkString(conn, list[i])
-		}
+	now := c.srv.clock.Now()
+	if c.db.SetIfTTLBelow(args[1], args[2], time.Duration(threshold)*time.Millisecond,
+		now.Add(time.Duration(ttl)*time.Millisecond)) {
+		c.reply(resp.Integer(1))
 	} else {
-		rPlush[key] = list[1:]
-		writeBulkString(conn, list[0])
+		c.reply(resp.Integer(0))
 	}
 }
 
-var (
-	blockings = make(map[string][]types.BlockingRequest)
-	mu        = sync.Mutex{}
-)
-func handleBLPop(conn net.Conn, args []string) {
-	if len(args) != 3 {
-		writeError(conn, "wrong number of arguments for 'BLPOP'")
-		return
+func handleGet(c *client, args []string) {
+	value, ok, err := c.db.Get(args[1])
+	switch {
+	case err != nil:
+		c.replyStoreError(err)
+	case !ok:
+		c.reply(resp.Null{})
+	default:
+		c.reply(resp.BulkString(value))
 	}
+}
 
-	mu.Lock()
-	key := args[1]
-	if list, ok := rPlush[key]; ok && len(list) > 0 {
-		value := list[0]
-		rPlush[key] = list[1:]
-		mu.Unlock()
-
-		conn.Write([]byte("*2\r\n"))
-		writeBulkString(conn, key)
-		writeBulkString(conn, value)
-		return
-	}
-
-
-	timeoutStr := args[2]
-	timeout, err := strconv.ParseFloat(timeoutStr, 64)
+func handleExpire(c *client, args []string, unit time.Duration) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		writeError(conn, "timeout must be a number")
+		c.reply(resp.Error(notAnInteger()))
 		return
 	}
-
-	ch := make(chan string, 1)
-	blocking := types.BlockingRequest{
-		Key:     key,
-		Ch:      ch,
-		Timeout: time.Duration(timeout * float64(time.Second)),
+	ms, ok := expireMillis(n, unit, c.srv.clock.Now().UnixMilli())
+	if !ok {
+		c.reply(resp.Error(invalidExpireTime(args[0])))
+		return
 	}
-	blockings[key] = append(blockings[key], blocking)
-	mu.Unlock()
-
-	if timeout == 0 {
-		_, ok := <-ch
-		if !ok {
-			writeBulkString(conn, "")
-			return
-		}
-		list := rPlush[key]
-		if len(list) > 0 {
-			value := list[0]
-			rPlush[key] = list[1:]
-			conn.Write([]byte("*2\r\n"))
-			writeBulkString(conn, key)
-			writeBulkString(conn, value)
-			return
-		}
+	// A deadline in the past deletes the key right away.
+	if c.db.Expire(args[1], time.UnixMilli(ms)) {
+		c.reply(resp.Integer(1))
 	} else {
-		select {
-		case <-time.After(blocking.Timeout):
-			mu.Lock()
-			list := blockings[key]
-			newList := []types.BlockingRequest{}
-			for _, r := range list {
-				if r.Ch != ch {
-					newList = append(newList, r)
-				}
-			}
-			blockings[key] = newList
-			mu.Unlock()
-			writeNull(conn)
-			return
-		case key := <-ch:
-			list := rPlush[key]
-			if len(list) > 0 {
-				value := list[0]
-				rPlush[key] = list[1:]
-				conn.Write([]byte("*2\r\n"))
-				writeBulkString(conn, key)
-				writeBulkString(conn, value)
-				return
-			}
-		}
+		c.reply(resp.Integer(0))
 	}
 }
 
-
-
-// Helpers
-
-func parseArgs(conn net.Conn, reader *bufio.Reader) ([]string, error) {
-	line, err := reader.ReadString('\n')
+// handleExpireAt sets an absolute deadline given in unix time units.
+func handleExpireAt(c *client, args []string, unit time.Duration) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		return nil, err
+		c.reply(resp.Error(notAnInteger()))
+		return
 	}
-	line = strings.TrimSpace(line)
-	if line == "" || !strings.HasPrefix(line, "*") {
-		writeError(conn, "invalid format")
-		return nil, errors.New("invalid format")
+	ms, ok := expireMillis(n, unit, 0)
+	if !ok {
+		c.reply(resp.Error(invalidExpireTime(args[0])))
+		return
 	}
-	n := parseLength(line)
-	args := []string{}
-	for i := 0; i < n; i++ {
-		_, err = reader.ReadString('\n') // skip $len
-		if err != nil {
-			return nil, err
-		}
-		arg, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, err
-		}
-		args = append(args, strings.TrimSpace(arg))
+	if c.db.Expire(args[1], time.UnixMilli(ms)) {
+		c.reply(resp.Integer(1))
+	} else {
+		c.reply(resp.Integer(0))
 	}
-	return args, nil
 }
 
-func parseLength(s string) int {
-	var n int
-	fmt.Sscanf(s, "*%d", &n)
-	return n
+func handleDel(c *client, args []string) {
+	if config.LazyfreeLazyUserDel.Load() {
+		handleUnlink(c, args)
+		return
+	}
+	c.reply(resp.Integer(c.db.Delete(args[1:]...)))
 }
 
-func writeError(conn net.Conn, msg string) {
-	conn.Write([]byte("-ERR " + msg + "\r\n"))
+func handleUnlink(c *client, args []string) {
+	c.reply(resp.Integer(c.db.Unlink(args[1:]...)))
 }
 
-func writeSimpleString(conn net.Conn, msg string) {
-	conn.Write([]byte("+" + msg + "\r\n"))
+func handleTouch(c *client, args []string) {
+	c.reply(resp.Integer(c.db.Touch(args[1:]...)))
 }
 
-func writeBulkString(conn net.Conn, s string) {
-	if s == "" {
-		conn.Write([]byte("$-1\r\n"))
-		return
+func handlePersist(c *client, args []string) {
+	if c.db.Persist(args[1]) {
+		c.reply(resp.Integer(1))
+	} else {
+		c.reply(resp.Integer(0))
 	}
-	conn.Write([]byte(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)))
 }
 
-
-func writeInteger(conn net.Conn, n int) {
-	conn.Write([]byte(fmt.Sprintf(":%d\r\n", n)))
+func handleTTL(c *client, args []string, unit time.Duration) {
+	deadline, ok := c.db.Deadline(args[1])
+	switch {
+	case !ok:
+		c.reply(resp.Integer(-2))
+	case deadline.IsZero():
+		c.reply(resp.Integer(-1))
+	default:
+		// In milliseconds rather than a Duration, which saturates for
+		// deadlines further out than EXPIREAT can set.
+		remaining := deadline.UnixMilli() - c.srv.clock.Now().UnixMilli()
+		if unit == time.Second {
+			remaining = (remaining + 500) / 1000
+		}
+		c.reply(resp.Integer(remaining))
+	}
 }
 
-func writeNull(conn net.Conn) {
-	conn.Write([]byte("$-1\r\n"))
+func (c *client) reply(v resp.Value) {
+	if c.hook != nil {
+		c.hook.Reply = v
+	}
+	if e, ok := v.(resp.Error); ok {
+		c.errReply = e
+	}
+	if c.script {
+		c.scriptReply = v
+		return
+	}
+	if c.replica != nil || c.master {
+		return
+	}
+	c.out.Encode(v)
 }
 
-func wakeUpFirstBlocking(key string) {
-	if list, ok := blockings[key]; ok && len(list) > 0 {
-		req := list[0]
-		blockings[key] = list[1:]
-		select {
-		case req.Ch <- key:
-		default:
-		}
+// replyStoreError reports an error returned by the store.
+func (c *client) replyStoreError(err error) {
+	if errors.Is(err, store.ErrWrongType) {
+		c.reply(resp.Error(wrongType()))
+		return
 	}
-}
\ No newline at end of file
+	if errors.Is(err, store.ErrBusyKey) {
+		c.reply(resp.Error(err.Error()))
+		return
+	}
+	c.reply(resp.Error("ERR " + err.Error()))
+}
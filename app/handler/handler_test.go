@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/clock"
+	"redis/app/resp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentStringCommands runs SET, GET and DEL from many
+// connections over the same few keys. Run with -race it catches any
+// access to the keyspace that skips the lock.
+func TestConcurrentStringCommands(t *testing.T) {
+	s := newTestServer(t)
+	const clients, rounds = 50, 200
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		c := dial(t, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				key := fmt.Sprintf("key%d", j%8)
+				var args []string
+				switch (i + j) % 3 {
+				case 0:
+					args = []string{"SET", key, fmt.Sprintf("%d-%d", i, j)}
+				case 1:
+					args = []string{"GET", key}
+				default:
+					args = []string{"DEL", key}
+				}
+				v, err := c.try(args...)
+				if err != nil {
+					t.Errorf("%q: %v", args, err)
+					return
+				}
+				switch v.(type) {
+				case resp.SimpleString, resp.BulkString, resp.Null, resp.Integer:
+				default:
+					t.Errorf("%q = %s", args, show(v))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestExpireOutOfRange checks that a relative expiry too large for unix
+// milliseconds is refused, rather than wrapping into the past and
+// deleting the key.
+func TestExpireOutOfRange(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "k", "v")
+	for _, args := range [][]string{
+		{"EXPIRE", "k", "9223372036854775"},
+		{"EXPIRE", "k", "-9223372036854776"},
+		{"PEXPIRE", "k", "9223372036854775807"},
+	} {
+		want := resp.Error(invalidExpireTime(args[0]))
+		c.expect(want, args...)
+	}
+	c.expect(bulk("v"), "GET", "k")
+	c.expect(resp.Integer(-1), "TTL", "k")
+
+	// A large deadline that still fits is kept.
+	c.expect(resp.Integer(1), "EXPIRE", "k", "9223372036854")
+	c.expect(bulk("v"), "GET", "k")
+	// A negative one deletes the key, as before.
+	c.expect(resp.Integer(1), "EXPIRE", "k", "-1")
+	c.expect(resp.Null{}, "GET", "k")
+}
+
+func TestExpireAtOutOfRange(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "k", "v")
+	for _, args := range [][]string{
+		{"EXPIREAT", "k", "9223372036854776"},
+		{"EXPIREAT", "k", "-9223372036854776"},
+	} {
+		c.expect(resp.Error(invalidExpireTime(args[0])), args...)
+	}
+	c.expect(resp.Integer(-1), "TTL", "k")
+
+	// The latest deadline there is, which TTL still reports in full.
+	c.expect(resp.Integer(1), "PEXPIREAT", "k", "9223372036854775807")
+	want := resp.Integer(9223372036854775807 - time.Now().UnixMilli())
+	if got, ok := c.do("PTTL", "k").(resp.Integer); !ok || got > want || got < want-5000 {
+		t.Errorf("PTTL = %v, want about %d", got, want)
+	}
+	c.expect(bulk("v"), "GET", "k")
+}
+
+// TestTTLOnManualClock counts a TTL down on a clock only the test moves,
+// so the deadline is hit to the millisecond however slow the machine.
+func TestTTLOnManualClock(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	clk := clock.NewManual(epoch)
+	c := dial(t, newTestServerClock(t, clk))
+	c.expect(ok(), "SET", "k", "v", "EX", "100")
+	c.expect(resp.Integer(100), "TTL", "k")
+
+	clk.Advance(40 * time.Second)
+	c.expect(resp.Integer(60), "TTL", "k")
+	c.expect(resp.Integer(60000), "PTTL", "k")
+	clk.Advance(59999 * time.Millisecond)
+	c.expect(resp.Integer(1), "PTTL", "k")
+	c.expect(bulk("v"), "GET", "k")
+	clk.Advance(time.Millisecond)
+	c.expect(resp.Null{}, "GET", "k")
+	c.expect(resp.Integer(-2), "TTL", "k")
+
+	// EXPIREAT counts from the clock's idea of now, not the system's.
+	c.expect(ok(), "SET", "k", "v")
+	c.expect(resp.Integer(1), "EXPIREAT", "k", fmt.Sprint(clk.Now().Unix()+10))
+	c.expect(resp.Integer(10), "TTL", "k")
+}
+
+// TestMalformedSetStoresNothing checks each malformed SET gets the error
+// redis-server gives and leaves the key unset.
+func TestMalformedSetStoresNothing(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	syntax := resp.Error(syntaxError())
+	notInt := resp.Error(notAnInteger())
+	badExpire := resp.Error(invalidExpireTime("SET"))
+	for _, tc := range []struct {
+		opts []string
+		want resp.Value
+	}{
+		{[]string{"EX"}, syntax},
+		{[]string{"PXAT"}, syntax},
+		{[]string{"EX", "1", "PX", "1"}, syntax},
+		{[]string{"EX", "10", "EX", "10"}, syntax},
+		{[]string{"EX", "10", "FOO"}, syntax},
+		{[]string{"NOSUCH"}, syntax},
+		{[]string{"EX", "abc"}, notInt},
+		{[]string{"PX", "1.5"}, notInt},
+		{[]string{"EX", "99999999999999999999"}, notInt},
+		{[]string{"EX", "0"}, badExpire},
+		{[]string{"ex", "-1"}, badExpire},
+		{[]string{"PX", "0"}, badExpire},
+		{[]string{"EXAT", "0"}, badExpire},
+		{[]string{"EX", "9223372036854775807"}, badExpire},
+		{[]string{"PX", "9223372036854775807"}, badExpire},
+	} {
+		args := append([]string{"SET", "k", "v"}, tc.opts...)
+		c.expect(tc.want, args...)
+		c.expect(resp.Null{}, "GET", "k")
+	}
+
+	// Nor does one overwrite or give a deadline to a key already there.
+	c.expect(ok(), "SET", "k", "old")
+	c.expect(syntax, "SET", "k", "new", "EX", "10", "FOO")
+	c.expect(bulk("old"), "GET", "k")
+	c.expect(resp.Integer(-1), "TTL", "k")
+}
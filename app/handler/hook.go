@@ -0,0 +1,136 @@
+package handler
+
+import "redis/app/resp"
+
+// CommandContext is what a Hook sees of a command: who sent it, what it
+// is and, once next has run it, how it was answered.
+type CommandContext struct {
+	// ClientID and ClientName identify the connection, as CLIENT LIST
+	// shows them. Addr is its remote address, empty for the AOF replay
+	// and for commands a script runs.
+	ClientID   int64
+	ClientName string
+	Addr       string
+	// User is the ACL user the client is logged in as.
+	User string
+	// Command is the command's name in lower case. Args is the whole
+	// request, name included, as the client sent it; hooks mustn't
+	// modify it.
+	Command string
+	Args    []string
+	// Replayed is set for commands read back from the AOF or sent by our
+	// master, which only hooks added with UseReplayed see.
+	Replayed bool
+	// Reply is the last value the command replied with, once next
+	// returns. A hook that doesn't call next may set it to answer in the
+	// command's place.
+	Reply resp.Value
+
+	ran bool
+}
+
+// HandlerFunc runs the rest of the chain: the hooks added after the
+// current one, then the command itself.
+type HandlerFunc func(ctx *CommandContext) error
+
+// A Hook runs around commands. It calls next to go on with the command
+// and can look at ctx.Reply once next returns. To veto a command it
+// returns without calling next, either with an error, sent to the
+// client as an ERR reply, or with ctx.Reply set to the reply to send.
+// Once next has run the client has its reply, and what the hook returns
+// is ignored.
+type Hook func(ctx *CommandContext, next HandlerFunc) error
+
+type hook struct {
+	fn       Hook
+	replayed bool
+}
+
+// Use adds h around every command clients send, scripts included. Hooks
+// run in the order they were added, the first outermost. It is safe to
+// call while serving; commands already running keep the hooks they
+// started with.
+//
+// A hook that keeps DEBUG off a production server:
+//
+//	srv.Use(func(ctx *handler.CommandContext, next handler.HandlerFunc) error {
+//		if production && ctx.Command == "debug" {
+//			return errors.New("DEBUG is disabled in production")
+//		}
+//		return next(ctx)
+//	})
+//
+// And one that records how long each command takes:
+//
+//	srv.Use(func(ctx *handler.CommandContext, next handler.HandlerFunc) error {
+//		start := time.Now()
+//		err := next(ctx)
+//		latencies.Observe(ctx.Command, time.Since(start))
+//		return err
+//	})
+func (s *Server) Use(h Hook) {
+	s.addHook(hook{fn: h})
+}
+
+// UseReplayed is Use for a hook that also runs around the commands
+// replayed from the AOF at startup and those our master sends.
+func (s *Server) UseReplayed(h Hook) {
+	s.addHook(hook{fn: h, replayed: true})
+}
+
+func (s *Server) addHook(h hook) {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	var hooks []hook
+	if old := s.hooks.Load(); old != nil {
+		hooks = append(hooks, *old...)
+	}
+	hooks = append(hooks, h)
+	s.hooks.Store(&hooks)
+}
+
+// execute runs cmd for c, through the hooks if any were added.
+func (c *client) execute(cmd *command, args []string) {
+	hooks := c.srv.hooks.Load()
+	if hooks == nil {
+		cmd.handler(c, args)
+		return
+	}
+	ctx := &CommandContext{
+		ClientID: c.id,
+		User:     c.user.Name(),
+		Command:  cmd.name,
+		Args:     args,
+		Replayed: c.replay || c.master,
+	}
+	c.mu.Lock()
+	ctx.ClientName = c.name
+	c.mu.Unlock()
+	if c.conn != nil {
+		ctx.Addr = c.conn.RemoteAddr().String()
+	}
+	next := func(ctx *CommandContext) error {
+		ctx.ran = true
+		c.hook = ctx
+		cmd.handler(c, args)
+		c.hook = nil
+		return nil
+	}
+	for i := len(*hooks) - 1; i >= 0; i-- {
+		h, inner := (*hooks)[i], next
+		if ctx.Replayed && !h.replayed {
+			continue
+		}
+		next = func(ctx *CommandContext) error { return h.fn(ctx, inner) }
+	}
+	err := next(ctx)
+	switch {
+	case ctx.ran:
+	case err != nil:
+		c.reply(resp.Error("ERR " + err.Error()))
+	case ctx.Reply != nil:
+		c.reply(ctx.Reply)
+	default:
+		c.reply(resp.Error("ERR command rejected by a hook"))
+	}
+}
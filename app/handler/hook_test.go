@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"redis/app/resp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHookRejectsDebugInProduction is the first example in Use's doc
+// comment: a hook that vetoes DEBUG and lets everything else through.
+func TestHookRejectsDebugInProduction(t *testing.T) {
+	s := newTestServer(t)
+	production := true
+	s.Use(func(ctx *CommandContext, next HandlerFunc) error {
+		if production && ctx.Command == "debug" {
+			return errors.New("DEBUG is disabled in production")
+		}
+		return next(ctx)
+	})
+	c := dial(t, s)
+	c.expect(ok(), "SET", "k", "v")
+	id := c.info("replication", "master_replid")
+	c.expect(resp.Error("ERR DEBUG is disabled in production"), "DEBUG", "CHANGE-REPL-ID")
+	c.expect(resp.Error("ERR DEBUG is disabled in production"), "debug", "sleep", "0")
+	if got := c.info("replication", "master_replid"); got != id {
+		t.Error("the vetoed DEBUG CHANGE-REPL-ID ran anyway")
+	}
+	c.expect(bulk("v"), "GET", "k")
+}
+
+// TestHookRecordsLatency is the second example in Use's doc comment: a
+// hook that times each command. It also sees each command's reply.
+func TestHookRecordsLatency(t *testing.T) {
+	s := newTestServer(t)
+	type call struct {
+		command string
+		reply   resp.Value
+		took    time.Duration
+	}
+	var mu sync.Mutex
+	var calls []call
+	s.Use(func(ctx *CommandContext, next HandlerFunc) error {
+		start := time.Now()
+		err := next(ctx)
+		mu.Lock()
+		calls = append(calls, call{ctx.Command, ctx.Reply, time.Since(start)})
+		mu.Unlock()
+		return err
+	})
+	c := dial(t, s)
+	c.expect(ok(), "SET", "k", "v")
+	c.expect(bulk("v"), "GET", "k")
+	c.expect(resp.Null{}, "GET", "missing")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []call{{"set", ok(), 0}, {"get", bulk("v"), 0}, {"get", resp.Null{}, 0}}
+	if len(calls) != len(want) {
+		t.Fatalf("recorded %d commands, want %d", len(calls), len(want))
+	}
+	for i, got := range calls {
+		if got.command != want[i].command || !sameValue(got.reply, want[i].reply) || got.took <= 0 {
+			t.Errorf("call %d recorded as %s %s in %v, want %s %s", i, got.command, show(got.reply), got.took, want[i].command, show(want[i].reply))
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"redis/app/config"
+	"redis/app/resp"
+	"redis/app/store"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisVersion = "7.2.4"
+
+// infoSection is one "# Name" block of the INFO report. Sections that
+// aren't default are only included when asked for by name or by "all" or
+// "everything".
+type infoSection struct {
+	name       string
+	notDefault bool
+	fields     func(c *client, stats store.Stats) []infoField
+}
+
+type infoField struct {
+	name, value string
+}
+
+var infoSections = []infoSection{
+	{name: "Server", fields: infoServer},
+	{name: "Clients", fields: infoClients},
+	{name: "Memory", fields: infoMemory},
+	{name: "Persistence", fields: infoPersistence},
+	{name: "Stats", fields: infoStats},
+	{name: "Replication", fields: infoReplication},
+	{name: "Commandstats", notDefault: true, fields: infoCommandstats},
+	{name: "Latencystats", notDefault: true, fields: infoLatencystats},
+	{name: "Cluster", fields: infoCluster},
+	{name: "Keyspace", fields: infoKeyspace},
+}
+
+func handleInfo(c *client, args []string) {
+	all := len(args) == 1
+	wanted := make(map[string]bool)
+	for _, arg := range args[1:] {
+		switch arg = strings.ToLower(arg); arg {
+		case "all", "everything":
+			wanted["all"] = true
+		case "default":
+			all = true
+		default:
+			wanted[arg] = true
+		}
+	}
+
+	stats := c.db.Stats()
+	var b strings.Builder
+	for _, sec := range infoSections {
+		name := strings.ToLower(sec.name)
+		if !wanted[name] && !wanted["all"] && !(all && !sec.notDefault) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + sec.name + "\r\n")
+		for _, f := range sec.fields(c, stats) {
+			b.WriteString(f.name + ":" + f.value + "\r\n")
+		}
+	}
+	c.reply(resp.BulkString(b.String()))
+}
+
+func infoServer(c *client, _ store.Stats) []infoField {
+	uptime := int64(time.Since(c.srv.startTime) / time.Second)
+	return []infoField{
+		{"redis_version", redisVersion},
+		{"redis_mode", "standalone"},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"go_version", runtime.Version()},
+		{"process_id", strconv.Itoa(os.Getpid())},
+		{"run_id", c.srv.runID},
+		{"tcp_port", strconv.FormatInt(c.srv.port, 10)},
+		{"uptime_in_seconds", strconv.FormatInt(uptime, 10)},
+		{"uptime_in_days", strconv.FormatInt(uptime/86400, 10)},
+		{"hz", strconv.Itoa(serverHz)},
+	}
+}
+
+func infoClients(c *client, stats store.Stats) []infoField {
+	return []infoField{
+		{"connected_clients", strconv.FormatInt(c.srv.ConnectedClients(), 10)},
+		{"maxclients", strconv.FormatInt(config.MaxClients.Load(), 10)},
+		{"blocked_clients", strconv.FormatInt(stats.BlockedClients, 10)},
+	}
+}
+
+func infoMemory(c *client, stats store.Stats) []infoField {
+	used := c.db.UsedMemory()
+	maxMemory := config.MaxMemory.Load()
+	return []infoField{
+		{"used_memory", strconv.FormatInt(used, 10)},
+		{"used_memory_human", humanBytes(used)},
+		{"maxmemory", strconv.FormatInt(maxMemory, 10)},
+		{"maxmemory_human", humanBytes(maxMemory)},
+		{"maxmemory_policy", config.MaxMemoryPolicy()},
+		{"lazyfree_pending_objects", strconv.FormatInt(stats.LazyfreePending, 10)},
+	}
+}
+
+func infoPersistence(c *client, _ store.Stats) []infoField {
+	status := "ok"
+	if !c.srv.lastBgsaveOK.Load() {
+		status = "err"
+	}
+	a := &c.srv.aof
+	a.mu.Lock()
+	rewriting, size, baseSize := a.rewriting, a.size, a.baseSize
+	rewriteStatus := "ok"
+	if !a.lastRewriteOK {
+		rewriteStatus = "err"
+	}
+	a.mu.Unlock()
+	fields := []infoField{
+		{"rdb_changes_since_last_save", strconv.FormatInt(c.srv.changesSinceSave(), 10)},
+		{"rdb_bgsave_in_progress", boolInt(c.srv.bgsaveInProgress.Load())},
+		{"rdb_last_save_time", strconv.FormatInt(c.srv.lastSave.Load(), 10)},
+		{"rdb_last_bgsave_status", status},
+		{"aof_enabled", boolInt(config.AppendOnly.Load())},
+		{"aof_rewrite_in_progress", boolInt(rewriting)},
+		{"aof_last_bgrewrite_status", rewriteStatus},
+	}
+	if config.AppendOnly.Load() {
+		fields = append(fields,
+			infoField{"aof_current_size", strconv.FormatInt(size, 10)},
+			infoField{"aof_base_size", strconv.FormatInt(baseSize, 10)})
+	}
+	return fields
+}
+
+func boolInt(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func infoStats(c *client, stats store.Stats) []infoField {
+	return []infoField{
+		{"total_connections_received", strconv.FormatInt(c.srv.totalConnections.Load(), 10)},
+		{"total_commands_processed", strconv.FormatInt(c.srv.totalCommands.Load(), 10)},
+		{"rejected_connections", strconv.FormatInt(c.srv.rejectedConnections.Load(), 10)},
+		{"client_query_buffer_limit_disconnections", strconv.FormatInt(c.srv.queryBufferDisconnections.Load(), 10)},
+		{"client_output_buffer_limit_disconnections", strconv.FormatInt(c.srv.outputBufferDisconnections.Load(), 10)},
+		{"expired_keys", strconv.FormatInt(stats.ExpiredKeys, 10)},
+		{"evicted_keys", strconv.FormatInt(stats.EvictedKeys, 10)},
+		{"keyspace_hits", strconv.FormatInt(stats.KeyspaceHits, 10)},
+		{"keyspace_misses", strconv.FormatInt(stats.KeyspaceMisses, 10)},
+	}
+}
+
+func infoReplication(c *client, _ store.Stats) []infoField {
+	fields := []infoField{{"role", "master"}}
+	// A replica reports the stream it follows, once it has synced, as
+	// redis-server does, so a client can tell how far behind it is.
+	var linkID string
+	var linkOffset int64
+	if c.srv.isReplica() {
+		fields = infoReplicaLink(c.srv)
+		link := &c.srv.master
+		link.mu.Lock()
+		linkID, linkOffset = link.replID, link.offset.Load()
+		link.mu.Unlock()
+	}
+	repl := &c.srv.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	replID, offset := repl.id, repl.offset
+	if linkID != "" {
+		replID, offset = linkID, linkOffset
+	}
+	fields = append(fields, infoField{"connected_slaves", strconv.Itoa(len(repl.replicas))})
+	replicas := make([]*replica, 0, len(repl.replicas))
+	for r := range repl.replicas {
+		replicas = append(replicas, r)
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].c.id < replicas[j].c.id })
+	now := time.Now().Unix()
+	for i, r := range replicas {
+		r.mu.Lock()
+		state := "wait_bgsave"
+		if r.online {
+			state = "online"
+		}
+		r.mu.Unlock()
+		host, _, _ := net.SplitHostPort(r.c.conn.RemoteAddr().String())
+		fields = append(fields, infoField{fmt.Sprintf("slave%d", i),
+			fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d,lag=%d", host, r.port, state, r.ackOffset.Load(), now-r.lastAck.Load())})
+	}
+	backlogSize, firstByte, histlen := config.ReplBacklogSize.Load(), int64(0), 0
+	if b := repl.backlog; b != nil {
+		backlogSize, histlen = int64(len(b.buf)), b.held
+		firstByte = repl.offset - int64(histlen) + 1
+	}
+	return append(fields,
+		infoField{"master_replid", replID},
+		infoField{"master_repl_offset", strconv.FormatInt(offset, 10)},
+		infoField{"repl_backlog_active", boolInt(repl.backlog != nil)},
+		infoField{"repl_backlog_size", strconv.FormatInt(backlogSize, 10)},
+		infoField{"repl_backlog_first_byte_offset", strconv.FormatInt(firstByte, 10)},
+		infoField{"repl_backlog_histlen", strconv.Itoa(histlen)},
+	)
+}
+
+func infoCluster(_ *client, _ store.Stats) []infoField {
+	return []infoField{{"cluster_enabled", "0"}}
+}
+
+// infoKeyspace leaves out an empty database, as redis-server does.
+func infoKeyspace(_ *client, stats store.Stats) []infoField {
+	if stats.Keys == 0 {
+		return nil
+	}
+	return []infoField{
+		{"db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", stats.Keys, stats.Expires)},
+	}
+}
+
+// humanBytes formats n the way the *_human INFO fields do.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	value, suffix := float64(n), ""
+	for _, s := range []string{"K", "M", "G", "T", "P"} {
+		if value < unit {
+			break
+		}
+		value /= unit
+		suffix = s
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + suffix
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"redis/app/config"
+	"redis/app/resp"
+	"redis/app/store"
+	"strconv"
+	"strings"
+)
+
+// handleLCS implements LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len]
+// [WITHMATCHLEN]. Missing keys count as empty strings.
+func handleLCS(c *client, args []string) {
+	var a, b string
+	var err error
+	c.db.Atomic(func(tx store.Store) {
+		if a, _, err = tx.Get(args[1]); err == nil {
+			b, _, err = tx.Get(args[2])
+		}
+	})
+	if err != nil {
+		c.reply(resp.Error("ERR The specified keys must contain string values"))
+		return
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int64
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "IDX":
+			getIdx = true
+		case opt == "LEN":
+			getLen = true
+		case opt == "WITHMATCHLEN":
+			withMatchLen = true
+		case opt == "MINMATCHLEN" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				c.reply(resp.Error(notAnInteger()))
+				return
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+	}
+	if getIdx && getLen {
+		c.reply(resp.Error("ERR If you want both the length and indexes, please just use IDX."))
+		return
+	}
+	// The table holds a uint32 per pair of prefixes.
+	if rows := int64(len(a)) + 1; rows > config.ProtoMaxBulkLen.Load()/4/(int64(len(b))+1) {
+		c.reply(resp.Error("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len"))
+		return
+	}
+
+	t := newLCSTable(a, b)
+	switch {
+	case getLen:
+		c.reply(resp.Integer(t.at(len(a), len(b))))
+	case getIdx:
+		matches := resp.Array{}
+		for _, m := range t.matches() {
+			if int64(m.length()) < minMatchLen {
+				continue
+			}
+			match := resp.Array{
+				resp.Array{resp.Integer(m.aStart), resp.Integer(m.aEnd)},
+				resp.Array{resp.Integer(m.bStart), resp.Integer(m.bEnd)},
+			}
+			if withMatchLen {
+				match = append(match, resp.Integer(m.length()))
+			}
+			matches = append(matches, match)
+		}
+		c.reply(resp.Map{
+			{Key: resp.BulkString("matches"), Value: matches},
+			{Key: resp.BulkString("len"), Value: resp.Integer(t.at(len(a), len(b)))},
+		})
+	default:
+		c.reply(resp.BulkString(t.subsequence()))
+	}
+}
+
+// lcsTable holds, for every pair of prefixes of a and b, the length of
+// their longest common subsequence.
+type lcsTable struct {
+	a, b  string
+	cells []uint32
+}
+
+func newLCSTable(a, b string) *lcsTable {
+	t := &lcsTable{a: a, b: b, cells: make([]uint32, (len(a)+1)*(len(b)+1))}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				t.set(i, j, t.at(i-1, j-1)+1)
+			} else {
+				t.set(i, j, max(t.at(i-1, j), t.at(i, j-1)))
+			}
+		}
+	}
+	return t
+}
+
+// at is the LCS length of a[:i] and b[:j].
+func (t *lcsTable) at(i, j int) uint32 {
+	return t.cells[i*(len(t.b)+1)+j]
+}
+
+func (t *lcsTable) set(i, j int, n uint32) {
+	t.cells[i*(len(t.b)+1)+j] = n
+}
+
+// lcsMatch is a run of the subsequence that is contiguous in both
+// strings, as inclusive byte ranges.
+type lcsMatch struct {
+	aStart, aEnd, bStart, bEnd int
+}
+
+func (m lcsMatch) length() int {
+	return m.aEnd - m.aStart + 1
+}
+
+// walk follows one longest common subsequence from the ends of a and b
+// back to their starts, calling fn with the position in each of every
+// byte in it, last first. Ties go the way redis-server's do, so the
+// same subsequence and matches come out.
+func (t *lcsTable) walk(fn func(i, j int)) {
+	i, j := len(t.a), len(t.b)
+	for i > 0 && j > 0 {
+		switch {
+		case t.a[i-1] == t.b[j-1]:
+			fn(i-1, j-1)
+			i--
+			j--
+		case t.at(i-1, j) > t.at(i, j-1):
+			i--
+		default:
+			j--
+		}
+	}
+}
+
+func (t *lcsTable) subsequence() string {
+	out := make([]byte, t.at(len(t.a), len(t.b)))
+	n := len(out)
+	t.walk(func(i, _ int) {
+		n--
+		out[n] = t.a[i]
+	})
+	return string(out)
+}
+
+// matches splits the subsequence into its runs, last first as LCS IDX
+// reports them.
+func (t *lcsTable) matches() []lcsMatch {
+	var out []lcsMatch
+	t.walk(func(i, j int) {
+		if n := len(out); n > 0 && out[n-1].aStart == i+1 && out[n-1].bStart == j+1 {
+			out[n-1].aStart, out[n-1].bStart = i, j
+			return
+		}
+		out = append(out, lcsMatch{i, i, j, j})
+	})
+	return out
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strings"
+	"testing"
+)
+
+// lcsIdx is the reply to LCS IDX: matches, last first, and the length.
+func lcsIdx(length int64, matches ...resp.Array) resp.Map {
+	arr := resp.Array{}
+	for _, m := range matches {
+		arr = append(arr, m)
+	}
+	return resp.Map{
+		{Key: bulk("matches"), Value: arr},
+		{Key: bulk("len"), Value: resp.Integer(length)},
+	}
+}
+
+// lcsRange is one match of an LCS IDX reply: the inclusive ranges in
+// each string, and the length if WITHMATCHLEN asked for it.
+func lcsRange(aStart, aEnd, bStart, bEnd int64, length ...int64) resp.Array {
+	m := resp.Array{
+		resp.Array{resp.Integer(aStart), resp.Integer(aEnd)},
+		resp.Array{resp.Integer(bStart), resp.Integer(bEnd)},
+	}
+	for _, n := range length {
+		m = append(m, resp.Integer(n))
+	}
+	return m
+}
+
+func TestLCS(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "a", "ohmytext")
+	c.expect(ok(), "SET", "b", "mynewtext")
+	c.expect(ok(), "SET", "x", "abc")
+	c.expect(ok(), "SET", "y", "xyz")
+	for _, tc := range []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"a", "b"}, bulk("mytext")},
+		{[]string{"b", "a"}, bulk("mytext")},
+		{[]string{"a", "a"}, bulk("ohmytext")},
+		{[]string{"x", "y"}, bulk("")},
+		{[]string{"a", "missing"}, bulk("")},
+		{[]string{"missing", "missing"}, bulk("")},
+
+		{[]string{"a", "b", "LEN"}, resp.Integer(6)},
+		{[]string{"a", "b", "len"}, resp.Integer(6)},
+		{[]string{"x", "y", "LEN"}, resp.Integer(0)},
+		{[]string{"a", "missing", "LEN"}, resp.Integer(0)},
+
+		{[]string{"a", "b", "IDX"}, lcsIdx(6, lcsRange(4, 7, 5, 8), lcsRange(2, 3, 0, 1))},
+		{[]string{"a", "b", "IDX", "WITHMATCHLEN"}, lcsIdx(6, lcsRange(4, 7, 5, 8, 4), lcsRange(2, 3, 0, 1, 2))},
+		{[]string{"a", "b", "IDX", "MINMATCHLEN", "4"}, lcsIdx(6, lcsRange(4, 7, 5, 8))},
+		{[]string{"a", "b", "IDX", "MINMATCHLEN", "3", "WITHMATCHLEN"}, lcsIdx(6, lcsRange(4, 7, 5, 8, 4))},
+		{[]string{"a", "b", "WITHMATCHLEN", "MINMATCHLEN", "2", "IDX"}, lcsIdx(6, lcsRange(4, 7, 5, 8, 4), lcsRange(2, 3, 0, 1, 2))},
+		{[]string{"a", "b", "IDX", "MINMATCHLEN", "5"}, lcsIdx(6)},
+		// A negative minimum is no minimum.
+		{[]string{"a", "b", "IDX", "MINMATCHLEN", "-1"}, lcsIdx(6, lcsRange(4, 7, 5, 8), lcsRange(2, 3, 0, 1))},
+		{[]string{"x", "y", "IDX"}, lcsIdx(0)},
+		// Without IDX the match options change nothing.
+		{[]string{"a", "b", "MINMATCHLEN", "4", "WITHMATCHLEN"}, bulk("mytext")},
+		{[]string{"a", "b", "LEN", "MINMATCHLEN", "4"}, resp.Integer(6)},
+	} {
+		c.expect(tc.want, append([]string{"LCS"}, tc.args...)...)
+	}
+}
+
+func TestLCSErrors(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "a", "ohmytext")
+	c.expect(resp.Integer(1), "RPUSH", "list", "x")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"a", "list"}, "ERR The specified keys must contain string values"},
+		{[]string{"list", "a", "LEN"}, "ERR The specified keys must contain string values"},
+		{[]string{"a", "a", "LEN", "IDX"}, "ERR If you want both the length and indexes, please just use IDX."},
+		{[]string{"a", "a", "IDX", "MINMATCHLEN", "x"}, notAnInteger()},
+		{[]string{"a", "a", "IDX", "MINMATCHLEN"}, syntaxError()},
+		{[]string{"a", "a", "BOGUS"}, syntaxError()},
+		{[]string{"a"}, wrongArity("lcs")},
+	} {
+		c.expect(resp.Error(tc.want), append([]string{"LCS"}, tc.args...)...)
+	}
+}
+
+// TestLCSMemoryCap checks LCS refuses inputs whose table, a uint32 per
+// pair of prefixes, would take more than proto-max-bulk-len bytes.
+func TestLCSMemoryCap(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	setConfig(t, "proto-max-bulk-len", "1mb")
+	// 512 * 512 cells of 4 bytes is exactly 1mb.
+	c.expect(ok(), "SET", "fits", strings.Repeat("a", 511))
+	c.expect(ok(), "SET", "over", strings.Repeat("a", 512))
+	c.expect(resp.Integer(511), "LCS", "fits", "fits", "LEN")
+	for _, args := range [][]string{
+		{"over", "fits"},
+		{"fits", "over", "LEN"},
+		{"over", "over", "IDX"},
+	} {
+		c.expect(resp.Error("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len"), append([]string{"LCS"}, args...)...)
+	}
+	// An empty string makes a table of one row or column.
+	c.expect(resp.Integer(0), "LCS", "over", "missing", "LEN")
+}
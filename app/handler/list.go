@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strconv"
+)
+
+func handleLPush(c *client, args []string) {
+	n, err := c.db.LPush(args[1], args[2:]...)
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
+
+func handleRPush(c *client, args []string) {
+	n, err := c.db.RPush(args[1], args[2:]...)
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
+
+func handleLRange(c *client, args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	end, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+
+	sublist, err := c.db.LRange(args[1], start, end)
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.BulkStrings(sublist))
+}
+
+func handleLLen(c *client, args []string) {
+	n, err := c.db.LLen(args[1])
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
+
+func handleLPop(c *client, args []string) {
+	if len(args) > 3 {
+		c.reply(resp.Error(wrongArity("LPOP")))
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			c.reply(resp.Error(notPositive()))
+			return
+		}
+		count = n
+	}
+
+	values, ok, err := c.db.LPop(args[1], count)
+	switch {
+	case err != nil:
+		c.replyStoreError(err)
+	case !ok && len(args) == 3:
+		c.reply(resp.NullArray{})
+	case !ok:
+		c.reply(resp.Null{})
+	case len(args) == 3:
+		c.reply(resp.BulkStrings(values))
+	default:
+		c.reply(resp.BulkString(values[0]))
+	}
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/resp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentLPopDeliversOnce has 100 connections race to LPOP a
+// 100-element list. Every element must go to exactly one of them.
+func TestConcurrentLPopDeliversOnce(t *testing.T) {
+	s := newTestServer(t)
+	const n = 100
+	args := []string{"RPUSH", "l"}
+	for i := 0; i < n; i++ {
+		args = append(args, fmt.Sprint(i))
+	}
+	dial(t, s).expect(resp.Integer(n), args...)
+
+	clients := make([]*testClient, n)
+	for i := range clients {
+		clients[i] = dial(t, s)
+	}
+	var mu sync.Mutex
+	got := make(map[string]int)
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.try("LPOP", "l")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			elem, ok := v.(resp.BulkString)
+			if !ok {
+				t.Errorf("LPOP = %s", show(v))
+				return
+			}
+			mu.Lock()
+			got[string(elem)]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		if k := got[fmt.Sprint(i)]; k != 1 {
+			t.Errorf("element %d delivered %d times", i, k)
+		}
+	}
+	dial(t, s).expect(resp.Null{}, "LPOP", "l")
+}
+
+func TestPushOntoStringFails(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "k", "v")
+	wrongType := resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	c.expect(wrongType, "LPUSH", "k", "x")
+	c.expect(wrongType, "RPUSH", "k", "x", "y")
+	c.expect(bulk("v"), "GET", "k")
+}
+
+// TestPushReplyCountsWhatWaitersLeft checks a push replies with the length
+// left after blocked clients took their elements.
+func TestPushReplyCountsWhatWaitersLeft(t *testing.T) {
+	s := newTestServer(t)
+	waiter := blockedClients(t, s, "q", 1)[0]
+	c := dial(t, s)
+	c.expect(resp.Integer(2), "RPUSH", "q", "a", "b", "c")
+	if got, err := waiter.read(); err != nil || !sameValue(got, blpopReply("q", "a")) {
+		t.Errorf("waiter got %v, %v", got, err)
+	}
+	c.expect(resp.StringArray{"b", "c"}, "LRANGE", "q", "0", "-1")
+}
+
+// fillList pushes n elements "0" to "n-1" onto key, in batches.
+func fillList(c *testClient, key string, n int) {
+	for i := 0; i < n; {
+		args := []string{"RPUSH", key}
+		for ; i < n && len(args) < 1002; i++ {
+			args = append(args, strconv.Itoa(i))
+		}
+		c.do(args...)
+	}
+}
+
+// TestLRangeDuringLPops reads a whole list while other connections pop
+// from it. Each reply must be what the list held at one moment: a run of
+// consecutive elements ending at the last one, nothing torn or repeated.
+func TestLRangeDuringLPops(t *testing.T) {
+	s := newTestServer(t)
+	const n = 2000
+	fillList(dial(t, s), "l", n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c := dial(t, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, err := c.try("LPOP", "l", "10")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				switch v.(type) {
+				case resp.Null, resp.NullArray:
+					return
+				}
+			}
+		}()
+	}
+	c := dial(t, s)
+	for {
+		v, ok := c.do("LRANGE", "l", "0", "-1").(resp.Array)
+		if !ok || len(v) == 0 {
+			break
+		}
+		first, _ := strconv.Atoi(string(v[0].(resp.BulkString)))
+		if len(v) != n-first {
+			t.Fatalf("LRANGE returned %d elements from %d on, want %d", len(v), first, n-first)
+		}
+		for i, elem := range v {
+			if want := strconv.Itoa(first + i); string(elem.(resp.BulkString)) != want {
+				t.Fatalf("element %d of the reply is %s, want %s", i, elem, want)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkLRange100k(b *testing.B) {
+	c := dial(b, newTestServer(b))
+	fillList(c, "l", 100000)
+	replyLen := lrangeReplyLen(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.send("LRANGE", "l", "0", "-1"); err != nil {
+			b.Fatal(err)
+		}
+		// Skip the reply rather than decode it, to time the server.
+		if _, err := c.dec.Reader().Discard(replyLen); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// lrangeReplyLen is the size of the reply to LRANGE 0 -1 over a list
+// filled by fillList.
+func lrangeReplyLen(n int) int {
+	size := len(fmt.Sprintf("*%d\r\n", n))
+	for i := 0; i < n; i++ {
+		elem := strconv.Itoa(i)
+		size += len(fmt.Sprintf("$%d\r\n%s\r\n", len(elem), elem))
+	}
+	return size
+}
+
+// BenchmarkLPopRPush runs a million-element list as a queue: each op is
+// an LPOP and an RPUSH, pipelined 100 ops at a time.
+func BenchmarkLPopRPush(b *testing.B) {
+	c := dial(b, newTestServer(b))
+	fillList(c, "l", 1000000)
+	const batch = 100
+	var req []byte
+	for i := 0; i < batch; i++ {
+		req = resp.AppendCommand(req, []string{"LPOP", "l"})
+		req = resp.AppendCommand(req, []string{"RPUSH", "l", "0123456789"})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		if _, err := c.conn.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 2*batch; j++ {
+			if _, err := c.read(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
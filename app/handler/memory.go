@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// memoryUsageSamples is how many list elements MEMORY USAGE measures
+// unless told otherwise.
+const memoryUsageSamples = 5
+
+const memoryDoctorReport = "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+func handleMemory(c *client, args []string) {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "USAGE" && len(args) >= 3:
+		samples := memoryUsageSamples
+		for i := 3; i < len(args); i++ {
+			if !strings.EqualFold(args[i], "SAMPLES") || i+1 == len(args) {
+				c.reply(resp.Error(syntaxError()))
+				return
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				c.reply(resp.Error(notAnInteger()))
+				return
+			}
+			if n < 0 {
+				c.reply(resp.Error(syntaxError()))
+				return
+			}
+			samples = n
+			i++
+		}
+		size, ok := c.db.MemoryUsage(args[2], samples)
+		if !ok {
+			c.reply(resp.Null{})
+			return
+		}
+		c.reply(resp.Integer(size))
+	case sub == "STATS" && len(args) == 2:
+		c.reply(memoryStats(c))
+	case sub == "DOCTOR" && len(args) == 2:
+		c.reply(resp.BulkString(memoryDoctorReport))
+	case sub == "HELP" && len(args) == 2:
+		arr := make(resp.Array, len(memoryHelp))
+		for i, line := range memoryHelp {
+			arr[i] = resp.SimpleString(line)
+		}
+		c.reply(arr)
+	default:
+		c.reply(resp.Error(unknownSubcommand("MEMORY", args[1])))
+	}
+}
+
+// memoryStats reports the Go heap alongside the dataset estimate the
+// store keeps; whatever the heap holds beyond the dataset is overhead.
+func memoryStats(c *client) resp.Map {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	total := int64(ms.HeapAlloc)
+	peak := max(c.srv.notePeakMemory(ms.HeapAlloc), total)
+	keys := int64(c.db.Len())
+	dataset := c.db.UsedMemory()
+	overhead := max(total-dataset, 0)
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / keys
+	}
+	percentage := 0.0
+	if total > 0 {
+		percentage = float64(dataset) * 100 / float64(total)
+	}
+
+	c.srv.mu.Lock()
+	clients := int64(len(c.srv.clients))
+	c.srv.mu.Unlock()
+	field := func(name string, v resp.Value) resp.KeyValue {
+		return resp.KeyValue{Key: resp.BulkString(name), Value: v}
+	}
+	return resp.Map{
+		field("peak.allocated", resp.Integer(peak)),
+		field("total.allocated", resp.Integer(total)),
+		field("startup.allocated", resp.Integer(c.srv.startupAllocated)),
+		field("overhead.total", resp.Integer(overhead)),
+		field("clients.normal", resp.Integer(clients)),
+		field("keys.count", resp.Integer(keys)),
+		field("keys.bytes-per-key", resp.Integer(bytesPerKey)),
+		field("dataset.bytes", resp.Integer(dataset)),
+		field("dataset.percentage", resp.Double(percentage)),
+		field("allocator.allocated", resp.Integer(int64(ms.HeapAlloc))),
+		field("allocator.resident", resp.Integer(int64(ms.HeapInuse))),
+		field("allocator.reserved", resp.Integer(int64(ms.HeapSys))),
+		field("process.sys", resp.Integer(int64(ms.Sys))),
+	}
+}
+
+// notePeakMemory records heap as the peak if it is one, and returns the
+// peak.
+func (s *Server) notePeakMemory(heap uint64) int64 {
+	for {
+		peak := s.peakAllocated.Load()
+		if heap <= peak || s.peakAllocated.CompareAndSwap(peak, heap) {
+			return int64(max(peak, heap))
+		}
+	}
+}
+
+// sampleMemory records the heap for peak.allocated.
+func (s *Server) sampleMemory() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.notePeakMemory(ms.HeapAlloc)
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"redis/app/config"
+	"redis/app/resp"
+	"time"
+)
+
+const (
+	errLFUNotSelected = "ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."
+	errLFUSelected    = "ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."
+)
+
+// objectSubcommands are OBJECT's; the container adds HELP. None of them
+// counts as an access to the key.
+var objectSubcommands = []*command{
+	{name: "encoding", handler: handleObjectEncoding, arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1,
+		usage: "<key>", help: []string{"Return the kind of internal representation used in order to store the value", "associated with a <key>."}},
+	{name: "freq", handler: handleObjectFreq, arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1,
+		usage: "<key>", help: []string{"Return the access frequency index of the <key>. The returned integer is", "proportional to the logarithm of the recent access frequency of the key."}},
+	{name: "idletime", handler: handleObjectIdleTime, arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1,
+		usage: "<key>", help: []string{"Return the idle time of the <key>, that is the approximated number of", "seconds elapsed since the last access to the key."}},
+}
+
+func handleObjectEncoding(c *client, args []string) {
+	info, ok := c.db.Info(args[2])
+	if !ok {
+		c.reply(resp.Error("ERR no such key"))
+		return
+	}
+	c.reply(resp.BulkString(info.Encoding))
+}
+
+func handleObjectFreq(c *client, args []string) {
+	info, ok := c.db.Info(args[2])
+	switch {
+	case !ok:
+		c.reply(resp.Null{})
+	case !config.IsLFU():
+		c.reply(resp.Error(errLFUNotSelected))
+	default:
+		c.reply(resp.Integer(int(info.Freq)))
+	}
+}
+
+func handleObjectIdleTime(c *client, args []string) {
+	info, ok := c.db.Info(args[2])
+	switch {
+	case !ok:
+		c.reply(resp.Null{})
+	case config.IsLFU():
+		c.reply(resp.Error(errLFUSelected))
+	default:
+		c.reply(resp.Integer(int(info.Idle / time.Second)))
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncodingStrings(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	for _, tc := range []struct{ value, want string }{
+		{"123", "int"},
+		{"-9223372036854775808", "int"},
+		{"9223372036854775808", "embstr"}, // past int64
+		{"0123", "embstr"},                // not how the integer prints
+		{"+1", "embstr"},
+		{"", "embstr"},
+		{strings.Repeat("x", 44), "embstr"},
+		{strings.Repeat("x", 45), "raw"},
+	} {
+		c.expect(ok(), "SET", "k", tc.value)
+		c.expect(bulk(tc.want), "OBJECT", "ENCODING", "k")
+	}
+
+	// Changing a string in place leaves it raw, whatever it holds.
+	c.expect(ok(), "SET", "k", "12")
+	c.expect(resp.Integer(3), "APPEND", "k", "3")
+	c.expect(bulk("raw"), "OBJECT", "ENCODING", "k")
+	c.expect(bulk("123"), "GET", "k")
+	c.expect(ok(), "SET", "k", "short")
+	c.expect(resp.Integer(5), "SETRANGE", "k", "0", "S")
+	c.expect(bulk("raw"), "OBJECT", "ENCODING", "k")
+
+	c.expect(resp.Error("ERR no such key"), "OBJECT", "ENCODING", "missing")
+}
+
+func TestObjectEncodingListGrowsAndShrinks(t *testing.T) {
+	setConfig(t, "list-max-listpack-size", "4")
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Integer(4), "RPUSH", "l", "a", "b", "c", "d")
+	c.expect(bulk("listpack"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.Integer(5), "RPUSH", "l", "e")
+	c.expect(bulk("quicklist"), "OBJECT", "ENCODING", "l")
+
+	// Back under the limit isn't enough: it converts back at half of it.
+	c.expect(resp.StringArray{"a", "b"}, "LPOP", "l", "2")
+	c.expect(bulk("quicklist"), "OBJECT", "ENCODING", "l")
+	c.expect(bulk("c"), "LPOP", "l")
+	c.expect(resp.Integer(2), "LLEN", "l")
+	c.expect(bulk("listpack"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.StringArray{"d", "e"}, "LRANGE", "l", "0", "-1")
+
+	// A lower limit takes effect on the list's next write.
+	c.expect(ok(), "CONFIG", "SET", "list-max-listpack-size", "1")
+	c.expect(bulk("listpack"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.Integer(3), "LPUSH", "l", "c")
+	c.expect(bulk("quicklist"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.StringArray{"c", "d", "e"}, "LRANGE", "l", "0", "-1")
+}
+
+func TestObjectEncodingListBySize(t *testing.T) {
+	// -1 limits a listpack to 4kb rather than a number of elements.
+	setConfig(t, "list-max-listpack-size", "-1")
+	c := dial(t, newTestServer(t))
+	for i := 0; i < 100; i++ {
+		c.do("RPUSH", "l", "small")
+	}
+	c.expect(bulk("listpack"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.Integer(101), "RPUSH", "l", strings.Repeat("x", 4096))
+	c.expect(bulk("quicklist"), "OBJECT", "ENCODING", "l")
+	c.expect(resp.StringArray{"small", strings.Repeat("x", 4096)}, "LRANGE", "l", "99", "-1")
+}
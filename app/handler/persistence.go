@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis/app/config"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"redis/app/store"
+	"time"
+)
+
+var errBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+const errMisconf = "MISCONF Redis is configured to save RDB snapshots, but it's currently unable to persist to disk. Commands that may modify the data set are disabled, because this instance is configured to report errors during writes if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the Redis logs for details about the RDB error."
+
+// bgsaveRetryDelay is how many seconds saveIfNeeded waits after a failed
+// background save before trying again.
+const bgsaveRetryDelay = 5
+
+func handleSave(c *client, _ []string) {
+	if c.srv.bgsaveInProgress.Load() {
+		c.reply(resp.Error(errBgsaveInProgress.Error()))
+		return
+	}
+	if err := c.srv.save(c.db.Snapshot()); err != nil {
+		c.log.Warn("Error saving DB on disk", "err", err)
+		c.reply(resp.Error("ERR " + err.Error()))
+		return
+	}
+	c.log.Info("DB saved on disk")
+	c.reply(resp.SimpleString("OK"))
+}
+
+func handleBGSave(c *client, _ []string) {
+	if err := c.srv.bgsave(); err != nil {
+		c.reply(resp.Error(err.Error()))
+		return
+	}
+	c.reply(resp.SimpleString("Background saving started"))
+}
+
+func handleLastSave(c *client, _ []string) {
+	c.reply(resp.Integer(c.srv.lastSave.Load()))
+}
+
+// LoadData fills the keyspace at startup: from the AOF if appendonly is
+// on and the file exists, otherwise from the RDB dump. With appendonly on
+// it then starts appending.
+func (s *Server) LoadData() error {
+	if !config.AppendOnly.Load() {
+		return s.loadDump()
+	}
+	err := s.loadAOF(aofPath())
+	if errors.Is(err, os.ErrNotExist) {
+		// Start the AOF from whatever the dump holds.
+		if err := s.loadDump(); err != nil {
+			return err
+		}
+		return s.startAOF()
+	}
+	if err != nil {
+		return err
+	}
+	// The changes just replayed are on disk already.
+	s.savedSeq.Store(s.seq.Load())
+	f, err := os.OpenFile(aofPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.aof.file, s.aof.size, s.aof.baseSize = f, info.Size(), info.Size()
+	return nil
+}
+
+// loadDump fills the keyspace from dir/dbfilename, if that file exists.
+func (s *Server) loadDump() error {
+	path := filepath.Join(config.Dir.Load(), config.DBFilename.Load())
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	snap, err := rdb.Read(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.db.Load(snap)
+	s.log.Info("DB loaded from disk", "path", path, "keys", s.db.Len(), "seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// bgsave writes a snapshot of the keyspace from a background goroutine.
+// Taking the snapshot only holds the store's lock while it walks the keys;
+// see store.Memory.Snapshot.
+func (s *Server) bgsave() error {
+	if !s.bgsaveInProgress.CompareAndSwap(false, true) {
+		return errBgsaveInProgress
+	}
+	s.lastBgsaveTry.Store(s.clock.Now().Unix())
+	snap := s.db.Snapshot()
+	s.log.Info("Background saving started")
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer s.bgsaveInProgress.Store(false)
+		if err := s.save(snap); err != nil {
+			s.log.Warn("Background saving error", "err", err)
+			s.lastBgsaveOK.Store(false)
+			return
+		}
+		s.log.Info("Background saving terminated with success")
+	}()
+	return nil
+}
+
+// save writes snap to dir/dbfilename. The file is written under a
+// temporary name and renamed into place, so a crash mid-save leaves the
+// previous dump intact.
+func (s *Server) save(snap *store.Snapshot) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	dir := config.Dir.Load()
+	tmp := filepath.Join(dir, fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("Failed opening the temp RDB file %s: %w", tmp, err)
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	err = rdb.Write(w, snap, s.rdbAux())
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Write error saving DB on disk: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, config.DBFilename.Load())); err != nil {
+		return fmt.Errorf("Error moving temp DB file on the final destination: %w", err)
+	}
+	s.lastSave.Store(s.clock.Now().Unix())
+	s.lastBgsaveOK.Store(true)
+	if snap.Seq > s.savedSeq.Load() {
+		s.savedSeq.Store(snap.Seq)
+	}
+	return nil
+}
+
+// saveIfNeeded starts a background save once a save rule is met: at
+// least its number of changes since the last save, and at least its
+// number of seconds. A failed save is retried every bgsaveRetryDelay
+// seconds rather than on every check.
+func (s *Server) saveIfNeeded() {
+	if s.bgsaveInProgress.Load() {
+		return
+	}
+	now := s.clock.Now().Unix()
+	if !s.lastBgsaveOK.Load() && now-s.lastBgsaveTry.Load() < bgsaveRetryDelay {
+		return
+	}
+	changes := s.changesSinceSave()
+	elapsed := now - s.lastSave.Load()
+	for _, rule := range config.SaveRules() {
+		if changes >= rule.Changes && elapsed >= rule.Seconds {
+			s.log.Info("Saving...", "changes", changes, "seconds", rule.Seconds)
+			s.bgsave()
+			return
+		}
+	}
+}
+
+// savesFailing reports whether writes must be refused because the last
+// background save failed. A replica's data is its master's to persist.
+func (s *Server) savesFailing() bool {
+	return config.StopWritesOnBgsaveError.Load() && len(config.SaveRules()) > 0 &&
+		!s.lastBgsaveOK.Load() && !s.isReplica()
+}
+
+// changesSinceSave counts the changes to the keyspace the last save
+// doesn't include.
+func (s *Server) changesSinceSave() int64 {
+	return int64(s.seq.Load() - s.savedSeq.Load())
+}
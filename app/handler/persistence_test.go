@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBgsaveIsOnePointInTime saves while writers change strings. Each
+// writer counts with SET n:w i and then APPEND s:w ".", so at any moment
+// n:w is the length of s:w or one more. A dump mixing moments, or one
+// that caught a string halfway through an APPEND, breaks that.
+func TestBgsaveIsOnePointInTime(t *testing.T) {
+	s := newTestServer(t)
+	const writers = 8
+	var stop atomic.Bool
+	var writes atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		c := dial(t, s)
+		c.expect(ok(), "SET", fmt.Sprint("s:", w), "")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; !stop.Load(); i++ {
+				if _, err := c.try("SET", fmt.Sprint("n:", w), strconv.Itoa(i)); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := c.try("APPEND", fmt.Sprint("s:", w), "."); err != nil {
+					t.Error(err)
+					return
+				}
+				writes.Add(1)
+			}
+		}()
+	}
+
+	// Save with the writers in full swing.
+	eventually(t, "the writers to get going", func() bool { return writes.Load() >= 1000 })
+	c := dial(t, s)
+	c.expect(resp.SimpleString("Background saving started"), "BGSAVE")
+	eventually(t, "the save to finish", func() bool { return !s.bgsaveInProgress.Load() })
+	stop.Store(true)
+	wg.Wait()
+	if !s.lastBgsaveOK.Load() {
+		t.Fatal("the save failed")
+	}
+
+	f, err := os.Open(filepath.Join(config.Dir.Load(), config.DBFilename.Load()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	snap, err := rdb.Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	for _, e := range snap.Entries {
+		values[e.Key] = e.Value.(string)
+	}
+	for w := 0; w < writers; w++ {
+		n, _ := strconv.Atoi(values[fmt.Sprint("n:", w)])
+		appended := len(values[fmt.Sprint("s:", w)])
+		if n != appended && n != appended+1 {
+			t.Errorf("writer %d: n:%d is %d but s:%d has %d bytes", w, w, n, w, appended)
+		}
+	}
+}
+
+// TestSaveRules drives save "10 2" with a manual clock: a background save
+// needs both 2 changes and 10 seconds since the last save.
+func TestSaveRules(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	clk := clock.NewManual(epoch)
+	s := newTestServerClock(t, clk)
+	setConfig(t, "save", "10 2")
+	c := dial(t, s)
+	saved := func(at time.Time) bool {
+		return !s.bgsaveInProgress.Load() && s.lastSave.Load() == at.Unix()
+	}
+
+	c.expect(ok(), "SET", "a", "1")
+	c.expect(ok(), "SET", "b", "2")
+	s.saveIfNeeded()
+	clk.Advance(9 * time.Second)
+	s.saveIfNeeded()
+	c.expect(resp.Integer(epoch.Unix()), "LASTSAVE")
+	// The cron checks the rules once a second.
+	clk.Advance(time.Second)
+	eventually(t, "the save rule to fire", func() bool { return saved(clk.Now()) })
+	c.expect(resp.Integer(clk.Now().Unix()), "LASTSAVE")
+	if got := c.info("persistence", "rdb_changes_since_last_save"); got != "0" {
+		t.Errorf("rdb_changes_since_last_save:%s after the save", got)
+	}
+	if _, err := os.Stat(filepath.Join(config.Dir.Load(), config.DBFilename.Load())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The seconds have passed, but there is one change too few.
+	last := clk.Now()
+	clk.Advance(time.Minute)
+	c.expect(ok(), "SET", "c", "3")
+	s.saveIfNeeded()
+	c.expect(resp.Integer(last.Unix()), "LASTSAVE")
+	c.expect(ok(), "SET", "d", "4")
+	s.saveIfNeeded()
+	eventually(t, "the second save", func() bool { return saved(clk.Now()) })
+}
+
+// TestMisconf fails a background save by removing the data directory, and
+// checks writes are refused until a save succeeds again.
+func TestMisconf(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	s := newTestServerClock(t, clk)
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, "save", "1 1", "dir", dir, "stop-writes-on-bgsave-error", "yes")
+	c := dial(t, s)
+	c.expect(ok(), "SET", "k", "v")
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.SimpleString("Background saving started"), "BGSAVE")
+	eventually(t, "the save to fail", func() bool { return !s.bgsaveInProgress.Load() && !s.lastBgsaveOK.Load() })
+	if got := c.info("persistence", "rdb_last_bgsave_status"); got != "err" {
+		t.Errorf("rdb_last_bgsave_status:%s after a failed save", got)
+	}
+	c.expect(resp.Error(errMisconf), "SET", "k", "w")
+	c.expect(resp.Error(errMisconf), "DEL", "k")
+	c.expect(bulk("v"), "GET", "k")
+	c.expect(resp.SimpleString("PONG"), "PING")
+
+	// Writes are only refused with stop-writes-on-bgsave-error yes and
+	// save rules to keep.
+	c.expect(ok(), "CONFIG", "SET", "stop-writes-on-bgsave-error", "no")
+	c.expect(ok(), "SET", "k", "w")
+	c.expect(ok(), "CONFIG", "SET", "stop-writes-on-bgsave-error", "yes")
+	c.expect(ok(), "CONFIG", "SET", "save", "")
+	c.expect(ok(), "SET", "k", "w")
+	c.expect(ok(), "CONFIG", "SET", "save", "1 1")
+	c.expect(resp.Error(errMisconf), "SET", "k", "w")
+
+	// A failed save is retried bgsaveRetryDelay seconds after it started,
+	// and once one succeeds writes are let through again.
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance((bgsaveRetryDelay - 1) * time.Second)
+	s.saveIfNeeded()
+	if s.bgsaveInProgress.Load() || s.lastBgsaveOK.Load() {
+		t.Fatal("the failed save was retried early")
+	}
+	clk.Advance(time.Second)
+	s.saveIfNeeded()
+	eventually(t, "the retry to succeed", func() bool { return !s.bgsaveInProgress.Load() && s.lastBgsaveOK.Load() })
+	c.expect(ok(), "SET", "k", "x")
+}
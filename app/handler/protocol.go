@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"redis/app/config"
+	"slices"
+)
+
+const (
+	maxMultibulkLen = 1024 * 1024
+	maxBulkLen      = 512 * 1024 * 1024
+	// protocolDumpLimit bounds how much of the offending input a protocol
+	// error logs.
+	protocolDumpLimit = 128
+)
+
+// protocolError is returned by requestReader when the client sent something
+// that is not valid RESP. Unlike I/O errors it is reported back to the
+// client before the connection is closed.
+type protocolError struct {
+	msg string
+	// input is the line or payload that couldn't be parsed.
+	input []byte
+	// limit marks a request refused for its size rather than malformed.
+	limit bool
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// dump hex-encodes the start of the offending input for the log.
+func (e *protocolError) dump() string {
+	if len(e.input) > protocolDumpLimit {
+		return hex.EncodeToString(e.input[:protocolDumpLimit]) + "..."
+	}
+	return hex.EncodeToString(e.input)
+}
+
+func newProtocolError(input []byte, format string, a ...any) error {
+	return &protocolError{msg: fmt.Sprintf(format, a...), input: bytes.Clone(input)}
+}
+
+func newLimitError(input []byte, msg string) error {
+	return &protocolError{msg: msg, input: bytes.Clone(input), limit: true}
+}
+
+// requestReader parses the multibulk requests on one stream. The argument
+// slice and payload buffer are reused from one request to the next, so the
+// arguments next returns are only valid until it is called again; the
+// strings themselves are copies and may be kept.
+type requestReader struct {
+	r    *bufio.Reader
+	args []string
+	buf  []byte
+	// client applies the proto-max-bulk-len, proto-max-multibulk-len and
+	// client-query-buffer-limit settings. The AOF and the master's stream
+	// only carry requests that were accepted once already.
+	client bool
+}
+
+func newRequestReader(r *bufio.Reader) *requestReader {
+	return &requestReader{r: r}
+}
+
+// next reads one multibulk request. An empty or null multibulk yields no
+// arguments and no error, which callers ignore like redis-server does.
+// The size limits are checked as each header arrives, so a request
+// over them is refused before any more of it is read.
+func (rr *requestReader) next() ([]string, error) {
+	maxArgs, maxBulk, maxRequest := maxMultibulkLen, maxBulkLen, math.MaxInt
+	if rr.client {
+		maxArgs = int(config.ProtoMaxMultibulkLen.Load())
+		maxBulk = int(config.ProtoMaxBulkLen.Load())
+		maxRequest = int(config.ClientQueryBufferLimit.Load())
+	}
+	line, err := readLineBytes(rr.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, newProtocolError(line, "expected '*', got '%s'", firstByte(line))
+	}
+	n, ok := parseLength(line[1:])
+	if !ok {
+		return nil, newProtocolError(line, "invalid multibulk length")
+	}
+	if n > maxArgs {
+		return nil, newLimitError(line, "invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	total := len(line) + 2
+	args := rr.args[:0]
+	for i := 0; i < n; i++ {
+		header, err := readLineBytes(rr.r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, newProtocolError(header, "expected '$', got '%s'", firstByte(header))
+		}
+		size, ok := parseLength(header[1:])
+		if !ok || size < 0 {
+			return nil, newProtocolError(header, "invalid bulk length")
+		}
+		if size > maxBulk {
+			return nil, newLimitError(header, "invalid bulk length")
+		}
+		total += len(header) + 2 + size + 2
+		if total > maxRequest {
+			return nil, newLimitError(header, "request exceeds client-query-buffer-limit")
+		}
+		// Read exactly the declared payload plus its CRLF so values may
+		// contain any bytes, including whitespace and embedded CRLF.
+		buf, err := rr.readPayload(size + 2)
+		if err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, newProtocolError(buf[size:], "bulk length does not match payload")
+		}
+		args = append(args, rr.argString(i, buf[:size]))
+	}
+	// Don't hang on to the buffer a single huge argument needed.
+	if cap(rr.buf) > maxReusedBuffer {
+		rr.buf = nil
+	}
+	rr.args = args
+	return args, nil
+}
+
+// readPayload reads n bytes into the reused buffer, growing it as the
+// bytes arrive rather than to n up front: a header announcing a huge
+// argument costs nothing until the argument is actually sent.
+func (rr *requestReader) readPayload(n int) ([]byte, error) {
+	buf := rr.buf[:0]
+	for len(buf) < n {
+		chunk := min(n-len(buf), payloadChunk)
+		buf = slices.Grow(buf, chunk)
+		read, err := io.ReadFull(rr.r, buf[len(buf):len(buf)+chunk])
+		buf = buf[:len(buf)+read]
+		if err != nil {
+			rr.buf = buf
+			return nil, err
+		}
+	}
+	rr.buf = buf
+	return buf, nil
+}
+
+// payloadChunk is how much of an argument readPayload makes room for at a
+// time.
+const payloadChunk = 1024 * 1024
+
+// maxReusedBuffer is the largest payload buffer kept between requests.
+const maxReusedBuffer = 64 * 1024
+
+// argString converts argument i. A command name is only ever looked up,
+// so when it is the table's name for a command, spelled in lower or upper
+// case, the table's own string is used and nothing is copied. Any other
+// spelling is copied, so that SLOWLOG and hooks see what the client sent.
+func (rr *requestReader) argString(i int, b []byte) string {
+	if i == 0 {
+		if cmd, ok := findCommand(commands, b); ok && cmd.enabled() {
+			switch string(b) {
+			case cmd.name:
+				return cmd.name
+			case cmd.upper:
+				return cmd.upper
+			}
+		}
+	}
+	return string(b)
+}
+
+// maxCommandNameLen bounds the names findCommand lowers without
+// allocating. Every command in the table is shorter, unless it was
+// renamed to something longer.
+const maxCommandNameLen = 32
+
+// parseLength parses the decimal length in a protocol header.
+func parseLength(b []byte) (int, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			return 0, false
+		}
+		n = n*10 + int(ch-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// readLine reads a CRLF-terminated protocol line and returns it without
+// the terminator.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := readLineBytes(reader)
+	return string(line), err
+}
+
+// readLineBytes is readLine without the copy: the line is only valid
+// until the next read. Lines longer than the reader's buffer are rejected
+// so a client can't make us buffer an unbounded header.
+func readLineBytes(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, newProtocolError(line, "too big request header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, newProtocolError(line, "expected CRLF line terminator")
+	}
+	return line[:len(line)-2], nil
+}
+
+func firstByte(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return string(b[:1])
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"redis/app/resp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func readRequest(t *testing.T, input string) ([]string, error) {
+	t.Helper()
+	return newRequestReader(bufio.NewReader(strings.NewReader(input))).next()
+}
+
+func TestRequestReaderKeepsPayloadBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"embedded CRLF", "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$12\r\nline1\r\nline2\r\n", []string{"set", "k", "line1\r\nline2"}},
+		{"surrounding spaces", "*2\r\n$4\r\necho\r\n$7\r\n  hi  \t\r\n", []string{"echo", "  hi  \t"}},
+		{"empty value", "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$0\r\n\r\n", []string{"set", "k", ""}},
+		{"binary", "*2\r\n$4\r\necho\r\n$4\r\n\x00\xff\r\x01\r\n", []string{"echo", "\x00\xff\r\x01"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readRequest(t, tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestReaderRejectsBadFraming(t *testing.T) {
+	for _, tc := range []struct {
+		name, input, msg string
+	}{
+		{"payload longer than declared", "*1\r\n$2\r\nabc\r\n", "bulk length does not match payload"},
+		{"payload shorter than declared", "*1\r\n$4\r\nab\r\nxx", "bulk length does not match payload"},
+		{"bad multibulk length", "*x\r\n", "invalid multibulk length"},
+		{"negative bulk length", "*1\r\n$-3\r\n", "invalid bulk length"},
+		{"missing dollar", "*1\r\n+OK\r\n", "expected '$', got '+'"},
+		{"bare LF", "*1\n", "expected CRLF line terminator"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readRequest(t, tc.input)
+			var perr *protocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("got error %v, want a protocol error", err)
+			}
+			if perr.msg != tc.msg {
+				t.Errorf("got %q, want %q", perr.msg, tc.msg)
+			}
+		})
+	}
+}
+
+func TestBinarySafeValuesRoundTrip(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	for _, v := range []string{"line1\r\nline2", " padded ", "", "\x00\r\n\x00"} {
+		c.expect(ok(), "SET", "k", v)
+		c.expect(bulk(v), "GET", "k")
+	}
+	// The connection is still in sync after all of that.
+	c.expect(resp.SimpleString("PONG"), "PING")
+}
+
+// TestRequestSizeLimits sends requests right at each size limit, which
+// must run, and one past it, which must be refused. The refused ones are
+// sent only up to the header that breaks the limit, since the server
+// answers and hangs up without reading further.
+func TestRequestSizeLimits(t *testing.T) {
+	const mb = 1 << 20
+	setConfig(t, "proto-max-bulk-len", "1mb", "proto-max-multibulk-len", "4", "client-query-buffer-limit", "2mb")
+	s := newTestServer(t)
+
+	refused := func(what, input, msg string) {
+		t.Helper()
+		c := dial(t, s)
+		if _, err := c.conn.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := c.read(); err != nil || !sameValue(got, resp.Error("ERR Protocol error: "+msg)) {
+			t.Errorf("%s: got %v, %v", what, got, err)
+		}
+		if got, err := c.read(); err == nil {
+			t.Errorf("%s: the connection stayed open and sent %s", what, show(got))
+		}
+	}
+
+	c := dial(t, s)
+	c.expect(ok(), "SET", "k", strings.Repeat("x", mb))
+	c.expect(resp.Integer(mb), "STRLEN", "k")
+	refused("an argument of 1mb+1", "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1048577\r\n", "invalid bulk length")
+
+	c.expect(resp.Integer(mb), "SETRANGE", "k", "0", "v")
+	refused("5 arguments", "*5\r\n", "invalid multibulk length")
+
+	// Two arguments just under 1mb, and a key padding the request to
+	// exactly 2mb.
+	args := []string{"RPUSH", "", strings.Repeat("a", mb-100), strings.Repeat("b", mb-100)}
+	pad := 2*mb - len(resp.AppendCommand(nil, args))
+	args[1] = strings.Repeat("l", pad-len(strconv.Itoa(pad))+1)
+	if n := len(resp.AppendCommand(nil, args)); n != 2*mb {
+		t.Fatalf("built a %d byte request", n)
+	}
+	if got := c.do(args...); !sameValue(got, resp.Integer(2)) {
+		t.Errorf("a request of 2mb got %s", show(got))
+	}
+	over := string(resp.AppendCommand(nil, args[:3])) + "$" + strconv.Itoa(len(args[3])+1) + "\r\n"
+	refused("a request of 2mb+1", "*4"+over[2:], "request exceeds client-query-buffer-limit")
+
+	if got := dial(t, s).do("INFO", "stats"); !strings.Contains(string(got.(resp.BulkString)), "client_query_buffer_limit_disconnections:3\r\n") {
+		t.Error("INFO stats doesn't count the 3 refused requests")
+	}
+}
+
+// TestNullRepliesOnTheWire checks the exact bytes of each kind of null
+// reply under both protocols. A PING after each one shows nothing else
+// was sent with it.
+func TestNullRepliesOnTheWire(t *testing.T) {
+	s := newTestServer(t)
+	for _, tc := range []struct {
+		args         []string
+		resp2, resp3 string
+	}{
+		{[]string{"GET", "missing"}, "$-1\r\n", "_\r\n"},
+		{[]string{"LPOP", "missing"}, "$-1\r\n", "_\r\n"},
+		{[]string{"LPOP", "missing", "2"}, "*-1\r\n", "_\r\n"},
+		{[]string{"BLPOP", "missing", "0.01"}, "*-1\r\n", "_\r\n"},
+	} {
+		for _, proto := range []string{"2", "3"} {
+			c := dial(t, s)
+			if v, isErr := c.do("HELLO", proto).(resp.Error); isErr {
+				t.Fatalf("HELLO %s = %s", proto, v)
+			}
+			want := tc.resp2
+			if proto == "3" {
+				want = tc.resp3
+			}
+			if err := c.send(tc.args...); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.readRaw(len(want)); string(got) != want {
+				t.Errorf("RESP%s %q = %q, want %q", proto, tc.args, got, want)
+			}
+			if err := c.send("PING"); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.readRaw(7); string(got) != "+PONG\r\n" {
+				t.Errorf("RESP%s %q was followed by %q", proto, tc.args, got)
+			}
+		}
+	}
+}
+
+func TestCommandNameKeepsClientSpelling(t *testing.T) {
+	for _, name := range []string{"set", "SET", "Set", "sEt"} {
+		got, err := readRequest(t, "*3\r\n$3\r\n"+name+"\r\n$1\r\nk\r\n$1\r\nv\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != name {
+			t.Errorf("command name %q read as %q", name, got[0])
+		}
+	}
+
+	setConfig(t, "slowlog-log-slower-than", "0")
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SeT", "k", "v")
+	entries, _ := c.do("SLOWLOG", "GET", "1").(resp.Array)
+	if len(entries) != 1 {
+		t.Fatalf("SLOWLOG GET 1 returned %d entries", len(entries))
+	}
+	want := resp.StringArray{"SeT", "k", "v"}
+	if got := entries[0].(resp.Array)[3]; !sameValue(got, want) {
+		t.Errorf("SLOWLOG logged %s, want %s", show(got), show(want))
+	}
+}
+
+// repeatReader returns data over and over.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		k := copy(p[n:], r.data[r.off:])
+		n += k
+		r.off = (r.off + k) % len(r.data)
+	}
+	return n, nil
+}
+
+// BenchmarkParseSet reads SET requests as clients usually send them. The
+// command name costs no allocation, leaving one each for the key and the
+// value.
+func BenchmarkParseSet(b *testing.B) {
+	rr := newRequestReader(bufio.NewReader(&repeatReader{
+		data: resp.AppendCommand(nil, []string{"SET", "key:000123", "some value"}),
+	}))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := rr.next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPipelinedGet sends GETs in batches of 100 over one connection,
+// as a pipelining client does, and reads the replies. The figures include
+// the test client decoding them.
+func BenchmarkPipelinedGet(b *testing.B) {
+	c := dial(b, newTestServer(b))
+	c.expect(ok(), "SET", "key", "value")
+	const batch = 100
+	var req []byte
+	for i := 0; i < batch; i++ {
+		req = resp.AppendCommand(req, []string{"GET", "key"})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += batch {
+		if _, err := c.conn.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < batch; i++ {
+			if _, err := c.read(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"redis/app/acl"
+	"redis/app/config"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	errReadOnly   = "READONLY You can't write against a read only replica."
+	errMasterDown = "MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'."
+	// replReconnectDelay is how long a replica waits before trying its
+	// master again after losing the link.
+	replReconnectDelay = time.Second
+	// replAckInterval is how often a replica reports its offset.
+	replAckInterval = time.Second
+)
+
+// masterLink is the replica side of replication: the connection to the
+// master and how far into its stream the replica has applied.
+type masterLink struct {
+	mu     sync.Mutex
+	host   string
+	port   string
+	state  string // connect, connecting, sync or connected
+	stop   chan struct{}
+	done   chan struct{}
+	lastIO time.Time
+
+	// replID is the id of the master's stream that offset counts into.
+	// It outlives the connection, so that the next one can resume the
+	// stream where this one stopped; it is cleared when the dataset stops
+	// following that master.
+	replID string
+	offset atomic.Int64
+	// writeMu serializes acks, which both the periodic acker and
+	// REPLCONF GETACK send.
+	writeMu sync.Mutex
+	conn    net.Conn
+}
+
+func (s *Server) isReplica() bool {
+	return s.replicaOf.Load() != ""
+}
+
+// masterLinkUp reports whether a replica has finished its sync with the
+// master and is following its stream.
+func (s *Server) masterLinkUp() bool {
+	link := &s.master
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.state == "connected"
+}
+
+func handleReplicaOf(c *client, args []string) {
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		if c.srv.isReplica() {
+			c.srv.stopReplication()
+			c.srv.forgetMaster()
+			c.srv.replicaOf.Store("")
+			config.ReplicaOf.Store("")
+			c.db.SetKeepExpired(false)
+			c.log.Info("MASTER MODE enabled by user request")
+		}
+		c.reply(resp.SimpleString("OK"))
+		return
+	}
+	if _, err := strconv.ParseUint(args[2], 10, 16); err != nil {
+		c.reply(resp.Error("ERR Invalid master port"))
+		return
+	}
+	master := args[1] + " " + args[2]
+	if c.srv.replicaOf.Load() == master {
+		c.reply(resp.SimpleString("OK Already connected to specified master"))
+		return
+	}
+	c.srv.stopReplication()
+	c.srv.forgetMaster()
+	c.srv.replicaOf.Store(master)
+	config.ReplicaOf.Store(master)
+	c.log.Info("REPLICAOF enabled by user request", "master", net.JoinHostPort(args[1], args[2]))
+	c.srv.startReplication()
+	c.reply(resp.SimpleString("OK"))
+}
+
+// startReplication connects to the master in replicaof, reconnecting
+// whenever the link drops, until stopReplication or shutdown. Keys stop
+// expiring on their own: the master's DELs delete them.
+func (s *Server) startReplication() {
+	s.db.SetKeepExpired(true)
+	host, port, _ := strings.Cut(s.replicaOf.Load(), " ")
+	link := &s.master
+	link.mu.Lock()
+	link.host, link.port, link.state = host, port, "connect"
+	stop, done := make(chan struct{}), make(chan struct{})
+	link.stop, link.done = stop, done
+	link.mu.Unlock()
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer close(done)
+		for {
+			err := s.syncWithMaster(host, port, stop)
+			select {
+			case <-stop:
+				return
+			case <-s.closing:
+				return
+			default:
+			}
+			s.log.Warn("Connection with master lost", "err", err)
+			link.mu.Lock()
+			link.state = "connect"
+			link.mu.Unlock()
+			select {
+			case <-stop:
+				return
+			case <-s.closing:
+				return
+			case <-time.After(replReconnectDelay):
+			}
+		}
+	}()
+}
+
+// stopReplication closes the link to the master and waits for it to
+// wind down.
+func (s *Server) stopReplication() {
+	link := &s.master
+	link.mu.Lock()
+	stop, done := link.stop, link.done
+	link.stop, link.done = nil, nil
+	link.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// forgetMaster drops what the replica knows of its master's stream, so
+// that the next sync is a full one.
+func (s *Server) forgetMaster() {
+	link := &s.master
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.replID = ""
+}
+
+func (link *masterLink) setState(state string) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.state = state
+	link.lastIO = time.Now()
+}
+
+// syncWithMaster performs the handshake, resumes the master's stream
+// where the last connection left it or else loads its snapshot, and then
+// applies its stream of changes until the connection fails.
+func (s *Server) syncWithMaster(host, port string, stop <-chan struct{}) error {
+	link := &s.master
+	link.setState("connecting")
+	s.log.Info("Connecting to MASTER", "master", net.JoinHostPort(host, port))
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-s.closing:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	counter := &countingReader{r: conn}
+	reader := bufio.NewReader(counter)
+	dec := resp.NewDecoder(reader)
+	enc := resp.NewEncoder(conn)
+	send := func(args ...string) (resp.Value, error) {
+		conn.SetDeadline(time.Now().Add(replTimeout))
+		enc.Encode(resp.BulkStrings(args))
+		if err := enc.Flush(); err != nil {
+			return nil, err
+		}
+		v, err := dec.Decode()
+		if e, ok := v.(resp.Error); ok {
+			return nil, fmt.Errorf("%s replied %s", args[0], e)
+		}
+		return v, err
+	}
+	if _, err := send("PING"); err != nil {
+		return err
+	}
+	if _, err := send("REPLCONF", "listening-port", strconv.FormatInt(s.port, 10)); err != nil {
+		return err
+	}
+	send("REPLCONF", "capa", "psync2")
+
+	link.setState("sync")
+	link.mu.Lock()
+	psync := []string{"PSYNC", "?", "-1"}
+	if link.replID != "" {
+		psync = []string{"PSYNC", link.replID, strconv.FormatInt(link.offset.Load()+1, 10)}
+	}
+	link.mu.Unlock()
+	v, err := send(psync...)
+	if err != nil {
+		return err
+	}
+	reply, _ := v.(resp.SimpleString)
+	fields := strings.Fields(string(reply))
+	switch {
+	case (len(fields) == 1 || len(fields) == 2) && fields[0] == "CONTINUE":
+		s.log.Info("Successful partial resynchronization with master", "offset", link.offset.Load())
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected reply to PSYNC: %q", reply)
+		}
+		s.log.Info("Full resync from master", "replid", fields[1], "offset", offset)
+		if err := s.loadMasterSnapshot(reader); err != nil {
+			return err
+		}
+		link.mu.Lock()
+		link.replID = fields[1]
+		link.mu.Unlock()
+		link.offset.Store(offset)
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply)
+	}
+	conn.SetDeadline(time.Time{})
+
+	link.writeMu.Lock()
+	link.conn = conn
+	link.writeMu.Unlock()
+	defer func() {
+		link.writeMu.Lock()
+		link.conn = nil
+		link.writeMu.Unlock()
+	}()
+	link.setState("connected")
+	s.log.Info("MASTER <-> REPLICA sync: Finished with success")
+	go link.ackLoop(finished)
+
+	c := &client{
+		out:           resp.NewEncoder(io.Discard),
+		conn:          conn,
+		reader:        reader,
+		db:            s.db,
+		srv:           s,
+		log:           s.log.With("client", "master"),
+		id:            s.nextClientID.Add(1),
+		createdAt:     time.Now(),
+		lastActive:    time.Now(),
+		user:          acl.Get(acl.DefaultUser),
+		authenticated: true,
+		master:        true,
+	}
+	requests := newRequestReader(reader)
+	consumed := counter.n - int64(reader.Buffered())
+	for {
+		args, err := requests.next()
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			c.dispatch(args)
+		}
+		now := counter.n - int64(reader.Buffered())
+		link.offset.Add(now - consumed)
+		consumed = now
+		link.mu.Lock()
+		link.lastIO = time.Now()
+		link.mu.Unlock()
+	}
+}
+
+// loadMasterSnapshot reads the RDB payload that follows FULLRESYNC, a
+// bulk string without the trailing CRLF, and replaces the keyspace with
+// it.
+func (s *Server) loadMasterSnapshot(reader *bufio.Reader) error {
+	header, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(header, "$") {
+		return fmt.Errorf("bad protocol from MASTER, the first byte is not '$': %q", header)
+	}
+	n, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("bad snapshot length from MASTER")
+	}
+	snap, err := rdb.Read(io.LimitReader(reader, n))
+	if err != nil {
+		return fmt.Errorf("failed trying to load the MASTER synchronization DB from socket: %w", err)
+	}
+	s.db.Clear()
+	s.db.Load(snap)
+	s.log.Info("MASTER <-> REPLICA sync: Loading DB in memory", "keys", s.db.Len())
+	if config.AppendOnly.Load() {
+		// The AOF describes the data just thrown away.
+		s.bgrewriteAOF()
+	}
+	return nil
+}
+
+// ackLoop reports the replica's offset to the master every
+// replAckInterval until done is closed.
+func (link *masterLink) ackLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			link.sendAck()
+		}
+	}
+}
+
+func (link *masterLink) sendAck() {
+	link.writeMu.Lock()
+	defer link.writeMu.Unlock()
+	if link.conn == nil {
+		return
+	}
+	ack := resp.AppendCommand(nil, []string{"REPLCONF", "ACK", strconv.FormatInt(link.offset.Load(), 10)})
+	link.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	link.conn.Write(ack)
+}
+
+func infoReplicaLink(s *Server) []infoField {
+	link := &s.master
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	// replica-serve-stale-data acts on the same test.
+	status := "down"
+	if link.state == "connected" {
+		status = "up"
+	}
+	lastIO := int64(-1)
+	if !link.lastIO.IsZero() {
+		lastIO = int64(time.Since(link.lastIO) / time.Second)
+	}
+	offset := strconv.FormatInt(link.offset.Load(), 10)
+	return []infoField{
+		{"role", "slave"},
+		{"master_host", link.host},
+		{"master_port", link.port},
+		{"master_link_status", status},
+		{"master_last_io_seconds_ago", strconv.FormatInt(lastIO, 10)},
+		{"master_sync_in_progress", boolInt(link.state == "sync")},
+		{"slave_read_repl_offset", offset},
+		{"slave_repl_offset", offset},
+		{"slave_read_only", "1"},
+	}
+}
@@ -0,0 +1,410 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"redis/app/clock"
+	"redis/app/resp"
+	"redis/app/store"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replicate makes replica follow master and waits for the initial sync.
+func replicate(t *testing.T, replica, master *Server) {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(master.Addr().String())
+	dial(t, replica).expect(ok(), "REPLICAOF", host, port)
+	eventually(t, "the replica to sync", replica.masterLinkUp)
+}
+
+// eventually waits a few seconds for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestReplicaWaitsForMasterDel gives master and replica clocks of their
+// own. The replica's copy of an expired key is hidden from reads, but
+// only goes away when the master's DEL arrives.
+func TestReplicaWaitsForMasterDel(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	masterClock, replicaClock := clock.NewManual(epoch), clock.NewManual(epoch)
+	master := newTestServerClock(t, masterClock)
+	replica := newTestServerClock(t, replicaClock)
+	replicate(t, replica, master)
+
+	m, r := dial(t, master), dial(t, replica)
+	m.expect(ok(), "SET", "k", "v", "PX", "50")
+	m.expect(ok(), "SET", "swept", "v", "PX", "50")
+	eventually(t, "the keys to reach the replica", func() bool {
+		return replica.db.Len() == 2
+	})
+
+	// Past the deadline on the replica alone: reads miss, but it deletes
+	// nothing by itself.
+	replicaClock.Advance(100 * time.Millisecond)
+	r.expect(resp.Null{}, "GET", "k")
+	r.expect(resp.Integer(-2), "PTTL", "k")
+	replica.db.ActiveExpireCycle()
+	if n := replica.db.Len(); n != 2 {
+		t.Fatalf("the replica has %d keys left, want both", n)
+	}
+
+	// The master expires k on a lookup and swept in its sweeper; each
+	// sends a DEL. Its cron may sweep both first, which is as good.
+	masterClock.Advance(100 * time.Millisecond)
+	m.expect(resp.Null{}, "GET", "k")
+	eventually(t, "the DEL of k to reach the replica", func() bool {
+		return replica.db.Len() <= 1
+	})
+	master.db.ActiveExpireCycle()
+	eventually(t, "the DEL of swept to reach the replica", func() bool {
+		return replica.db.Len() == 0
+	})
+}
+
+// psync connects to s as a replica would and returns the first line of
+// the reply to PSYNC id offset. The connection stays open, so s keeps a
+// backlog for as long as the test runs.
+func psync(t *testing.T, s *Server, id string, offset int64) string {
+	t.Helper()
+	c := dial(t, s)
+	if err := c.send("PSYNC", id, strconv.FormatInt(offset, 10)); err != nil {
+		t.Fatal(err)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.dec.Reader().ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// replOffset is the next offset a replica of s would ask for.
+func replOffset(t *testing.T, c *testClient) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(c.info("replication", "master_repl_offset"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n + 1
+}
+
+// TestChangeReplID checks DEBUG CHANGE-REPL-ID gives the server a new
+// replication id, so a replica asking to resume under the old one gets a
+// full sync instead.
+func TestChangeReplID(t *testing.T) {
+	s := newTestServer(t)
+	c := dial(t, s)
+	old := c.info("replication", "master_replid")
+	if line := psync(t, s, "?", -1); !strings.HasPrefix(line, "+FULLRESYNC "+old+" ") {
+		t.Fatalf("PSYNC ? -1 = %q", line)
+	}
+	c.expect(ok(), "SET", "k", "v")
+	if line := psync(t, s, old, replOffset(t, c)); line != "+CONTINUE "+old {
+		t.Fatalf("PSYNC with the current id = %q, want a CONTINUE", line)
+	}
+
+	c.expect(ok(), "DEBUG", "CHANGE-REPL-ID")
+	id := c.info("replication", "master_replid")
+	if id == old || len(id) != 40 {
+		t.Fatalf("master_replid went from %s to %s", old, id)
+	}
+	if line := psync(t, s, old, replOffset(t, c)); !strings.HasPrefix(line, "+FULLRESYNC "+id+" ") {
+		t.Errorf("PSYNC with the old id = %q, want a FULLRESYNC under %s", line, id)
+	}
+}
+
+// syncBuffer is a bytes.Buffer a server may log to while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestReplicaResumesWithPartialSync drops a synced replica's link, writes
+// to the master while it is down, and checks the replica comes back with
+// +CONTINUE and only the missed bytes, not a second full sync. Throughout,
+// the replica reports the master's replication id and offset as its own.
+func TestReplicaResumesWithPartialSync(t *testing.T) {
+	var log syncBuffer
+	master := newUnstartedServer(t, clock.Real)
+	master.log = slog.New(slog.NewTextHandler(&log, nil))
+	serve(t, master)
+	replica := newTestServer(t)
+	replicate(t, replica, master)
+
+	m, r := dial(t, master), dial(t, replica)
+	m.expect(ok(), "SET", "a", "1")
+	caughtUp := func() bool {
+		return r.info("replication", "master_repl_offset") == m.info("replication", "master_repl_offset")
+	}
+	eventually(t, "the replica to catch up", caughtUp)
+	if got, want := r.info("replication", "master_replid"), m.info("replication", "master_replid"); got != want {
+		t.Fatalf("the replica reports master_replid %s, the master %s", got, want)
+	}
+	before := replOffset(t, m)
+
+	// Cut the link from the replica's side. It waits a second before
+	// reconnecting, which is when the master gets the write.
+	link := &replica.master
+	link.writeMu.Lock()
+	link.conn.Close()
+	link.writeMu.Unlock()
+	eventually(t, "the replica to notice", func() bool { return !replica.masterLinkUp() })
+	m.expect(ok(), "SET", "b", "2")
+	missed := replOffset(t, m) - before
+
+	eventually(t, "the replica to catch up", replica.masterLinkUp)
+	eventually(t, "the replica to catch up again", caughtUp)
+	r.expect(bulk("1"), "GET", "a")
+	r.expect(bulk("2"), "GET", "b")
+
+	text := log.String()
+	if n := strings.Count(text, "Replica asks for synchronization"); n != 1 {
+		t.Errorf("the master ran %d full syncs, want just the first", n)
+	}
+	resumed := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, "Partial resynchronization request accepted") &&
+			strings.Contains(line, fmt.Sprintf("backlog_bytes=%d offset=%d", missed, before)) {
+			resumed = true
+		}
+	}
+	if !resumed {
+		t.Errorf("the master didn't resume the stream with the %d bytes missed from offset %d; its log:\n%s", missed, before, text)
+	}
+}
+
+// TestReplicaFullSync starts a replica of a master that already holds
+// data. The replica gets it with a FULLRESYNC and the RDB transfer, then
+// follows the writes the master propagates.
+func TestReplicaFullSync(t *testing.T) {
+	var log syncBuffer
+	master := newUnstartedServer(t, clock.Real)
+	master.log = slog.New(slog.NewTextHandler(&log, nil))
+	serve(t, master)
+	m := dial(t, master)
+	big := strings.Repeat("compressible ", 1000)
+	m.expect(ok(), "SET", "s", "v")
+	m.expect(ok(), "SET", "n", "12345")
+	m.expect(ok(), "SET", "big", big)
+	m.expect(resp.Integer(3), "RPUSH", "l", "a", "b", "c")
+	m.expect(ok(), "SET", "ttl", "v", "EX", "1000")
+
+	replica := newTestServer(t)
+	replicate(t, replica, master)
+	if n := strings.Count(log.String(), "Replica asks for synchronization"); n != 1 {
+		t.Errorf("the master ran %d full syncs, want 1", n)
+	}
+	if got := m.info("replication", "connected_slaves"); got != "1" {
+		t.Errorf("connected_slaves:%s", got)
+	}
+	r := dial(t, replica)
+	if got := r.info("replication", "role"); got != "slave" {
+		t.Errorf("the replica reports role:%s", got)
+	}
+	r.expect(bulk("v"), "GET", "s")
+	r.expect(bulk("12345"), "GET", "n")
+	r.expect(bulk(big), "GET", "big")
+	r.expect(resp.BulkStrings([]string{"a", "b", "c"}), "LRANGE", "l", "0", "-1")
+	if ttl, _ := r.do("TTL", "ttl").(resp.Integer); ttl < 990 || ttl > 1000 {
+		t.Errorf("TTL on the replica is %d, want about 1000", ttl)
+	}
+
+	// Writes after the sync arrive through the stream.
+	m.expect(ok(), "SET", "s", "v2")
+	m.expect(resp.Integer(3), "APPEND", "s", "!")
+	m.expect(resp.Integer(4), "RPUSH", "l", "d")
+	m.expect(bulk("a"), "LPOP", "l")
+	m.expect(resp.Integer(1), "DEL", "big")
+	m.expect(resp.Integer(1), "PERSIST", "ttl")
+	m.expect(ok(), "SET", "new", "v")
+	eventually(t, "the replica to catch up", func() bool {
+		return r.info("replication", "master_repl_offset") == m.info("replication", "master_repl_offset")
+	})
+	r.expect(bulk("v2!"), "GET", "s")
+	r.expect(resp.BulkStrings([]string{"b", "c", "d"}), "LRANGE", "l", "0", "-1")
+	r.expect(resp.Null{}, "GET", "big")
+	r.expect(resp.Integer(-1), "TTL", "ttl")
+	r.expect(bulk("v"), "GET", "new")
+}
+
+// TestReplicaIsReadOnly checks a replica refuses writes from its clients
+// but serves reads and applies its master's writes.
+func TestReplicaIsReadOnly(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	replicate(t, replica, master)
+	m, r := dial(t, master), dial(t, replica)
+	m.expect(ok(), "SET", "k", "v")
+	eventually(t, "the write to reach the replica", func() bool { return replica.db.Len() == 1 })
+	for _, args := range [][]string{
+		{"SET", "k", "x"},
+		{"DEL", "k"},
+		{"RPUSH", "l", "x"},
+		{"APPEND", "k", "x"},
+		{"EXPIRE", "k", "10"},
+	} {
+		r.expect(resp.Error(errReadOnly), args...)
+	}
+	r.expect(bulk("v"), "GET", "k")
+	r.expect(resp.SimpleString("PONG"), "PING")
+	m.expect(resp.Integer(1), "DEL", "k")
+	eventually(t, "the DEL to reach the replica", func() bool { return replica.db.Len() == 0 })
+}
+
+// TestReplicaReconnects has the master drop its replica's connection. The
+// replica notices, reconnects after replReconnectDelay and picks up the
+// writes made in between.
+func TestReplicaReconnects(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	replicate(t, replica, master)
+	m, r := dial(t, master), dial(t, replica)
+	m.expect(ok(), "SET", "a", "1")
+
+	var id int64
+	for _, c := range master.clientList() {
+		if c.replica != nil {
+			id = c.id
+		}
+	}
+	m.expect(resp.Integer(1), "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+	eventually(t, "the replica to notice", func() bool { return !replica.masterLinkUp() })
+	m.expect(ok(), "SET", "b", "2")
+	eventually(t, "the replica to reconnect", replica.masterLinkUp)
+	eventually(t, "the replica to catch up", func() bool {
+		return r.info("replication", "master_repl_offset") == m.info("replication", "master_repl_offset")
+	})
+	r.expect(bulk("1"), "GET", "a")
+	r.expect(bulk("2"), "GET", "b")
+	if got := m.info("replication", "connected_slaves"); got != "1" {
+		t.Errorf("connected_slaves:%s after the reconnect", got)
+	}
+}
+
+// TestWait checks WAIT counts the replicas that acknowledged the writes
+// before it, and gives up at its timeout with however many did. One of
+// the replicas here is a bare PSYNC connection that never acknowledges.
+func TestWait(t *testing.T) {
+	master := newTestServer(t)
+	replicas := []*Server{newTestServer(t), newTestServer(t)}
+	for _, r := range replicas {
+		replicate(t, r, master)
+	}
+	m := dial(t, master)
+	m.expect(ok(), "SET", "k", "v")
+	m.expect(resp.Integer(2), "WAIT", "2", "5000")
+	// Already acknowledged: no need to wait, whatever the timeout, though
+	// the GETACKs the first WAIT sent have moved the offset on.
+	m.expect(resp.Integer(2), "WAIT", "1", "0")
+	m.expect(resp.Integer(2), "WAIT", "0", "0")
+
+	psync(t, master, "?", -1)
+	eventually(t, "the third replica to register", func() bool { return m.info("replication", "connected_slaves") == "3" })
+	m.expect(ok(), "SET", "k", "v2")
+	start := time.Now()
+	m.expect(resp.Integer(2), "WAIT", "3", "200")
+	if took := time.Since(start); took < 200*time.Millisecond {
+		t.Errorf("WAIT 3 200 returned after %v", took)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"WAIT", "x", "0"}, notAnInteger()},
+		{[]string{"WAIT", "1", "x"}, notAnInteger()},
+		{[]string{"WAIT", "1", "-1"}, "ERR timeout is negative"},
+	} {
+		m.expect(resp.Error(tc.want), tc.args...)
+	}
+	dial(t, replicas[0]).expect(resp.Error("ERR WAIT cannot be used with replica instances."), "WAIT", "0", "0")
+}
+
+// TestReplicaOutputBufferLimit checks a replica whose pending changes
+// reach the hard client-output-buffer-limit is dropped and counted, and
+// that it catches up once it reconnects.
+func TestReplicaOutputBufferLimit(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	replicate(t, replica, master)
+	m, r := dial(t, master), dial(t, replica)
+	setConfig(t, "client-output-buffer-limit", "replica 4kb 0 0")
+
+	m.expect(ok(), "SET", "small", "v")
+	eventually(t, "the write to reach the replica", func() bool { return sameValue(r.do("GET", "small"), bulk("v")) })
+	if got := m.info("stats", "client_output_buffer_limit_disconnections"); got != "0" {
+		t.Fatalf("client_output_buffer_limit_disconnections:%s under the limit", got)
+	}
+
+	big := strings.Repeat("x", 8<<10)
+	m.expect(ok(), "SET", "big", big)
+	if got := m.info("stats", "client_output_buffer_limit_disconnections"); got != "1" {
+		t.Fatalf("client_output_buffer_limit_disconnections:%s over the limit", got)
+	}
+	eventually(t, "the replica to catch up", func() bool { return sameValue(r.do("GET", "big"), bulk(big)) })
+	if got := r.info("replication", "master_link_status"); got != "up" {
+		t.Errorf("master_link_status:%s after catching up", got)
+	}
+	if got := m.info("replication", "connected_slaves"); got != "1" {
+		t.Errorf("connected_slaves:%s after catching up", got)
+	}
+}
+
+// TestMasterLinkStatus follows master_link_status as the master goes away
+// and comes back on the same address. While the link is down a replica
+// with replica-serve-stale-data no refuses reads but still answers INFO.
+func TestMasterLinkStatus(t *testing.T) {
+	master, replica := newTestServer(t), newTestServer(t)
+	setConfig(t, "replica-serve-stale-data", "no")
+	addr := master.Addr().String()
+	replicate(t, replica, master)
+	m, r := dial(t, master), dial(t, replica)
+	m.expect(ok(), "SET", "k", "old")
+	eventually(t, "the write to reach the replica", func() bool { return sameValue(r.do("GET", "k"), bulk("old")) })
+	if got := r.info("replication", "master_link_status"); got != "up" {
+		t.Fatalf("master_link_status:%s with the master running", got)
+	}
+
+	master.Shutdown()
+	eventually(t, "the link to go down", func() bool { return r.info("replication", "master_link_status") == "down" })
+	r.expect(resp.Error(errMasterDown), "GET", "k")
+	r.expect(resp.SimpleString("PONG"), "PING")
+	r.expect(ok(), "CONFIG", "SET", "replica-serve-stale-data", "yes")
+	r.expect(bulk("old"), "GET", "k")
+	r.expect(ok(), "CONFIG", "SET", "replica-serve-stale-data", "no")
+
+	// The restarted master reads the settings as a process of its own
+	// would, in which it replicates nothing.
+	setConfig(t, "replicaof", "")
+	restarted := NewServer(store.NewMemory(clock.Real), slog.New(slog.NewTextHandler(io.Discard, nil)), clock.Real)
+	if err := restarted.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, restarted)
+	dial(t, restarted).expect(ok(), "SET", "k", "new")
+	eventually(t, "the link to come back up", func() bool { return r.info("replication", "master_link_status") == "up" })
+	r.expect(bulk("new"), "GET", "k")
+}
@@ -0,0 +1,451 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"redis/app/config"
+	"redis/app/rdb"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replTimeout bounds each write to a replica.
+const replTimeout = 60 * time.Second
+
+// replication is the master side of replication: the stream of changes,
+// numbered by byte offset, and the replicas it is fed to.
+type replication struct {
+	mu     sync.Mutex
+	id     string
+	offset int64
+	// changed is the offset at the end of the last change, leaving out
+	// stream-only commands such as the GETACKs WAIT sends.
+	changed  int64
+	replicas map[*replica]struct{}
+	// backlog holds the end of the stream for replicas resuming it. It is
+	// made when the first replica syncs and sized by repl-backlog-size.
+	backlog *backlog
+	// acked is closed, and replaced, whenever a replica acks, waking
+	// WAIT.
+	acked chan struct{}
+}
+
+// replica is a connection that has completed PSYNC. Changes queue up in
+// queue, filled under the store's lock by replicate and drained by the
+// replica's own writer goroutine, so a slow replica never holds up
+// command execution.
+type replica struct {
+	c    *client
+	port string
+	// ackOffset and lastAck are what the replica last reported with
+	// REPLCONF ACK; lastAck is unix seconds.
+	ackOffset atomic.Int64
+	lastAck   atomic.Int64
+
+	mu    sync.Mutex
+	queue []replEntry
+	// queued counts the bytes not yet written to the replica, whether
+	// still in queue or being written by feedReplica. softSince is when
+	// it went over the soft output buffer limit.
+	queued    int
+	softSince time.Time
+	online    bool
+	wake      chan struct{}
+	// closed is set once the replica has been dropped.
+	closed bool
+}
+
+type replEntry struct {
+	seq  uint64
+	data []byte
+}
+
+// replicate feeds one change, already encoded, to every replica. It runs
+// under the store's lock via propagate; seq is 0 for commands that only
+// exist on the stream, such as REPLCONF GETACK.
+func (s *Server) replicate(seq uint64, data []byte) {
+	repl := &s.repl
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	repl.offset += int64(len(data))
+	if seq != 0 {
+		repl.changed = repl.offset
+	}
+	if repl.backlog != nil {
+		if size := int(config.ReplBacklogSize.Load()); size != len(repl.backlog.buf) {
+			repl.backlog = repl.backlog.resized(size)
+		}
+		repl.backlog.write(data)
+	}
+	now := time.Now()
+	for r := range repl.replicas {
+		r.mu.Lock()
+		if r.overLimit(len(data), now) {
+			pending := r.queued
+			r.mu.Unlock()
+			r.c.log.Warn("Client scheduled to be closed ASAP for overcoming of output buffer limits",
+				"class", config.ClassReplica, "pending", pending)
+			s.outputBufferDisconnections.Add(1)
+			s.dropReplicaLocked(r)
+			continue
+		}
+		r.queue = append(r.queue, replEntry{seq, data})
+		r.queued += len(data)
+		r.mu.Unlock()
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// overLimit reports whether queuing n more bytes takes r past the replica
+// client-output-buffer-limit. Callers must hold r.mu.
+func (r *replica) overLimit(n int, now time.Time) bool {
+	limit := config.ClientOutputBufferLimit(config.ClassReplica)
+	pending := int64(r.queued + n)
+	if limit.Hard > 0 && pending >= limit.Hard {
+		return true
+	}
+	if limit.Soft == 0 || pending < limit.Soft {
+		r.softSince = time.Time{}
+		return false
+	}
+	if r.softSince.IsZero() {
+		r.softSince = now
+	}
+	return now.Sub(r.softSince) >= time.Duration(limit.SoftSeconds)*time.Second
+}
+
+// dropReplicaLocked disconnects r. Callers must hold repl.mu.
+func (s *Server) dropReplicaLocked(r *replica) {
+	delete(s.repl.replicas, r)
+	r.mu.Lock()
+	r.closed, r.queue = true, nil
+	r.mu.Unlock()
+	r.c.conn.Close()
+	close(r.wake)
+}
+
+func (s *Server) dropReplica(r *replica) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if _, ok := s.repl.replicas[r]; ok {
+		s.dropReplicaLocked(r)
+	}
+}
+
+func (repl *replication) notifyAck() {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	close(repl.acked)
+	repl.acked = make(chan struct{})
+}
+
+// countAcked returns how many replicas have acked offset, and a channel
+// closed on the next ack.
+func (repl *replication) countAcked(offset int64) (int, <-chan struct{}) {
+	repl.mu.Lock()
+	defer repl.mu.Unlock()
+	n := 0
+	for r := range repl.replicas {
+		if r.ackOffset.Load() >= offset {
+			n++
+		}
+	}
+	return n, repl.acked
+}
+
+// handleWait blocks until numreplicas replicas have acked every change
+// made before it was called, or the timeout in milliseconds passes, and
+// replies with how many have.
+func handleWait(c *client, args []string) {
+	want, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	if ms < 0 {
+		c.reply(resp.Error("ERR timeout is negative"))
+		return
+	}
+	s := c.srv
+	if s.isReplica() {
+		c.reply(resp.Error("ERR WAIT cannot be used with replica instances."))
+		return
+	}
+	s.repl.mu.Lock()
+	target := s.repl.changed
+	s.repl.mu.Unlock()
+	acked, next := s.repl.countAcked(target)
+	if acked >= want {
+		c.reply(resp.Integer(acked))
+		return
+	}
+	s.replicate(0, resp.AppendCommand(nil, []string{"REPLCONF", "GETACK", "*"}))
+
+	c.out.Flush()
+	c.blocked.Store(true)
+	parkedAt := time.Now()
+	defer func() {
+		c.blocked.Store(false)
+		c.blockedTime += time.Since(parkedAt)
+	}()
+	gone, stopWatching := watchDisconnect(c.conn, c.reader)
+	var timer <-chan time.Time
+	if ms > 0 {
+		timer = time.After(time.Duration(ms) * time.Millisecond)
+	}
+	for waiting := true; waiting && acked < want; {
+		select {
+		case <-next:
+			acked, next = s.repl.countAcked(target)
+		case <-timer:
+			acked, _ = s.repl.countAcked(target)
+			waiting = false
+		case <-gone:
+			stopWatching()
+			return
+		case <-s.closing:
+			stopWatching()
+			return
+		}
+	}
+	// The watcher may be flushing from its own goroutine until stopped.
+	stopWatching()
+	c.reply(resp.Integer(acked))
+}
+
+func handleReplconf(c *client, args []string) {
+	if len(args)%2 == 0 {
+		c.reply(resp.Error(syntaxError()))
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			if _, err := strconv.Atoi(args[i+1]); err != nil {
+				c.reply(resp.Error(notAnInteger()))
+				return
+			}
+			c.replicaPort = args[i+1]
+		case "ack":
+			// Acks come from replicas and get no reply.
+			if c.replica != nil {
+				if offset, err := strconv.ParseInt(args[i+1], 10, 64); err == nil {
+					c.replica.ackOffset.Store(offset)
+					c.replica.lastAck.Store(time.Now().Unix())
+					c.srv.repl.notifyAck()
+				}
+			}
+			return
+		case "getack":
+			// Our master wants to know how far we are.
+			if c.master {
+				c.srv.master.sendAck()
+			}
+			return
+		case "capa", "ip-address":
+		default:
+			c.reply(resp.Error(fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", args[i])))
+			return
+		}
+	}
+	c.reply(resp.SimpleString("OK"))
+}
+
+// handlePSync attaches a replica. One asking to resume our stream at an
+// offset the backlog still holds gets +CONTINUE and the part it missed;
+// any other gets a full resynchronization. From then on the connection
+// receives the replication stream.
+func handlePSync(c *client, args []string) {
+	if c.replica != nil {
+		return
+	}
+	s := c.srv
+	r := &replica{c: c, port: c.replicaPort, wake: make(chan struct{}, 1)}
+	r.lastAck.Store(time.Now().Unix())
+	resumed, err := s.partialSync(r, args[1], args[2])
+	if err == nil && !resumed {
+		err = s.fullSync(r)
+	}
+	if err != nil {
+		s.dropReplica(r)
+		return
+	}
+	c.replica = r
+	r.mu.Lock()
+	r.online = true
+	r.mu.Unlock()
+
+	s.background.Add(1)
+	go s.feedReplica(r)
+}
+
+// partialSync resumes r's stream if r asks for our replication id and
+// the backlog holds everything from the offset it gives, that of the
+// first byte it lacks counting from 1. It reports whether it did.
+func (s *Server) partialSync(r *replica, id, offsetArg string) (bool, error) {
+	from, err := strconv.ParseInt(offsetArg, 10, 64)
+	if err != nil || id == "?" {
+		return false, nil
+	}
+	c := r.c
+	repl := &s.repl
+	repl.mu.Lock()
+	missed := repl.offset - (from - 1)
+	var data []byte
+	resumed := false
+	if id == repl.id && repl.backlog != nil && missed >= 0 {
+		data, resumed = repl.backlog.last(int(missed))
+	}
+	if resumed {
+		// Later changes queue up behind the missed part.
+		r.queue = append(r.queue, replEntry{0, data})
+		r.queued = len(data)
+		r.wake <- struct{}{}
+		repl.replicas[r] = struct{}{}
+	}
+	ourID := repl.id
+	repl.mu.Unlock()
+	if !resumed {
+		if id != ourID {
+			c.log.Info("Partial resynchronization not accepted: replication ID mismatch", "replica", replicaAddr(r), "asked", id, "ours", ourID)
+		} else {
+			c.log.Info("Unable to partial resync with replica for lack of backlog", "replica", replicaAddr(r), "offset", from)
+		}
+		return false, nil
+	}
+
+	c.reply(resp.SimpleString("CONTINUE " + ourID))
+	c.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	if err := c.out.Flush(); err != nil {
+		return false, err
+	}
+	c.conn.SetWriteDeadline(time.Time{})
+	c.log.Info("Partial resynchronization request accepted", "replica", replicaAddr(r), "backlog_bytes", len(data), "offset", from)
+	return true, nil
+}
+
+// fullSync sends r the current replication id and offset, then an RDB
+// snapshot taken at exactly that offset.
+func (s *Server) fullSync(r *replica) error {
+	c := r.c
+	// Register first, so that every change the snapshot misses is queued.
+	// Changes queued before the snapshot was taken are in it already, and
+	// only move the offset the snapshot corresponds to.
+	s.repl.mu.Lock()
+	id, offset := s.repl.id, s.repl.offset
+	s.repl.replicas[r] = struct{}{}
+	if s.repl.backlog == nil {
+		s.repl.backlog = newBacklog(int(config.ReplBacklogSize.Load()))
+	}
+	s.repl.mu.Unlock()
+
+	snap := s.db.Snapshot()
+	r.mu.Lock()
+	i := 0
+	for ; i < len(r.queue) && r.queue[i].seq <= snap.Seq; i++ {
+		offset += int64(len(r.queue[i].data))
+		r.queued -= len(r.queue[i].data)
+	}
+	r.queue = r.queue[i:]
+	r.mu.Unlock()
+
+	var payload bytes.Buffer
+	if err := rdb.Write(&payload, snap, s.rdbAux()); err != nil {
+		return err
+	}
+	c.log.Info("Replica asks for synchronization", "replica", replicaAddr(r))
+	c.reply(resp.SimpleString(fmt.Sprintf("FULLRESYNC %s %d", id, offset)))
+	// The snapshot travels as a bulk string without the trailing CRLF.
+	c.out.Flush()
+	c.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	_, err := fmt.Fprintf(c.conn, "$%d\r\n", payload.Len())
+	if err == nil {
+		_, err = c.conn.Write(payload.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Time{})
+	c.log.Info("Synchronization with replica succeeded", "replica", replicaAddr(r))
+	return nil
+}
+
+// feedReplica writes the replication stream to r until it is dropped or
+// the server shuts down.
+func (s *Server) feedReplica(r *replica) {
+	defer s.background.Done()
+	var buf []byte
+	for {
+		select {
+		case <-s.closing:
+			s.dropReplica(r)
+			return
+		case _, ok := <-r.wake:
+			if !ok {
+				return
+			}
+		}
+		r.mu.Lock()
+		for _, e := range r.queue {
+			buf = append(buf, e.data...)
+		}
+		r.queue = nil
+		r.mu.Unlock()
+		if len(buf) == 0 {
+			continue
+		}
+		r.c.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+		if _, err := r.c.conn.Write(buf); err != nil {
+			r.c.log.Warn("Connection with replica lost", "replica", replicaAddr(r), "err", err)
+			s.dropReplica(r)
+			return
+		}
+		r.mu.Lock()
+		r.queued -= len(buf)
+		r.mu.Unlock()
+		buf = buf[:0]
+	}
+}
+
+func replicaAddr(r *replica) string {
+	host, _, _ := net.SplitHostPort(r.c.conn.RemoteAddr().String())
+	return net.JoinHostPort(host, r.port)
+}
+
+func (s *Server) replID() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return s.repl.id
+}
+
+// changeReplID gives the server a new replication id, for DEBUG
+// CHANGE-REPL-ID.
+func (s *Server) changeReplID() {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	s.repl.id = newRunID()
+}
+
+func (s *Server) rdbAux() []rdb.Aux {
+	return []rdb.Aux{
+		{Key: "redis-ver", Value: redisVersion},
+		{Key: "redis-bits", Value: strconv.Itoa(strconv.IntSize)},
+		{Key: "ctime", Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		{Key: "used-mem", Value: strconv.FormatInt(s.db.UsedMemory(), 10)},
+		{Key: "repl-id", Value: s.replID()},
+		{Key: "aof-base", Value: "0"},
+	}
+}
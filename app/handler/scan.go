@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"redis/app/glob"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+)
+
+// handleScan serves SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+// MATCH and TYPE filter the keys the store returns for the cursor, so a
+// call may return fewer than COUNT keys, or none, before the walk is over.
+func handleScan(c *client, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply(resp.Error("ERR invalid cursor"))
+		return
+	}
+	count := 10
+	var pattern, typ string
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				c.reply(resp.Error(notAnInteger()))
+				return
+			}
+			if count < 1 {
+				c.reply(resp.Error(syntaxError()))
+				return
+			}
+		case "MATCH":
+			pattern = args[i+1]
+		case "TYPE":
+			typ = strings.ToLower(args[i+1])
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+	}
+
+	keys, next := c.db.Scan(cursor, count)
+	matched := keys[:0]
+	for _, key := range keys {
+		if pattern != "" && !glob.Match(pattern, key, false) {
+			continue
+		}
+		if typ != "" {
+			if info, ok := c.db.Info(key); !ok || info.Type != typ {
+				continue
+			}
+		}
+		matched = append(matched, key)
+	}
+	c.reply(resp.Array{resp.BulkString(strconv.FormatUint(next, 10)), resp.BulkStrings(matched)})
+}
@@ -0,0 +1,389 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"redis/app/config"
+	"redis/app/resp"
+	"redis/app/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const errNoScript = "NOSCRIPT No matching script. Please use EVAL."
+
+// scriptCache holds every script given to EVAL or SCRIPT LOAD, compiled,
+// by the hex SHA1 of its source.
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*lua.FunctionProto
+}
+
+// load compiles src unless it is already cached and returns its SHA1.
+func (sc *scriptCache) load(src string) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+	if proto := sc.get(sha); proto != nil {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(src), "user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.scripts == nil {
+		sc.scripts = make(map[string]*lua.FunctionProto)
+	}
+	sc.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+func (sc *scriptCache) get(sha string) *lua.FunctionProto {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.scripts[strings.ToLower(sha)]
+}
+
+func (sc *scriptCache) flush() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.scripts = nil
+}
+
+func handleEval(c *client, args []string) {
+	_, proto, err := c.srv.scripts.load(args[1])
+	if err != nil {
+		c.reply(scriptError("ERR Error compiling script (new function): " + err.Error()))
+		return
+	}
+	runScript(c, proto, args[2], args[3:])
+}
+
+func handleEvalSha(c *client, args []string) {
+	proto := c.srv.scripts.get(args[1])
+	if proto == nil {
+		c.reply(resp.Error(errNoScript))
+		return
+	}
+	runScript(c, proto, args[2], args[3:])
+}
+
+func handleScript(c *client, args []string) {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "LOAD" && len(args) == 3:
+		sha, _, err := c.srv.scripts.load(args[2])
+		if err != nil {
+			c.reply(scriptError("ERR Error compiling script (new function): " + err.Error()))
+			return
+		}
+		c.reply(resp.BulkString(sha))
+	case sub == "EXISTS" && len(args) >= 3:
+		arr := make(resp.Array, len(args)-2)
+		for i, sha := range args[2:] {
+			if c.srv.scripts.get(sha) != nil {
+				arr[i] = resp.Integer(1)
+			} else {
+				arr[i] = resp.Integer(0)
+			}
+		}
+		c.reply(arr)
+	case sub == "FLUSH" && len(args) <= 3:
+		if len(args) == 3 {
+			if mode := strings.ToUpper(args[2]); mode != "ASYNC" && mode != "SYNC" {
+				c.reply(resp.Error("ERR SCRIPT FLUSH only support SYNC|ASYNC option"))
+				return
+			}
+		}
+		c.srv.scripts.flush()
+		c.reply(resp.SimpleString("OK"))
+	case sub == "LOAD" || sub == "EXISTS" || sub == "FLUSH":
+		c.reply(resp.Error(wrongArity("SCRIPT|" + sub)))
+	default:
+		c.reply(resp.Error(unknownSubcommand("SCRIPT", args[1])))
+	}
+}
+
+// runScript runs a compiled script with the keyspace to itself: no other
+// client's command runs until it returns.
+func runScript(c *client, proto *lua.FunctionProto, numkeys string, rest []string) {
+	n, err := strconv.Atoi(numkeys)
+	switch {
+	case err != nil:
+		c.reply(resp.Error(notAnInteger()))
+		return
+	case n < 0:
+		c.reply(resp.Error("ERR Number of keys can't be negative"))
+		return
+	case n > len(rest):
+		c.reply(resp.Error("ERR Number of keys can't be greater than number of args"))
+		return
+	}
+
+	var reply resp.Value
+	c.db.Atomic(func(tx store.Store) {
+		reply = c.scriptClient(tx).run(proto, rest[:n], rest[n:])
+	})
+	c.reply(reply)
+}
+
+// scriptClient returns the client a script's redis.call runs commands as:
+// c's user, with the script's view of the keyspace.
+func (c *client) scriptClient(tx store.Store) *client {
+	return &client{
+		out:           resp.NewEncoder(io.Discard),
+		db:            tx,
+		srv:           c.srv,
+		log:           c.log,
+		id:            c.id,
+		createdAt:     c.createdAt,
+		lastActive:    time.Now(),
+		user:          c.user,
+		authenticated: true,
+		script:        true,
+	}
+}
+
+func (c *client) run(proto *lua.FunctionProto, keys, argv []string) resp.Value {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Scripts get no access to the filesystem.
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, argv))
+	L.SetGlobal("redis", c.redisLib(L))
+
+	if ms := config.BusyReplyThreshold.Load(); ms > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ms)*time.Millisecond)
+		defer cancel()
+		L.SetContext(ctx)
+	}
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx := L.Context(); ctx != nil && ctx.Err() != nil {
+			return resp.Error(fmt.Sprintf("ERR Script exceeded busy-reply-threshold (%d ms) and was aborted", config.BusyReplyThreshold.Load()))
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return scriptError(string(msg))
+				}
+			}
+			return scriptError("ERR " + apiErr.Object.String())
+		}
+		return scriptError("ERR " + err.Error())
+	}
+	return luaToResp(L.Get(-1))
+}
+
+// scriptError makes an error reply of a Lua message, which may span
+// lines; error replies can't.
+func scriptError(msg string) resp.Error {
+	return resp.Error(strings.Join(strings.Fields(msg), " "))
+}
+
+func stringsTable(L *lua.LState, items []string) *lua.LTable {
+	t := L.CreateTable(len(items), 0)
+	for _, s := range items {
+		t.Append(lua.LString(s))
+	}
+	return t
+}
+
+// redisLib builds the redis table scripts call back into.
+func (c *client) redisLib(L *lua.LState) *lua.LTable {
+	lib := L.NewTable()
+	L.SetFuncs(lib, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return c.luaCall(L, true) },
+		"pcall": func(L *lua.LState) int { return c.luaCall(L, false) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			sum := sha1.Sum([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+	})
+	return lib
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+// luaCall implements redis.call, which raises command errors, and
+// redis.pcall, which returns them as an error table.
+func (c *client) luaCall(L *lua.LState, raise bool) int {
+	top := L.GetTop()
+	if top == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, top)
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString, lua.LNumber:
+			args[i] = v.String()
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	reply := c.call(args)
+	if msg, ok := reply.(resp.Error); ok && raise {
+		L.Error(replyTable(L, "err", string(msg)), 1)
+	}
+	L.Push(respToLua(L, reply))
+	return 1
+}
+
+// call runs one command from a script and returns its reply.
+func (c *client) call(args []string) resp.Value {
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		return resp.Error("ERR Unknown Redis command called from script")
+	}
+	if !cmd.arityOK(len(args)) {
+		return resp.Error("ERR Wrong number of args calling Redis command from script")
+	}
+	cmd, msg := cmd.resolve(args)
+	if msg != "" {
+		return resp.Error(msg)
+	}
+	if cmd.has(flagAdmin | flagNoScript) {
+		return resp.Error("ERR This Redis command is not allowed from script")
+	}
+	if msg := c.refusal(cmd, args); msg != "" {
+		c.srv.recordRejected(cmd)
+		return resp.Error(msg)
+	}
+	c.scriptReply = resp.Null{}
+	start := time.Now()
+	c.execute(cmd, args)
+	_, failed := c.scriptReply.(resp.Error)
+	c.srv.recordCall(cmd, time.Since(start), failed)
+	return c.scriptReply
+}
+
+// respToLua converts a reply for a script, following redis-server's rules:
+// status and error replies become tables with an ok or err field, and nil
+// replies become false.
+func respToLua(L *lua.LState, v resp.Value) lua.LValue {
+	switch v := v.(type) {
+	case resp.Integer:
+		return lua.LNumber(v)
+	case resp.BulkString:
+		return lua.LString(v)
+	case resp.SimpleString:
+		return replyTable(L, "ok", string(v))
+	case resp.Error:
+		return replyTable(L, "err", string(v))
+	case resp.Double:
+		return lua.LString(strconv.FormatFloat(float64(v), 'g', 17, 64))
+	case resp.Array:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(respToLua(L, item))
+		}
+		return t
+	case resp.StringArray:
+		t := L.CreateTable(len(v), 0)
+		for _, s := range v {
+			t.Append(lua.LString(s))
+		}
+		return t
+	case resp.Set:
+		return respToLua(L, resp.Array(v))
+	case resp.Push:
+		return respToLua(L, resp.Array(v))
+	case resp.Boolean:
+		// Scripts see replies as RESP2 clients do.
+		if v {
+			return lua.LNumber(1)
+		}
+		return lua.LNumber(0)
+	case resp.BigNumber:
+		return lua.LString(v)
+	case resp.Verbatim:
+		return lua.LString(v.Text)
+	case resp.Map:
+		t := L.CreateTable(2*len(v), 0)
+		for _, kv := range v {
+			t.Append(respToLua(L, kv.Key))
+			t.Append(respToLua(L, kv.Value))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// luaToResp converts a script's return value to its reply. Numbers are
+// truncated to integers, true becomes 1, and arrays stop at the first nil.
+func luaToResp(v lua.LValue) resp.Value {
+	switch v := v.(type) {
+	case lua.LString:
+		return resp.BulkString(v)
+	case lua.LNumber:
+		return resp.Integer(int64(v))
+	case lua.LBool:
+		if v {
+			return resp.Integer(1)
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.Error(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return resp.SimpleString(string(msg))
+		}
+		var arr resp.Array
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			arr = append(arr, luaToResp(item))
+		}
+		if arr == nil {
+			arr = resp.Array{}
+		}
+		return arr
+	}
+	return resp.Null{}
+}
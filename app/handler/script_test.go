@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"redis/app/resp"
+	"strings"
+	"testing"
+)
+
+// TestEvalConversions checks the values scripts return, and the replies
+// redis.call hands them, convert the way redis-server converts them.
+func TestEvalConversions(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "str", "v")
+	c.expect(resp.Integer(2), "RPUSH", "list", "a", "b")
+	for _, tc := range []struct {
+		script string
+		want   resp.Value
+	}{
+		// Lua to RESP.
+		{"return 1", resp.Integer(1)},
+		{"return 3.99", resp.Integer(3)},
+		{"return -3.99", resp.Integer(-3)},
+		{"return 'x'", bulk("x")},
+		{"return true", resp.Integer(1)},
+		{"return false", resp.Null{}},
+		{"return nil", resp.Null{}},
+		{"return {}", resp.Array{}},
+		{"return {1, 'a', {2, {}}}", resp.Array{resp.Integer(1), bulk("a"), resp.Array{resp.Integer(2), resp.Array{}}}},
+		// Arrays stop at the first nil.
+		{"return {1, nil, 3}", resp.Array{resp.Integer(1)}},
+		{"return {1, false, 3}", resp.Array{resp.Integer(1), resp.Null{}, resp.Integer(3)}},
+		{"return {ok = 'fine'}", resp.SimpleString("fine")},
+		{"return {err = 'ERR bad'}", resp.Error("ERR bad")},
+		{"return redis.status_reply('fine')", resp.SimpleString("fine")},
+		{"return redis.error_reply('MY error')", resp.Error("MY error")},
+		// RESP to Lua, and back.
+		{"return redis.call('GET', 'str')", bulk("v")},
+		{"return redis.call('GET', 'missing') == false", resp.Integer(1)},
+		{"return redis.call('LLEN', 'list')", resp.Integer(2)},
+		{"return type(redis.call('LLEN', 'list'))", bulk("number")},
+		{"return redis.call('LRANGE', 'list', 0, -1)", resp.Array{bulk("a"), bulk("b")}},
+		{"return redis.call('PING')", resp.SimpleString("PONG")},
+		{"return redis.call('PING').ok", bulk("PONG")},
+		{"return redis.pcall('LPUSH', 'str', 'x').err", bulk(wrongType())},
+	} {
+		c.expect(tc.want, "EVAL", tc.script, "0")
+	}
+	c.expect(resp.Array{bulk("k1"), bulk("k2"), bulk("a1")}, "EVAL", "return {KEYS[1], KEYS[2], ARGV[1]}", "2", "k1", "k2", "a1")
+	c.expect(resp.Integer(0), "EVAL", "return #KEYS + #ARGV", "0")
+}
+
+// TestEvalErrors checks redis.call raises a command's error, ending the
+// script, where redis.pcall returns it for the script to handle.
+func TestEvalErrors(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(ok(), "SET", "str", "v")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"return redis.call('LPUSH', 'str', 'x')", "0"}, wrongType()},
+		{[]string{"redis.call('LPUSH', 'str', 'x') return 'unreached'", "0"}, wrongType()},
+		{[]string{"return redis.pcall('LPUSH', 'str', 'x')", "0"}, wrongType()},
+		{[]string{"error('boom')", "0"}, "ERR user_script:1: boom"},
+		{[]string{"error({err = 'MY own'})", "0"}, "MY own"},
+		{[]string{"return redis.call('NOPE')", "0"}, "ERR Unknown Redis command called from script"},
+		{[]string{"return redis.call('GET')", "0"}, "ERR Wrong number of args calling Redis command from script"},
+		{[]string{"return redis.call('SHUTDOWN')", "0"}, "ERR This Redis command is not allowed from script"},
+		{[]string{"return redis.call()", "0"}, "ERR user_script:1: Please specify at least one argument for this redis lib call"},
+		{[]string{"return redis.call('GET', {})", "0"}, "ERR user_script:1: Lua redis lib command arguments must be strings or integers"},
+		{[]string{"return 1 +", "0"}, "ERR Error compiling script (new function): user_script at EOF: syntax error"},
+		{[]string{"return 1", "x"}, notAnInteger()},
+		{[]string{"return 1", "-1"}, "ERR Number of keys can't be negative"},
+		{[]string{"return 1", "2", "k"}, "ERR Number of keys can't be greater than number of args"},
+	} {
+		c.expect(resp.Error(tc.want), append([]string{"EVAL"}, tc.args...)...)
+	}
+
+	// pcall lets the script carry on past the error.
+	c.expect(bulk("handled"), "EVAL", "if redis.pcall('LPUSH', 'str', 'x').err then return 'handled' end", "0")
+	// Writes made before an error stay made: there is no rollback.
+	c.expect(resp.Error(wrongType()), "EVAL", "redis.call('SET', 'before', '1') redis.call('LPUSH', 'str', 'x') redis.call('SET', 'after', '1')", "0")
+	c.expect(bulk("1"), "GET", "before")
+	c.expect(resp.Null{}, "GET", "after")
+}
+
+func TestEvalSha(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	script := "return ARGV[1]"
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	c.expect(resp.Error(errNoScript), "EVALSHA", sha, "0", "x")
+	c.expect(resp.Array{resp.Integer(0)}, "SCRIPT", "EXISTS", sha)
+	c.expect(bulk(sha), "SCRIPT", "LOAD", script)
+	c.expect(bulk("x"), "EVALSHA", sha, "0", "x")
+	c.expect(bulk("y"), "EVALSHA", strings.ToUpper(sha), "0", "y")
+	c.expect(resp.Array{resp.Integer(1), resp.Integer(0)}, "SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000")
+
+	c.expect(ok(), "SCRIPT", "FLUSH")
+	c.expect(resp.Error(errNoScript), "EVALSHA", sha, "0", "x")
+	// EVAL caches what it runs as well.
+	c.expect(bulk("z"), "EVAL", script, "0", "z")
+	c.expect(bulk("x"), "EVALSHA", sha, "0", "x")
+	c.expect(ok(), "SCRIPT", "FLUSH", "ASYNC")
+	c.expect(resp.Array{resp.Integer(0)}, "SCRIPT", "EXISTS", sha)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SCRIPT", "LOAD", "return 1 +"}, "ERR Error compiling script (new function): user_script at EOF: syntax error"},
+		{[]string{"SCRIPT", "FLUSH", "NOW"}, "ERR SCRIPT FLUSH only support SYNC|ASYNC option"},
+		{[]string{"SCRIPT", "LOAD"}, wrongArity("SCRIPT|LOAD")},
+		{[]string{"SCRIPT", "EXISTS"}, wrongArity("SCRIPT|EXISTS")},
+		{[]string{"SCRIPT", "NOPE"}, unknownSubcommand("SCRIPT", "NOPE")},
+	} {
+		c.expect(resp.Error(tc.want), tc.args...)
+	}
+}
+
+// TestEvalIsAtomic has one connection read a key over and over while a
+// script sets it, spins for a while and sets it again. The reader must
+// only ever see the key before the script or after it.
+func TestEvalIsAtomic(t *testing.T) {
+	s := newTestServer(t)
+	c, reader := dial(t, s), dial(t, s)
+	const script = "redis.call('SET', KEYS[1], 'partial') for i = 1, 500000 do end redis.call('SET', KEYS[1], 'done')"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := c.try("EVAL", script, "1", "k"); err != nil || !sameValue(v, resp.Null{}) {
+			t.Errorf("EVAL = %s, %v", show(v), err)
+		}
+	}()
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		default:
+		}
+		v, err := reader.try("GET", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameValue(v, resp.Null{}) && !sameValue(v, bulk("done")) {
+			t.Fatalf("read %s while the script ran", show(v))
+		}
+	}
+	reader.expect(bulk("done"), "GET", "k")
+}
@@ -0,0 +1,412 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/store"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const errMaxClients = "ERR max number of clients reached"
+
+const (
+	shutdownSaveDefault = iota // save if any save rules are configured
+	shutdownSaveForce
+	shutdownSaveNever
+)
+
+const (
+	// serverHz is how often background jobs such as active expiry run.
+	serverHz = 10
+	// shutdownGrace is how long Shutdown lets in-flight commands finish
+	// before it closes connections out from under them.
+	shutdownGrace = 500 * time.Millisecond
+)
+
+// Server accepts client connections and serves them all from one store.
+// It can listen for plaintext and TLS clients at the same time.
+type Server struct {
+	db  store.Store
+	log *slog.Logger
+	// clock is the one db uses, for key deadlines and blocking timeouts.
+	// Save rules are timed by it too.
+	clock    clock.Clock
+	listener net.Listener
+	tls      net.Listener
+	// port and tlsPort are the ports the listeners are bound to, which
+	// INFO and REPLCONF report. They are the server's own rather than the
+	// port settings, so that servers in one process each report theirs.
+	port, tlsPort int64
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	conns   sync.WaitGroup
+	// connected counts open connections, including ones still in the TLS
+	// handshake, against maxclients.
+	connected    atomic.Int64
+	nextClientID atomic.Int64
+
+	runID     string
+	startTime time.Time
+	// Counters for INFO stats.
+	totalConnections    atomic.Int64
+	totalCommands       atomic.Int64
+	rejectedConnections atomic.Int64
+	// outputBufferDisconnections counts clients dropped for going over
+	// client-output-buffer-limit.
+	outputBufferDisconnections atomic.Int64
+	// queryBufferDisconnections counts clients dropped for a request over
+	// the size limits.
+	queryBufferDisconnections atomic.Int64
+	cmdStats                  []commandStats
+	// The heap in use at startup and the most seen since, for MEMORY
+	// STATS.
+	startupAllocated int64
+	peakAllocated    atomic.Uint64
+
+	slowlog slowlog
+	// activeExpire is cleared by DEBUG SET-ACTIVE-EXPIRE 0 to leave expiry
+	// to lookups alone.
+	activeExpire atomic.Bool
+
+	// lastSave is the unix time of the last successful save. saveMu
+	// serializes writers of the dump file.
+	lastSave atomic.Int64
+	saveMu   sync.Mutex
+	// seq is the sequence number of the latest change to the keyspace and
+	// savedSeq that of the latest change a save covered.
+	seq              atomic.Uint64
+	savedSeq         atomic.Uint64
+	bgsaveInProgress atomic.Bool
+	lastBgsaveOK     atomic.Bool
+	// lastBgsaveTry is the unix time the last background save started.
+	lastBgsaveTry atomic.Int64
+	// shutdownSave is what Shutdown does about saving, set by SHUTDOWN's
+	// SAVE and NOSAVE options.
+	shutdownSave atomic.Int32
+	aof          aof
+	repl         replication
+	// replicaOf is the master's "host port", empty on a master. It starts
+	// as the replicaof setting. REPLICAOF changes both, but only this copy
+	// decides whether the server is a replica, so that servers in one
+	// process can replicate one another.
+	replicaOf config.String
+	master    masterLink
+	scripts   scriptCache
+	// hooks are the ones added with Use and UseReplayed, replaced whole
+	// under hookMu when one is added.
+	hooks  atomic.Pointer[[]hook]
+	hookMu sync.Mutex
+
+	background sync.WaitGroup
+	// closing is closed once shutdown has begun.
+	closing      chan struct{}
+	startOnce    sync.Once
+	shutdownOnce sync.Once
+}
+
+// NewServer returns a server for db that logs to log. clk must be the
+// clock db was made with.
+func NewServer(db store.Store, log *slog.Logger, clk clock.Clock) *Server {
+	s := &Server{
+		db:        db,
+		log:       log,
+		clock:     clk,
+		clients:   make(map[*client]struct{}),
+		closing:   make(chan struct{}),
+		runID:     newRunID(),
+		startTime: time.Now(),
+		cmdStats:  newCommandStats(),
+	}
+	s.activeExpire.Store(true)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.startupAllocated = int64(ms.HeapAlloc)
+	s.peakAllocated.Store(ms.HeapAlloc)
+	s.lastSave.Store(clk.Now().Unix())
+	s.lastBgsaveOK.Store(true)
+	s.aof.log = log
+	s.aof.lastRewriteOK = true
+	s.replicaOf.Store(config.ReplicaOf.Load())
+	s.repl.id = newRunID()
+	s.repl.replicas = make(map[*replica]struct{})
+	s.repl.acked = make(chan struct{})
+	db.SetPropagator(s.propagate)
+	return s
+}
+
+// Listen binds addr. Port 0 picks a free port; the server reports the
+// one it got.
+func (s *Server) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	s.port = listenPort(l)
+	return nil
+}
+
+// ListenTLS binds addr for TLS clients, recording the port like Listen.
+func (s *Server) ListenTLS(addr string, cfg *tls.Config) error {
+	l, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	s.tls = l
+	s.tlsPort = listenPort(l)
+	return nil
+}
+
+// CloseListeners closes what Listen and ListenTLS bound, for a server
+// that won't be served after all.
+func (s *Server) CloseListeners() {
+	for _, l := range []net.Listener{s.listener, s.tls} {
+		if l != nil {
+			l.Close()
+		}
+	}
+}
+
+// RunID returns the random id the server was given at startup, which
+// INFO reports as run_id and CLUSTER MYID as the node id.
+func (s *Server) RunID() string {
+	return s.runID
+}
+
+// newRunID returns 40 random hex characters, like redis-server's run_id.
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func listenPort(l net.Listener) int64 {
+	if tcp, ok := l.Addr().(*net.TCPAddr); ok {
+		return int64(tcp.Port)
+	}
+	return 0
+}
+
+// Addr returns the address the server is listening on for plaintext
+// clients.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// TLSAddr returns the address the server is listening on for TLS clients,
+// nil if it isn't.
+func (s *Server) TLSAddr() net.Addr {
+	if s.tls == nil {
+		return nil
+	}
+	return s.tls.Addr()
+}
+
+// Serve runs the background jobs and the accept loops. It returns once
+// Shutdown has finished.
+func (s *Server) Serve() error {
+	s.background.Add(2)
+	go s.cron()
+	go s.aofSyncLoop()
+	if s.isReplica() {
+		s.startReplication()
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range []net.Listener{s.listener, s.tls} {
+		if l == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.acceptLoop(l)
+		}()
+	}
+	wg.Wait()
+	s.Shutdown()
+	return nil
+}
+
+func (s *Server) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Warn("Failed to accept connection", "err", err)
+			continue
+		}
+		s.totalConnections.Add(1)
+		if s.connected.Add(1) > config.MaxClients.Load() {
+			s.connected.Add(-1)
+			s.rejectedConnections.Add(1)
+			go rejectConn(conn, errMaxClients)
+			continue
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			defer s.connected.Add(-1)
+			if tlsConn, ok := conn.(*tls.Conn); ok && !handshake(tlsConn, s.log) {
+				return
+			}
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+// rejectConn sends a connection we won't serve the reason and hangs up.
+func rejectConn(conn net.Conn, msg string) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("-" + msg + "\r\n"))
+	conn.Close()
+}
+
+// resetStats zeroes the counters CONFIG RESETSTAT covers.
+func (s *Server) resetStats() {
+	s.totalConnections.Store(0)
+	s.totalCommands.Store(0)
+	s.rejectedConnections.Store(0)
+	s.outputBufferDisconnections.Store(0)
+	s.queryBufferDisconnections.Store(0)
+	s.resetCommandStats()
+	s.db.ResetStats()
+}
+
+// ConnectedClients returns the number of open client connections.
+func (s *Server) ConnectedClients() int64 {
+	return s.connected.Load()
+}
+
+// cron runs periodic jobs until shutdown.
+func (s *Server) cron() {
+	defer s.background.Done()
+	ticker := time.NewTicker(time.Second / serverHz)
+	defer ticker.Stop()
+	for tick := 0; ; tick++ {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			if tick%serverHz == 0 {
+				s.sampleMemory()
+				s.saveIfNeeded()
+			}
+			if s.activeExpire.Load() {
+				if n := s.db.ActiveExpireCycle(); n > 0 {
+					s.log.Debug("Active expire cycle", "expired", n)
+				}
+			}
+			s.flushAOF()
+			s.rewriteAOFIfNeeded()
+		}
+	}
+}
+
+func (s *Server) addClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c] = struct{}{}
+}
+
+// removeClient is the teardown every connection goes through when it
+// closes, however that came about: it releases whatever the client still
+// holds in the server.
+func (s *Server) removeClient(c *client) {
+	c.abandonWait()
+	if c.replica != nil {
+		s.dropReplica(c.replica)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// shuttingDown reports whether shutdown has begun.
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// startShutdown stops accepting connections and tells every client to
+// finish up: idle ones are woken by an expired read deadline, blocked ones
+// by closing.
+func (s *Server) startShutdown() {
+	s.startOnce.Do(func() {
+		close(s.closing)
+		for _, l := range []net.Listener{s.listener, s.tls} {
+			if l != nil {
+				l.Close()
+			}
+		}
+		s.mu.Lock()
+		for c := range s.clients {
+			c.conn.SetReadDeadline(time.Now())
+		}
+		s.mu.Unlock()
+	})
+}
+
+// Shutdown stops the server. Commands already running get shutdownGrace
+// to complete and send their replies; connections still open after that
+// are closed. The AOF is then flushed and the keyspace saved if save
+// rules are configured or SHUTDOWN SAVE asked for it. Shutdown is safe to
+// call more than once and from several goroutines; every call returns
+// once the server has stopped.
+func (s *Server) Shutdown() {
+	s.startShutdown()
+	s.shutdownOnce.Do(func() {
+		drained := make(chan struct{})
+		go func() {
+			s.conns.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(shutdownGrace):
+			s.mu.Lock()
+			for c := range s.clients {
+				c.conn.Close()
+			}
+			s.mu.Unlock()
+			<-drained
+		}
+		s.background.Wait()
+		s.db.DrainLazyFree()
+		s.stopAOF()
+		s.saveOnShutdown()
+	})
+}
+
+func (s *Server) saveOnShutdown() {
+	switch s.shutdownSave.Load() {
+	case shutdownSaveNever:
+		return
+	case shutdownSaveDefault:
+		if len(config.SaveRules()) == 0 {
+			return
+		}
+	}
+	s.log.Info("Saving the final RDB snapshot before exiting.")
+	if err := s.save(s.db.Snapshot()); err != nil {
+		s.log.Warn("Error trying to save the DB, can't exit", "err", err)
+		return
+	}
+	s.log.Info("DB saved on disk")
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/resp"
+	"redis/app/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a server on a free local port that lives as long
+// as the test. Saving and the AOF are off and the data directory is the
+// test's own, so nothing is written outside it.
+func newTestServer(t testing.TB) *Server {
+	return newTestServerClock(t, clock.Real)
+}
+
+// newTestServerClock is newTestServer with the clock keys and blocking
+// timeouts are measured against.
+func newTestServerClock(t testing.TB, clk clock.Clock) *Server {
+	t.Helper()
+	s := newUnstartedServer(t, clk)
+	serve(t, s)
+	return s
+}
+
+// newUnstartedServer is newTestServerClock without starting Serve, so the
+// test can set up more listeners first.
+func newUnstartedServer(t testing.TB, clk clock.Clock) *Server {
+	t.Helper()
+	setConfig(t, "save", "", "appendonly", "no", "dir", t.TempDir(), "replicaof", "")
+	s := NewServer(store.NewMemory(clk), slog.New(slog.NewTextHandler(io.Discard, nil)), clk)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serve runs s until the test ends.
+func serve(t testing.TB, s *Server) {
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.Serve()
+	}()
+	t.Cleanup(func() {
+		s.Shutdown()
+		<-served
+	})
+}
+
+// setConfig applies name, value pairs of settings for the rest of the
+// test and puts the previous values back when it ends.
+func setConfig(t testing.TB, pairs ...string) {
+	t.Helper()
+	for i := 0; i < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		old, ok := config.Get(name)
+		if !ok {
+			t.Fatalf("no setting %s", name)
+		}
+		if err := config.SetInitial(name, value); err != nil {
+			t.Fatalf("setting %s to %q: %v", name, value, err)
+		}
+		t.Cleanup(func() { config.SetInitial(name, old) })
+	}
+}
+
+// testClient is a connection to a test server speaking RESP.
+type testClient struct {
+	t    testing.TB
+	conn net.Conn
+	dec  *resp.Decoder
+}
+
+func dial(t testing.TB, s *Server) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, dec: resp.NewDecoder(conn)}
+}
+
+// do sends a command and returns its reply, failing the test if none
+// comes. It must be called from the test's goroutine; others use try.
+func (tc *testClient) do(args ...string) resp.Value {
+	tc.t.Helper()
+	v, err := tc.try(args...)
+	if err != nil {
+		tc.t.Fatalf("%q: %v", args, err)
+	}
+	return v
+}
+
+// try sends a command and returns its reply or why there is none.
+func (tc *testClient) try(args ...string) (resp.Value, error) {
+	if err := tc.send(args...); err != nil {
+		return nil, err
+	}
+	return tc.read()
+}
+
+func (tc *testClient) send(args ...string) error {
+	_, err := tc.conn.Write(resp.AppendCommand(nil, args))
+	return err
+}
+
+// read returns the next reply, waiting at most a few seconds for it.
+func (tc *testClient) read() (resp.Value, error) {
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return tc.dec.Decode()
+}
+
+// readRaw returns exactly the next n bytes the server sends.
+func (tc *testClient) readRaw(n int) []byte {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(tc.dec.Reader(), buf); err != nil {
+		tc.t.Fatalf("reading %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+// expect sends a command and fails the test unless the reply is want.
+func (tc *testClient) expect(want resp.Value, args ...string) {
+	tc.t.Helper()
+	if got := tc.do(args...); !sameValue(got, want) {
+		tc.t.Errorf("%q = %s, want %s", args, show(got), show(want))
+	}
+}
+
+// info returns one field of an INFO section.
+func (tc *testClient) info(section, field string) string {
+	tc.t.Helper()
+	text, _ := tc.do("INFO", section).(resp.BulkString)
+	for _, line := range strings.Split(string(text), "\r\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			return v
+		}
+	}
+	tc.t.Fatalf("INFO %s has no %s", section, field)
+	return ""
+}
+
+// sameValue compares replies by their wire form, so that a BulkString
+// and a StringArray element holding the same bytes are equal.
+func sameValue(a, b resp.Value) bool {
+	return bytes.Equal(encode(a), encode(b))
+}
+
+func encode(v resp.Value) []byte {
+	var buf bytes.Buffer
+	e := resp.NewEncoder(&buf)
+	e.Encode(v)
+	e.Flush()
+	return buf.Bytes()
+}
+
+func show(v resp.Value) string {
+	return fmt.Sprintf("%q", encode(v))
+}
+
+func bulk(s string) resp.BulkString {
+	return resp.BulkString(s)
+}
+
+func ok() resp.SimpleString {
+	return resp.SimpleString("OK")
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strings"
+)
+
+// handleShutdown stops the server the same way a SIGTERM does. On success
+// there is no reply: the connection just closes.
+func handleShutdown(c *client, args []string) {
+	mode := int32(shutdownSaveDefault)
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			mode = shutdownSaveForce
+		case "NOSAVE":
+			mode = shutdownSaveNever
+		default:
+			c.reply(resp.Error(syntaxError()))
+			return
+		}
+	}
+	c.srv.shutdownSave.Store(mode)
+	c.log.Warn("User requested shutdown...")
+	c.srv.startShutdown()
+	// Shutdown waits for every connection, this one included, so it can't
+	// run on this goroutine.
+	go c.srv.Shutdown()
+}
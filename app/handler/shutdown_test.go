@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net"
+	"redis/app/resp"
+	"testing"
+	"time"
+)
+
+// shutdownWithin calls Shutdown and fails the test if it takes longer
+// than d.
+func shutdownWithin(t *testing.T, s *Server, d time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("Shutdown still waiting after %v", d)
+	}
+}
+
+func TestShutdownReleasesBlockedClients(t *testing.T) {
+	s := newTestServer(t)
+	waiter := blockedClients(t, s, "q", 1)[0]
+	shutdownWithin(t, s, time.Second)
+	if v, err := waiter.read(); err == nil {
+		t.Errorf("blocked client got %s, want its connection closed", show(v))
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	s := newTestServer(t)
+	waiter := blockedClients(t, s, "q", 1)[0]
+	c := dial(t, s)
+	c.expect(resp.Error(syntaxError()), "SHUTDOWN", "LATER")
+	if v, err := c.try("SHUTDOWN", "NOSAVE"); err == nil {
+		t.Errorf("SHUTDOWN replied %s, want the connection closed", show(v))
+	}
+	if _, err := waiter.read(); err == nil {
+		t.Error("blocked client still connected")
+	}
+	// The command ran the same shutdown, so this one only waits for it.
+	shutdownWithin(t, s, time.Second)
+	if conn, err := net.Dial("tcp", s.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("still accepting connections")
+	}
+}
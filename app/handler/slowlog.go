@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"fmt"
+	"redis/app/config"
+	"redis/app/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Arguments recorded in a slow log entry are truncated like redis-server
+// does, so one huge command can't pin a lot of memory.
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the last slowlog-max-len commands that took longer than
+// slowlog-log-slower-than, oldest first.
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	nextID  int64
+}
+
+// record logs the command c just ran if it was slow enough. duration is
+// execution time only; time spent blocked has already been taken out.
+func (l *slowlog) record(c *client, args []string, duration time.Duration) {
+	threshold := config.SlowlogLogSlowerThan.Load()
+	if threshold < 0 || duration < time.Duration(threshold)*time.Microsecond {
+		return
+	}
+
+	argc := min(len(args), slowlogMaxArgc)
+	logged := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		switch {
+		case argc < len(args) && i == argc-1:
+			logged[i] = fmt.Sprintf("... (%d more arguments)", len(args)-argc+1)
+		case len(args[i]) > slowlogMaxArgLen:
+			logged[i] = fmt.Sprintf("%s... (%d more bytes)", args[i][:slowlogMaxArgLen], len(args[i])-slowlogMaxArgLen)
+		default:
+			// Copy, so the entry doesn't keep the whole request alive.
+			logged[i] = strings.Clone(args[i])
+		}
+	}
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+	addr := ""
+	if c.conn != nil {
+		addr = c.conn.RemoteAddr().String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, slowlogEntry{
+		id:       l.nextID,
+		time:     time.Now(),
+		duration: duration,
+		args:     logged,
+		addr:     addr,
+		name:     name,
+	})
+	l.nextID++
+	l.trim()
+}
+
+// trim drops the oldest entries beyond slowlog-max-len. Callers must hold
+// mu.
+func (l *slowlog) trim() {
+	if max := int(config.SlowlogMaxLen.Load()); len(l.entries) > max {
+		l.entries = append(l.entries[:0:0], l.entries[len(l.entries)-max:]...)
+	}
+}
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+func handleSlowlog(c *client, args []string) {
+	l := &c.srv.slowlog
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "GET" && len(args) <= 3:
+		count := 10
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < -1 {
+				c.reply(resp.Error("ERR count should be greater than or equal to -1"))
+				return
+			}
+			count = n
+		}
+		l.mu.Lock()
+		l.trim()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+		arr := make(resp.Array, 0, count)
+		for i := len(l.entries) - 1; i >= len(l.entries)-count; i-- {
+			e := l.entries[i]
+			arr = append(arr, resp.Array{
+				resp.Integer(e.id),
+				resp.Integer(e.time.Unix()),
+				resp.Integer(e.duration.Microseconds()),
+				resp.BulkStrings(e.args),
+				resp.BulkString(e.addr),
+				resp.BulkString(e.name),
+			})
+		}
+		l.mu.Unlock()
+		c.reply(arr)
+	case sub == "LEN" && len(args) == 2:
+		l.mu.Lock()
+		l.trim()
+		n := len(l.entries)
+		l.mu.Unlock()
+		c.reply(resp.Integer(n))
+	case sub == "RESET" && len(args) == 2:
+		l.mu.Lock()
+		l.entries = nil
+		l.mu.Unlock()
+		c.reply(resp.SimpleString("OK"))
+	case sub == "HELP" && len(args) == 2:
+		arr := make(resp.Array, len(slowlogHelp))
+		for i, line := range slowlogHelp {
+			arr[i] = resp.SimpleString(line)
+		}
+		c.reply(arr)
+	default:
+		c.reply(resp.Error(unknownSubcommand("SLOWLOG", args[1])))
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"redis/app/resp"
+	"redis/app/store"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errSortScore = errors.New("ERR One or more scores can't be converted into double")
+
+// sortOptions holds what SORT's arguments ask for.
+type sortOptions struct {
+	offset, count int
+	desc, alpha   bool
+	by            string
+	get           []string
+	store         string
+	limited       bool
+}
+
+// sortOption is one keyword SORT accepts, with how many arguments follow
+// it and what it sets.
+type sortOption struct {
+	args  int
+	apply func(o *sortOptions, args []string) error
+}
+
+var sortOptionTable = map[string]sortOption{
+	"ASC":   {0, func(o *sortOptions, _ []string) error { o.desc = false; return nil }},
+	"DESC":  {0, func(o *sortOptions, _ []string) error { o.desc = true; return nil }},
+	"ALPHA": {0, func(o *sortOptions, _ []string) error { o.alpha = true; return nil }},
+	"BY":    {1, func(o *sortOptions, args []string) error { o.by = args[0]; return nil }},
+	"GET":   {1, func(o *sortOptions, args []string) error { o.get = append(o.get, args[0]); return nil }},
+	"STORE": {1, func(o *sortOptions, args []string) error { o.store = args[0]; return nil }},
+	"LIMIT": {2, func(o *sortOptions, args []string) error {
+		offset, err1 := strconv.Atoi(args[0])
+		count, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil {
+			return errors.New(notAnInteger())
+		}
+		o.offset, o.count, o.limited = offset, count, true
+		return nil
+	}},
+}
+
+func parseSortOptions(args []string, readonly bool) (*sortOptions, error) {
+	o := &sortOptions{}
+	for i := 0; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		opt, ok := sortOptionTable[name]
+		if !ok || i+opt.args >= len(args) || readonly && name == "STORE" {
+			return nil, errors.New(syntaxError())
+		}
+		if err := opt.apply(o, args[i+1:i+1+opt.args]); err != nil {
+			return nil, err
+		}
+		i += opt.args
+	}
+	return o, nil
+}
+
+func handleSort(c *client, args []string) {
+	sortCommand(c, args, false)
+}
+
+func handleSortRO(c *client, args []string) {
+	sortCommand(c, args, true)
+}
+
+// sortCommand implements SORT and SORT_RO. Reading the elements and any
+// keys BY or GET refer to, and storing the result, happen as one atomic
+// step.
+func sortCommand(c *client, args []string, readonly bool) {
+	o, err := parseSortOptions(args[2:], readonly)
+	if err != nil {
+		c.reply(resp.Error(err.Error()))
+		return
+	}
+	var reply resp.Value
+	c.db.Atomic(func(tx store.Store) {
+		reply = o.run(tx, args[1])
+	})
+	c.reply(reply)
+}
+
+// sortItem is an element being sorted with the weight it sorts by.
+type sortItem struct {
+	elem  string
+	score float64
+	alpha string
+	isNil bool
+}
+
+func (o *sortOptions) run(tx store.Store, key string) resp.Value {
+	elems, err := tx.LRange(key, 0, -1)
+	if err != nil {
+		return resp.Error(wrongType())
+	}
+	// A BY pattern without a * names no per-element key, so there is
+	// nothing to sort by and the list keeps its order.
+	nosort := o.by != "" && !strings.Contains(o.by, "*")
+
+	items := make([]sortItem, len(elems))
+	for i, elem := range elems {
+		items[i].elem = elem
+		if nosort {
+			continue
+		}
+		weight, ok := elem, true
+		if o.by != "" {
+			weight, ok = lookupPattern(tx, o.by, elem)
+		}
+		switch {
+		case o.alpha:
+			items[i].alpha, items[i].isNil = weight, !ok
+		case ok:
+			score, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+			if err != nil {
+				return resp.Error(errSortScore.Error())
+			}
+			items[i].score = score
+		}
+	}
+	if !nosort {
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp := compareSortItems(&items[i], &items[j], o.alpha)
+			if o.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+	items = o.limit(items)
+
+	var out []resp.Value
+	for _, item := range items {
+		if len(o.get) == 0 {
+			out = append(out, resp.BulkString(item.elem))
+			continue
+		}
+		for _, pattern := range o.get {
+			if v, ok := lookupPattern(tx, pattern, item.elem); ok {
+				out = append(out, resp.BulkString(v))
+			} else {
+				out = append(out, resp.Null{})
+			}
+		}
+	}
+
+	if o.store == "" {
+		return resp.Array(out)
+	}
+	values := make([]string, len(out))
+	for i, v := range out {
+		if b, ok := v.(resp.BulkString); ok {
+			values[i] = string(b)
+		}
+	}
+	tx.Delete(o.store)
+	if len(values) > 0 {
+		tx.RPush(o.store, values...)
+	}
+	return resp.Integer(len(values))
+}
+
+// compareSortItems orders by weight, then by the elements themselves so
+// that equal weights sort the same way every time.
+func compareSortItems(a, b *sortItem, alpha bool) int {
+	switch {
+	case alpha && a.isNil != b.isNil:
+		if a.isNil {
+			return -1
+		}
+		return 1
+	case alpha:
+		if cmp := strings.Compare(a.alpha, b.alpha); cmp != 0 {
+			return cmp
+		}
+	case a.score < b.score:
+		return -1
+	case a.score > b.score:
+		return 1
+	}
+	return bytes.Compare([]byte(a.elem), []byte(b.elem))
+}
+
+// limit applies LIMIT offset count, where a negative count means all the
+// rest.
+func (o *sortOptions) limit(items []sortItem) []sortItem {
+	if !o.limited {
+		return items
+	}
+	start := min(max(o.offset, 0), len(items))
+	end := len(items)
+	if o.count >= 0 {
+		end = min(start+o.count, len(items))
+	}
+	return items[start:end]
+}
+
+// lookupPattern resolves a BY or GET pattern for elem: # is elem itself,
+// otherwise the first * is replaced by elem to name a key. A key->field
+// pattern reads a hash field, and as there are no hashes it finds nothing.
+func lookupPattern(tx store.Store, pattern, elem string) (string, bool) {
+	if pattern == "#" {
+		return elem, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	key := pattern[:star] + elem + pattern[star+1:]
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+3+arrow < len(pattern) {
+		return "", false
+	}
+	v, ok, err := tx.Get(key)
+	return v, ok && err == nil
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Integer(4), "RPUSH", "l", "3", "1", "2", "10")
+	c.expect(resp.Integer(3), "RPUSH", "words", "banana", "apple", "cherry")
+	for elem, weight := range map[string]string{"3": "1", "1": "3", "2": "2", "10": "0"} {
+		c.expect(ok(), "SET", "weight_"+elem, weight)
+	}
+	c.expect(ok(), "SET", "obj_1", "one")
+	c.expect(ok(), "SET", "obj_3", "three")
+	list := func(elems ...string) resp.Value {
+		return resp.BulkStrings(elems)
+	}
+	for _, tc := range []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"l"}, list("1", "2", "3", "10")},
+		{[]string{"l", "ASC"}, list("1", "2", "3", "10")},
+		{[]string{"l", "DESC"}, list("10", "3", "2", "1")},
+		{[]string{"l", "desc"}, list("10", "3", "2", "1")},
+		// The last of ASC and DESC wins.
+		{[]string{"l", "DESC", "ASC"}, list("1", "2", "3", "10")},
+		{[]string{"l", "ALPHA"}, list("1", "10", "2", "3")},
+		{[]string{"l", "ALPHA", "DESC"}, list("3", "2", "10", "1")},
+		{[]string{"words", "ALPHA"}, list("apple", "banana", "cherry")},
+
+		// LIMIT applies after sorting; a negative count means the rest.
+		{[]string{"l", "LIMIT", "1", "2"}, list("2", "3")},
+		{[]string{"l", "limit", "1", "-1"}, list("2", "3", "10")},
+		{[]string{"l", "LIMIT", "-5", "2"}, list("1", "2")},
+		{[]string{"l", "LIMIT", "10", "5"}, list()},
+		{[]string{"l", "LIMIT", "0", "0"}, list()},
+		{[]string{"l", "DESC", "LIMIT", "0", "1"}, list("10")},
+		{[]string{"l", "LIMIT", "0", "1", "DESC"}, list("10")},
+
+		// BY weighs each element by the key its pattern names.
+		{[]string{"l", "BY", "weight_*"}, list("10", "3", "2", "1")},
+		{[]string{"l", "BY", "weight_*", "DESC"}, list("1", "2", "3", "10")},
+		{[]string{"l", "BY", "weight_*", "ALPHA"}, list("10", "3", "2", "1")},
+		// Missing weights count as 0, and ties go by the elements.
+		{[]string{"l", "BY", "missing_*"}, list("1", "10", "2", "3")},
+		{[]string{"l", "BY", "weight_*->field"}, list("1", "10", "2", "3")},
+		// A pattern without a * keeps the list's order.
+		{[]string{"l", "BY", "nosort"}, list("3", "1", "2", "10")},
+		{[]string{"l", "BY", "nosort", "DESC"}, list("3", "1", "2", "10")},
+		{[]string{"l", "BY", "nosort", "LIMIT", "1", "2"}, list("1", "2")},
+
+		// GET replaces each element with the keys its patterns name.
+		{[]string{"l", "GET", "obj_*"}, resp.Array{bulk("one"), resp.Null{}, bulk("three"), resp.Null{}}},
+		{[]string{"l", "GET", "#", "GET", "obj_*", "LIMIT", "0", "2"}, resp.Array{bulk("1"), bulk("one"), bulk("2"), resp.Null{}}},
+		{[]string{"l", "BY", "weight_*", "GET", "#", "LIMIT", "0", "2"}, list("10", "3")},
+		{[]string{"l", "GET", "obj_*->field", "LIMIT", "0", "1"}, resp.Array{resp.Null{}}},
+		{[]string{"missing"}, list()},
+	} {
+		c.expect(tc.want, append([]string{"SORT"}, tc.args...)...)
+		c.expect(tc.want, append([]string{"SORT_RO"}, tc.args...)...)
+	}
+}
+
+func TestSortStore(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Integer(3), "RPUSH", "l", "3", "1", "2")
+	c.expect(ok(), "SET", "obj_1", "one")
+
+	c.expect(resp.Integer(3), "SORT", "l", "DESC", "STORE", "dst")
+	c.expect(resp.BulkStrings([]string{"3", "2", "1"}), "LRANGE", "dst", "0", "-1")
+	// Missing GET keys are stored as empty strings.
+	c.expect(resp.Integer(2), "SORT", "l", "GET", "obj_*", "LIMIT", "0", "2", "STORE", "dst")
+	c.expect(resp.BulkStrings([]string{"one", ""}), "LRANGE", "dst", "0", "-1")
+	// STORE replaces whatever the destination held, of any type.
+	c.expect(ok(), "SET", "str", "v")
+	c.expect(resp.Integer(3), "SORT", "l", "STORE", "str")
+	c.expect(resp.BulkStrings([]string{"1", "2", "3"}), "LRANGE", "str", "0", "-1")
+	// An empty result deletes it.
+	c.expect(resp.Integer(0), "SORT", "missing", "STORE", "dst")
+	c.expect(resp.Null{}, "LPOP", "dst")
+}
+
+func TestSortErrors(t *testing.T) {
+	c := dial(t, newTestServer(t))
+	c.expect(resp.Integer(3), "RPUSH", "l", "3", "1", "2")
+	c.expect(resp.Integer(2), "RPUSH", "words", "banana", "1")
+	c.expect(ok(), "SET", "str", "v")
+	c.expect(ok(), "SET", "weight_1", "heavy")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SORT", "l", "BOGUS"}, syntaxError()},
+		{[]string{"SORT", "l", "LIMIT"}, syntaxError()},
+		{[]string{"SORT", "l", "LIMIT", "1"}, syntaxError()},
+		{[]string{"SORT", "l", "LIMIT", "x", "1"}, notAnInteger()},
+		{[]string{"SORT", "l", "LIMIT", "1", "x"}, notAnInteger()},
+		{[]string{"SORT", "l", "BY"}, syntaxError()},
+		{[]string{"SORT", "l", "GET"}, syntaxError()},
+		{[]string{"SORT", "l", "GET", "#", "GET"}, syntaxError()},
+		{[]string{"SORT", "l", "STORE"}, syntaxError()},
+		{[]string{"SORT", "l", "DESC", "BOGUS", "ALPHA"}, syntaxError()},
+		{[]string{"SORT_RO", "l", "STORE", "dst"}, syntaxError()},
+		{[]string{"SORT", "str"}, wrongType()},
+		{[]string{"SORT", "str", "ALPHA"}, wrongType()},
+		{[]string{"SORT", "words"}, errSortScore.Error()},
+		{[]string{"SORT", "l", "BY", "weight_*"}, errSortScore.Error()},
+		{[]string{"SORT", "words", "STORE", "dst"}, errSortScore.Error()},
+	} {
+		c.expect(resp.Error(tc.want), tc.args...)
+	}
+	// A failed SORT ... STORE stores nothing.
+	c.expect(resp.Null{}, "LPOP", "dst")
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"redis/app/resp"
+	"strconv"
+)
+
+func handleAppend(c *client, args []string) {
+	n, err := c.db.Append(args[1], args[2])
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
+
+func handleSetRange(c *client, args []string) {
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	if offset < 0 {
+		c.reply(resp.Error("ERR offset is out of range"))
+		return
+	}
+	n, err := c.db.SetRange(args[1], offset, args[3])
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
+
+func handleGetRange(c *client, args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	end, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		c.reply(resp.Error(notAnInteger()))
+		return
+	}
+	s, err := c.db.GetRange(args[1], start, end)
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.BulkString(s))
+}
+
+func handleStrLen(c *client, args []string) {
+	n, err := c.db.StrLen(args[1])
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+	c.reply(resp.Integer(n))
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"redis/app/config"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a TLS client may take to finish its
+// handshake before it is dropped.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// handshake completes the TLS handshake up front, so that a failure is
+// logged rather than showing up as an anonymous read error.
+func handshake(conn *tls.Conn, log *slog.Logger) bool {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err := conn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		log.Warn("Error accepting a client connection", "addr", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// TLSConfig builds the server's TLS configuration from the tls-* settings.
+func TLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile.Load(), config.TLSKeyFile.Load())
+	if err != nil {
+		return nil, fmt.Errorf("loading tls-cert-file/tls-key-file: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch config.TLSAuthClients.Load() {
+	case "yes":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		pool, err := loadCAFile(config.TLSCACertFile.Load())
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+func loadCAFile(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, errors.New("tls-ca-cert-file must be specified when tls-auth-clients is enabled")
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls-ca-cert-file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("loading tls-ca-cert-file: no certificates found in %s", path)
+	}
+	return pool, nil
+}
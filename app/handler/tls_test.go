@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"redis/app/clock"
+	"redis/app/resp"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a self-signed certificate for 127.0.0.1 and its key
+// to files in a temporary directory, and returns their paths and the
+// certificate.
+func selfSigned(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile, cert
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts a server that also takes TLS clients, configured
+// through the tls-* settings given as name, value pairs.
+func newTLSServer(t *testing.T, settings ...string) *Server {
+	t.Helper()
+	setConfig(t, settings...)
+	s := newUnstartedServer(t, clock.Real)
+	cfg, err := TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ListenTLS("127.0.0.1:0", cfg); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, s)
+	return s
+}
+
+func dialTLS(t *testing.T, s *Server, cfg *tls.Config) (*testClient, error) {
+	conn, err := tls.Dial("tcp", s.TLSAddr().String(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, dec: resp.NewDecoder(conn)}, nil
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, cert := selfSigned(t)
+	s := newTLSServer(t, "tls-cert-file", certFile, "tls-key-file", keyFile, "tls-auth-clients", "no")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	c, err := dialTLS(t, s, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.SimpleString("PONG"), "PING")
+	c.expect(ok(), "SET", "k", "over tls")
+	c.expect(bulk("over tls"), "GET", "k")
+	// The plaintext port serves the same keyspace alongside.
+	dial(t, s).expect(bulk("over tls"), "GET", "k")
+
+	if _, err := dialTLS(t, s, &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Error("a TLS 1.1 client was accepted")
+	}
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	certFile, keyFile, cert := selfSigned(t)
+	s := newTLSServer(t, "tls-cert-file", certFile, "tls-key-file", keyFile,
+		"tls-ca-cert-file", certFile, "tls-auth-clients", "yes")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	// Without a client certificate the handshake fails; TLS 1.3 reports
+	// that on the first read.
+	if c, err := dialTLS(t, s, &tls.Config{RootCAs: roots}); err == nil {
+		if _, err := c.try("PING"); err == nil {
+			t.Error("a client without a certificate was served")
+		}
+	}
+
+	// The failed handshake didn't stop the accept loop.
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dialTLS(t, s, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{pair}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.expect(resp.SimpleString("PONG"), "PING")
+}
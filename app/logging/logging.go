@@ -0,0 +1,111 @@
+// Package logging builds the server's slog loggers. Levels are the four
+// redis.conf names, and records go to stdout or to a log file that can be
+// reopened after rotation.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// LevelVerbose sits between slog's debug and info levels, where
+// redis-server's verbose does.
+const LevelVerbose = slog.LevelDebug + 2
+
+// Levels maps the loglevel setting's names to slog levels.
+var Levels = map[string]slog.Level{
+	"debug":   slog.LevelDebug,
+	"verbose": LevelVerbose,
+	"notice":  slog.LevelInfo,
+	"warning": slog.LevelWarn,
+}
+
+// LevelName is the inverse of Levels, rounding down to the nearest name.
+func LevelName(l slog.Level) string {
+	switch {
+	case l < LevelVerbose:
+		return "debug"
+	case l < slog.LevelInfo:
+		return "verbose"
+	case l < slog.LevelWarn:
+		return "notice"
+	}
+	return "warning"
+}
+
+// New returns a logger writing text records to w at level and above.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				return slog.String(slog.LevelKey, LevelName(a.Value.Any().(slog.Level)))
+			}
+			return a
+		},
+	}))
+}
+
+// Output is where records go: stdout, or a file opened for appending.
+type Output struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open returns the Output for path, "" meaning stdout.
+func Open(path string) (*Output, error) {
+	o := &Output{path: path}
+	if path == "" {
+		return o, nil
+	}
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	o.f = f
+	return o, nil
+}
+
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return os.Stdout.Write(p)
+	}
+	return o.f.Write(p)
+}
+
+// Reopen closes the log file and opens path again, so that records go to
+// a new file once the old one was moved away. It does nothing for stdout.
+func (o *Output) Reopen() error {
+	if o.path == "" {
+		return nil
+	}
+	f, err := openFile(o.path)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	old := o.f
+	o.f = f
+	o.mu.Unlock()
+	return old.Close()
+}
+
+// Close closes the log file; records written after that are lost.
+func (o *Output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return nil
+	}
+	return o.f.Close()
+}
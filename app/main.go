@@ -1,26 +1,53 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net"
 	"os"
-	"redis/app/handler"
+	"os/signal"
+	"redis/app/config"
+	"redis/app/server"
+	"syscall"
 )
 
 func main() {
-	l, err := net.Listen("tcp", "0.0.0.0:6379")
+	for _, name := range config.Names() {
+		flag.Func(name, "see CONFIG GET "+name, func(v string) error {
+			return config.SetInitial(name, v)
+		})
+	}
+	flag.Parse()
+
+	srv, err := server.New(server.Config{})
+	if err == nil {
+		err = srv.Start()
+	}
 	if err != nil {
-		fmt.Println("Failed to bind to port 6379")
+		fmt.Fprintf(os.Stderr, "Fatal error: %v. Exiting.\n", err)
 		os.Exit(1)
 	}
 
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			fmt.Println("Failed to accept connection:", err)
-			continue
+	log := srv.Logger()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGHUP {
+				// Log rotation: the file was moved away, start a new one.
+				if err := srv.ReopenLog(); err != nil {
+					log.Warn("Failed reopening the log file", "err", err)
+				}
+				continue
+			}
+			log.Warn("Received signal, scheduling shutdown...", "signal", sig.String())
+			srv.Close()
+			return
 		}
-		go handler.HandleConnection(conn)
+	}()
+
+	if err := srv.Wait(); err != nil {
+		log.Error("Server stopped", "err", err)
+		os.Exit(1)
 	}
+	log.Info("Ready to exit, bye bye...")
 }
-
@@ -0,0 +1,42 @@
+package rdb
+
+import "io"
+
+// RDB files end with a CRC-64 using the Jones polynomial, reflected, with
+// zero initial value and no final xor. hash/crc64 inverts the CRC before
+// and after each update, so it can't produce this variant.
+const jonesPoly = 0x95ac9329ac4bc9b5
+
+var crcTable = func() *[256]uint64 {
+	var t [256]uint64
+	for i := range t {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ jonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return &t
+}()
+
+func crc64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crcTable[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// crcWriter checksums everything written through it.
+type crcWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	c.crc = crc64(c.crc, p)
+	return c.w.Write(p)
+}
@@ -0,0 +1,74 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDumpPayload means a DUMP payload has a bad checksum or comes from a
+// newer format version.
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// Dump serializes one value, a string or []string, the way DUMP does: its
+// type byte and encoding, then the format version in two bytes and a
+// CRC-64 of everything before it.
+func Dump(value any) ([]byte, error) {
+	valueType, ok := typeOf(value)
+	if !ok {
+		return nil, fmt.Errorf("rdb: can't encode value of type %T", value)
+	}
+	var buf bytes.Buffer
+	e := &encoder{w: bufio.NewWriter(&buf)}
+	e.w.WriteByte(valueType)
+	e.writeValue(value)
+	e.w.Flush()
+	binary.Write(&buf, binary.LittleEndian, uint16(Version))
+	binary.Write(&buf, binary.LittleEndian, crc64(0, buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// SerializedLength returns how many bytes value's encoding takes in a
+// dump, not counting the type byte and the footer DUMP adds, as DEBUG
+// OBJECT reports it.
+func SerializedLength(value any) (int, error) {
+	payload, err := Dump(value)
+	if err != nil {
+		return 0, err
+	}
+	return len(payload) - 1 - 10, nil
+}
+
+// Restore parses a payload made by Dump, or by redis-server's DUMP.
+// ErrDumpPayload reports a payload that fails its version or checksum
+// test; any other error, one whose contents don't decode.
+func Restore(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, ErrDumpPayload
+	}
+	footer := payload[len(payload)-10:]
+	body := payload[:len(payload)-8]
+	if binary.LittleEndian.Uint16(footer) > maxVersion {
+		return nil, ErrDumpPayload
+	}
+	if binary.LittleEndian.Uint64(footer[2:]) != crc64(0, body) {
+		return nil, ErrDumpPayload
+	}
+
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(body[:len(body)-2]))}
+	valueType, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	value, err := d.value(valueType)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return nil, errors.New("rdb: trailing data after the value")
+	}
+	return value, nil
+}
@@ -0,0 +1,72 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDumpRestore(t *testing.T) {
+	for _, v := range []any{
+		"",
+		"hello",
+		"12345",
+		"-9223372036854775808",
+		"line1\r\nline2\x00",
+		strings.Repeat("compressible ", 100),
+		[]string{"a"},
+		[]string{"", "1", strings.Repeat("x", 1000)},
+	} {
+		payload, err := Dump(v)
+		if err != nil {
+			t.Fatalf("Dump(%q): %v", v, err)
+		}
+		got, err := Restore(payload)
+		if err != nil {
+			t.Fatalf("Restore(Dump(%q)): %v", v, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("Restore(Dump(%q)) = %q", v, got)
+		}
+	}
+}
+
+// TestRestoreRedisPayload restores what redis-server 7.0 replies to DUMP
+// for a key holding 10, as given in its documentation.
+func TestRestoreRedisPayload(t *testing.T) {
+	got, err := Restore([]byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "10" {
+		t.Errorf("got %q, want 10", got)
+	}
+}
+
+func TestRestoreRejectsBadFooter(t *testing.T) {
+	valid, err := Dump("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(fn func(p []byte)) []byte {
+		p := append([]byte(nil), valid...)
+		fn(p)
+		return p
+	}
+	for name, payload := range map[string][]byte{
+		"zeroed checksum": corrupt(func(p []byte) { clear(p[len(p)-8:]) }),
+		"wrong checksum":  corrupt(func(p []byte) { p[len(p)-1] ^= 1 }),
+		"changed value":   corrupt(func(p []byte) { p[2] = 'j' }),
+		"newer version": corrupt(func(p []byte) {
+			binary.LittleEndian.PutUint16(p[len(p)-10:], maxVersion+1)
+			binary.LittleEndian.PutUint64(p[len(p)-8:], crc64(0, p[:len(p)-8]))
+		}),
+		"too short": valid[:9],
+	} {
+		if _, err := Restore(payload); !errors.Is(err, ErrDumpPayload) {
+			t.Errorf("%s: got %v, want ErrDumpPayload", name, err)
+		}
+	}
+}
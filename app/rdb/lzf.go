@@ -0,0 +1,108 @@
+package rdb
+
+import "errors"
+
+var errLZF = errors.New("rdb: invalid LZF compressed string")
+
+// lzfDecompress expands an LZF block, which redis-server uses for strings
+// longer than 20 bytes when rdbcompression is on, into exactly n bytes.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// A literal run of ctrl+1 bytes.
+			run := ctrl + 1
+			if i+run > len(in) || len(out)+run > n {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+			continue
+		}
+		// A back reference: copy length+2 bytes from earlier output.
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - ((ctrl&0x1f)<<8 | int(in[i])) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > n {
+			return nil, errLZF
+		}
+		// The ranges may overlap, so copy byte by byte.
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, errLZF
+	}
+	return out, nil
+}
+
+const (
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = 264
+	lzfHashBits   = 14
+)
+
+// lzfCompress compresses in as an LZF block, or returns nil if that
+// wouldn't save at least four bytes, the test redis-server applies before
+// storing a string compressed.
+func lzfCompress(in []byte) []byte {
+	limit := len(in) - 4
+	if limit <= 0 {
+		return nil
+	}
+	out := make([]byte, 0, limit)
+	// table maps a hash of three bytes to one past where they last began.
+	table := make([]int32, 1<<lzfHashBits)
+	literal := 0 // start of the bytes not yet written out
+	flush := func(end int) {
+		for literal < end {
+			run := min(end-literal, lzfMaxLiteral)
+			out = append(out, byte(run-1))
+			out = append(out, in[literal:literal+run]...)
+			literal += run
+		}
+	}
+	for i := 0; i+2 < len(in) && len(out) < limit; {
+		h := (uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])) * 2654435761 >> (32 - lzfHashBits)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lzfMaxOffset || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			i++
+			continue
+		}
+		length := 3
+		for length < lzfMaxRef && i+length < len(in) && in[ref+length] == in[i+length] {
+			length++
+		}
+		flush(i)
+		off, n := i-ref-1, length-2
+		if n < 7 {
+			out = append(out, byte(n<<5|off>>8))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(n-7))
+		}
+		out = append(out, byte(off))
+		i += length
+		literal = i
+	}
+	flush(len(in))
+	if len(out) >= limit {
+		return nil
+	}
+	return out
+}
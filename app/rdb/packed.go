@@ -0,0 +1,185 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var (
+	errZiplist  = errors.New("rdb: invalid ziplist")
+	errListpack = errors.New("rdb: invalid listpack")
+)
+
+// ziplistEntries appends the elements of a ziplist, the packed encoding
+// Redis before 7.0 used for small lists, to list.
+func ziplistEntries(zl []byte, list []string) ([]string, error) {
+	// zlbytes, zltail and zllen make up the header.
+	const headerSize = 10
+	if len(zl) < headerSize+1 || int(binary.LittleEndian.Uint32(zl)) != len(zl) {
+		return nil, errZiplist
+	}
+	p := zl[headerSize:]
+	for {
+		if len(p) == 0 {
+			return nil, errZiplist
+		}
+		if p[0] == 0xff {
+			return list, nil
+		}
+		// Skip the previous entry's length: one byte, or 0xfe and four.
+		if p[0] == 0xfe {
+			if len(p) < 5 {
+				return nil, errZiplist
+			}
+			p = p[5:]
+		} else {
+			p = p[1:]
+		}
+		if len(p) == 0 {
+			return nil, errZiplist
+		}
+		var elem string
+		var n int
+		enc := p[0]
+		switch {
+		case enc>>6 == 0:
+			elem, n = sliceString(p, 1, int(enc&0x3f))
+		case enc>>6 == 1:
+			if len(p) < 2 {
+				return nil, errZiplist
+			}
+			elem, n = sliceString(p, 2, int(enc&0x3f)<<8|int(p[1]))
+		case enc == 0x80:
+			if len(p) < 5 {
+				return nil, errZiplist
+			}
+			elem, n = sliceString(p, 5, int(binary.BigEndian.Uint32(p[1:])))
+		case enc == 0xc0:
+			elem, n = sliceInt(p, 1, 2)
+		case enc == 0xd0:
+			elem, n = sliceInt(p, 1, 4)
+		case enc == 0xe0:
+			elem, n = sliceInt(p, 1, 8)
+		case enc == 0xf0:
+			elem, n = sliceInt(p, 1, 3)
+		case enc == 0xfe:
+			elem, n = sliceInt(p, 1, 1)
+		case enc >= 0xf1 && enc <= 0xfd:
+			// The value 0-12 is stored in the encoding byte itself.
+			elem, n = strconv.Itoa(int(enc&0x0f)-1), 1
+		default:
+			return nil, errZiplist
+		}
+		if n < 0 {
+			return nil, errZiplist
+		}
+		list = append(list, elem)
+		p = p[n:]
+	}
+}
+
+// listpackEntries appends the elements of a listpack, the packed encoding
+// of small lists since Redis 7.0, to list.
+func listpackEntries(lp []byte, list []string) ([]string, error) {
+	// Total bytes and element count make up the header.
+	const headerSize = 6
+	if len(lp) < headerSize+1 || int(binary.LittleEndian.Uint32(lp)) != len(lp) {
+		return nil, errListpack
+	}
+	p := lp[headerSize:]
+	for {
+		if len(p) == 0 {
+			return nil, errListpack
+		}
+		enc := p[0]
+		if enc == 0xff {
+			return list, nil
+		}
+		var elem string
+		var n int
+		switch {
+		case enc>>7 == 0:
+			elem, n = strconv.Itoa(int(enc)), 1
+		case enc>>6 == 2:
+			elem, n = sliceString(p, 1, int(enc&0x3f))
+		case enc>>5 == 6:
+			if len(p) < 2 {
+				return nil, errListpack
+			}
+			v := int(enc&0x1f)<<8 | int(p[1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			elem, n = strconv.Itoa(v), 2
+		case enc>>4 == 0xe:
+			if len(p) < 2 {
+				return nil, errListpack
+			}
+			elem, n = sliceString(p, 2, int(enc&0x0f)<<8|int(p[1]))
+		case enc == 0xf0:
+			if len(p) < 5 {
+				return nil, errListpack
+			}
+			elem, n = sliceString(p, 5, int(binary.LittleEndian.Uint32(p[1:])))
+		case enc == 0xf1:
+			elem, n = sliceInt(p, 1, 2)
+		case enc == 0xf2:
+			elem, n = sliceInt(p, 1, 3)
+		case enc == 0xf3:
+			elem, n = sliceInt(p, 1, 4)
+		case enc == 0xf4:
+			elem, n = sliceInt(p, 1, 8)
+		default:
+			return nil, errListpack
+		}
+		if n < 0 {
+			return nil, errListpack
+		}
+		list = append(list, elem)
+		// Each entry ends with its own length, stored in 7-bit groups.
+		n += backlenSize(n)
+		if n > len(p) {
+			return nil, errListpack
+		}
+		p = p[n:]
+	}
+}
+
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// sliceString returns the length bytes after a header of hdr bytes and the
+// total size consumed, or -1 if p is too short.
+func sliceString(p []byte, hdr, length int) (string, int) {
+	if hdr+length > len(p) {
+		return "", -1
+	}
+	return string(p[hdr : hdr+length]), hdr + length
+}
+
+// sliceInt decodes a little-endian signed integer of size bytes following
+// a header of hdr bytes.
+func sliceInt(p []byte, hdr, size int) (string, int) {
+	if hdr+size > len(p) {
+		return "", -1
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[hdr+i])
+	}
+	// Sign-extend from size bytes.
+	shift := 64 - 8*size
+	return strconv.FormatInt(int64(v<<shift)>>shift, 10), hdr + size
+}
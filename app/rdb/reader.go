@@ -0,0 +1,353 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"redis/app/store"
+	"strconv"
+	"time"
+)
+
+// Value types redis-server writes for lists besides the plain typeList.
+const (
+	typeListZiplist    = 10
+	typeListQuicklist  = 14
+	typeListQuicklist2 = 18
+
+	opFunction2 = 0xf5
+	opModuleAux = 0xf7
+	opIdle      = 0xf8
+	opFreq      = 0xf9
+
+	// Node containers in a typeListQuicklist2 list.
+	quicklistPlain  = 1
+	quicklistPacked = 2
+)
+
+// maxVersion is the newest format version Read understands.
+const maxVersion = 12
+
+// ErrChecksum means the file's contents don't match its trailing checksum.
+var ErrChecksum = errors.New("rdb: wrong checksum")
+
+// Read parses an RDB file. Keys of databases other than 0 are skipped, as
+// are keys whose deadline has already passed. Any malformed or truncated
+// input is an error: nothing is returned from a file that doesn't parse
+// completely.
+func Read(r io.Reader) (*store.Snapshot, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	snap, err := d.read(time.Now())
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("rdb: short read, the file is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (at offset %d)", err, d.offset)
+	}
+	return snap, nil
+}
+
+type decoder struct {
+	r      *bufio.Reader
+	crc    uint64
+	offset int64
+	buf    [8]byte
+}
+
+func (d *decoder) read(now time.Time) (*store.Snapshot, error) {
+	header, err := d.bytes(9)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return nil, errors.New("rdb: wrong signature trying to load DB from file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return nil, fmt.Errorf("rdb: can't handle RDB format version %s", header[5:])
+	}
+
+	snap := &store.Snapshot{}
+	db := uint64(0)
+	var expireAt time.Time
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opEOF:
+			if version >= 5 {
+				return snap, d.checksum()
+			}
+			return snap, nil
+		case opSelectDB:
+			db, err = d.length()
+		case opResizeDB:
+			if _, err = d.length(); err == nil {
+				_, err = d.length()
+			}
+		case opAux:
+			if _, err = d.string(); err == nil {
+				_, err = d.string()
+			}
+		case opFunction2:
+			_, err = d.string()
+		case opModuleAux:
+			err = errors.New("rdb: module data is not supported")
+		case opIdle:
+			_, err = d.length()
+		case opFreq:
+			_, err = d.byte()
+		case opExpireTime:
+			var secs []byte
+			if secs, err = d.bytes(4); err == nil {
+				expireAt = time.Unix(int64(binary.LittleEndian.Uint32(secs)), 0)
+			}
+		case opExpireTimeMS:
+			var ms []byte
+			if ms, err = d.bytes(8); err == nil {
+				expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(ms)))
+			}
+		default:
+			var entry store.SnapshotEntry
+			if entry, err = d.entry(op); err != nil {
+				return nil, err
+			}
+			entry.ExpireAt = expireAt
+			if db == 0 && (expireAt.IsZero() || expireAt.After(now)) {
+				snap.Entries = append(snap.Entries, entry)
+				if !expireAt.IsZero() {
+					snap.Expires++
+				}
+			}
+			// An expire opcode applies to the key right after it only.
+			expireAt = time.Time{}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checksum verifies the trailing CRC. A zero checksum means the writer had
+// rdbchecksum turned off.
+func (d *decoder) checksum() error {
+	want := d.crc
+	sum, err := d.bytes(8)
+	if err != nil {
+		return err
+	}
+	if got := binary.LittleEndian.Uint64(sum); got != 0 && got != want {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func (d *decoder) entry(valueType byte) (store.SnapshotEntry, error) {
+	key, err := d.string()
+	if err != nil {
+		return store.SnapshotEntry{}, err
+	}
+	value, err := d.value(valueType)
+	return store.SnapshotEntry{Key: key, Value: value}, err
+}
+
+// value reads a value of the given type, returning a string or []string.
+func (d *decoder) value(valueType byte) (any, error) {
+	switch valueType {
+	case typeString:
+		return d.string()
+	case typeList:
+		return d.plainList()
+	case typeListZiplist:
+		zl, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return ziplistEntries([]byte(zl), nil)
+	case typeListQuicklist, typeListQuicklist2:
+		return d.quicklist(valueType == typeListQuicklist2)
+	}
+	return nil, fmt.Errorf("rdb: unknown or unsupported value type %d", valueType)
+}
+
+func (d *decoder) plainList() ([]string, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for ; n > 0; n-- {
+		elem, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, elem)
+	}
+	return list, nil
+}
+
+// quicklist reads a list stored as a sequence of ziplist nodes or, since
+// Redis 7, listpack and plain nodes.
+func (d *decoder) quicklist(v2 bool) ([]string, error) {
+	nodes, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	list := []string{}
+	for ; nodes > 0; nodes-- {
+		container := uint64(quicklistPacked)
+		if v2 {
+			if container, err = d.length(); err != nil {
+				return nil, err
+			}
+		}
+		node, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case container == quicklistPlain:
+			list = append(list, node)
+		case container != quicklistPacked:
+			return nil, fmt.Errorf("rdb: unknown quicklist container %d", container)
+		case v2:
+			list, err = listpackEntries([]byte(node), list)
+		default:
+			list, err = ziplistEntries([]byte(node), list)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	var b []byte
+	if n <= len(d.buf) {
+		b = d.buf[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	d.crc = crc64(d.crc, b)
+	d.offset += int64(n)
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// lengthOrEncoding reads a length prefix. If the prefix instead announces
+// a specially encoded string, encoded is true and n is the encoding.
+func (d *decoder) lengthOrEncoding() (n uint64, encoded bool, err error) {
+	first, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit >> 6:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit >> 6:
+		second, err := d.byte()
+		return uint64(first&0x3f)<<8 | uint64(second), false, err
+	case encValue >> 6:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		b, err := d.bytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	case len64Bit:
+		b, err := d.bytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+	return 0, false, fmt.Errorf("rdb: unknown length encoding %#x", first)
+}
+
+func (d *decoder) length() (uint64, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err == nil && encoded {
+		err = errors.New("rdb: expected a length, found an encoded string")
+	}
+	return n, err
+}
+
+// maxStringLen bounds allocations made on the word of a length prefix, so
+// a corrupt file fails instead of exhausting memory.
+const maxStringLen = 512 << 20
+
+func (d *decoder) string() (string, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > maxStringLen {
+			return "", fmt.Errorf("rdb: string length %d out of range", n)
+		}
+		b, err := d.bytes(int(n))
+		return string(b), err
+	}
+	switch n {
+	case encInt8:
+		b, err := d.bytes(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case encInt16:
+		b, err := d.bytes(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		b, err := d.bytes(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case encLZF:
+		return d.lzfString()
+	}
+	return "", fmt.Errorf("rdb: unknown string encoding %d", n)
+}
+
+func (d *decoder) lzfString() (string, error) {
+	clen, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	ulen, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	if clen > maxStringLen || ulen > maxStringLen {
+		return "", errors.New("rdb: LZF string length out of range")
+	}
+	compressed, err := d.bytes(int(clen))
+	if err != nil {
+		return "", err
+	}
+	out, err := lzfDecompress(compressed, int(ulen))
+	return string(out), err
+}
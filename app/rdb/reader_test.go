@@ -0,0 +1,162 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redis/app/store"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata are written by testdata/gen.go.
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// distinct is testdata/gen.go's filler: n pseudo-random letters.
+func distinct(n int) string {
+	b := make([]byte, n)
+	x := uint32(1)
+	for i := range b {
+		x = x*1103515245 + 12345
+		b[i] = 'a' + byte(x>>16)%26
+	}
+	return string(b)
+}
+
+func TestReadFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file    string
+		want    []store.SnapshotEntry
+		expires int
+	}{
+		{"strings.rdb", []store.SnapshotEntry{
+			{Key: "int8", Value: "-12"},
+			{Key: "int16", Value: "1234"},
+			{Key: "int32", Value: "-123456789"},
+			{Key: "raw", Value: "hello world"},
+			{Key: "big", Value: "2147483648"},
+			{Key: "len14", Value: distinct(100)},
+			{Key: "lzf", Value: strings.Repeat("redis ", 50)},
+		}, 0},
+		{"ziplist.rdb", []store.SnapshotEntry{
+			{Key: "list", Value: []string{
+				"hello", "0", "12", "-1", "300", "-100000",
+				"10000000", "5000000000", distinct(300), "after", "",
+			}},
+		}, 0},
+		{"quicklist.rdb", []store.SnapshotEntry{
+			{Key: "list", Value: []string{"a", "b", "1", "2", "c"}},
+		}, 0},
+		{"listpack.rdb", []store.SnapshotEntry{
+			{Key: "list", Value: []string{
+				"0", "127", "-1", "-4096", "4095", "30000",
+				"-8000000", "2000000000", "-9000000000000000000",
+				"short", distinct(100), "", distinct(5000),
+			}},
+			{Key: "small", Value: []string{"x"}},
+		}, 0},
+		// Keys whose deadline has passed are dropped, whichever unit it
+		// was stored in.
+		{"expiry.rdb", []store.SnapshotEntry{
+			{Key: "sec future", Value: "v", ExpireAt: time.Unix(4102444800, 0)},
+			{Key: "ms future", Value: "v", ExpireAt: time.UnixMilli(4102444800123)},
+			{Key: "persistent", Value: "v"},
+		}, 2},
+	} {
+		snap, err := Read(bytes.NewReader(readFixture(t, tc.file)))
+		if err != nil {
+			t.Errorf("%s: %v", tc.file, err)
+			continue
+		}
+		if snap.Expires != tc.expires {
+			t.Errorf("%s: %d keys with deadlines, want %d", tc.file, snap.Expires, tc.expires)
+		}
+		if len(snap.Entries) != len(tc.want) {
+			t.Errorf("%s: read %d keys, want %d", tc.file, len(snap.Entries), len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if e := snap.Entries[i]; e.Key != want.Key || !reflect.DeepEqual(e.Value, want.Value) || !e.ExpireAt.Equal(want.ExpireAt) {
+				t.Errorf("%s: read %q = %.40q expiring %v, want %q = %.40q expiring %v", tc.file, e.Key, e.Value, e.ExpireAt, want.Key, want.Value, want.ExpireAt)
+			}
+		}
+	}
+}
+
+func TestReadBadChecksum(t *testing.T) {
+	if _, err := Read(bytes.NewReader(readFixture(t, "bad_checksum.rdb"))); !errors.Is(err, ErrChecksum) {
+		t.Errorf("got %v, want ErrChecksum", err)
+	}
+	// A zero checksum is what redis-server writes with rdbchecksum no.
+	data := readFixture(t, "strings.rdb")
+	clear(data[len(data)-8:])
+	if _, err := Read(bytes.NewReader(data)); err != nil {
+		t.Errorf("with no checksum: %v", err)
+	}
+}
+
+// TestReadTruncated cuts every fixture short at each byte. Every cut must
+// be reported as a truncated file, without a panic and without a partial
+// snapshot.
+func TestReadTruncated(t *testing.T) {
+	for _, name := range []string{"strings.rdb", "ziplist.rdb", "quicklist.rdb", "listpack.rdb", "expiry.rdb"} {
+		data := readFixture(t, name)
+		for n := 0; n < len(data); n++ {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s cut to %d bytes: panic: %v", name, n, r)
+					}
+				}()
+				snap, err := Read(bytes.NewReader(data[:n]))
+				if snap != nil || !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("%s cut to %d bytes: got %v, %v, want a truncation error", name, n, snap, err)
+				}
+			}()
+		}
+	}
+}
+
+// TestReadCorrupt flips each byte of the fixtures in turn. Whatever Read
+// makes of the result, it mustn't panic or return a snapshot without an
+// error, since every change breaks the checksum if nothing else.
+func TestReadCorrupt(t *testing.T) {
+	for _, name := range []string{"strings.rdb", "ziplist.rdb", "quicklist.rdb", "listpack.rdb", "expiry.rdb"} {
+		data := readFixture(t, name)
+		for i := range len(data) - 8 {
+			corrupt := bytes.Clone(data)
+			corrupt[i] ^= 0xff
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s with byte %d flipped: panic: %v", name, i, r)
+					}
+				}()
+				if snap, err := Read(bytes.NewReader(corrupt)); err == nil {
+					t.Fatalf("%s with byte %d flipped: read %d keys without an error", name, i, len(snap.Entries))
+				}
+			}()
+		}
+	}
+}
+
+func TestReadRejectsVersion(t *testing.T) {
+	for _, header := range []string{"REDIS0000", fmt.Sprintf("REDIS%04d", maxVersion+1), "REDISxxxx", "RUBIS0009"} {
+		if _, err := Read(strings.NewReader(header)); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v, want a header error", header, err)
+		}
+	}
+}
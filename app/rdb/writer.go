@@ -0,0 +1,178 @@
+// Package rdb reads and writes the keyspace in redis-server's RDB snapshot
+// format, so dumps can be exchanged with real Redis.
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"redis/app/store"
+	"strconv"
+)
+
+// Version is the RDB format version written, the one Redis 7.2 uses.
+const Version = 11
+
+const (
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMS = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+
+	typeString = 0
+	typeList   = 1
+
+	// Length prefixes: the top two bits of the first byte say how the
+	// length is stored.
+	len6Bit  = 0x00
+	len14Bit = 0x40
+	len32Bit = 0x80
+	len64Bit = 0x81
+	encValue = 0xc0
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Aux is an auxiliary field stored in the file header, such as redis-ver.
+type Aux struct {
+	Key, Value string
+}
+
+// Write serializes snap as an RDB file holding database 0.
+func Write(w io.Writer, snap *store.Snapshot, aux []Aux) error {
+	crc := &crcWriter{w: w}
+	e := &encoder{w: bufio.NewWriter(crc)}
+	e.w.WriteString(fmt.Sprintf("REDIS%04d", Version))
+	for _, a := range aux {
+		e.w.WriteByte(opAux)
+		e.writeString(a.Key)
+		e.writeString(a.Value)
+	}
+	e.w.WriteByte(opSelectDB)
+	e.writeLength(0)
+	e.w.WriteByte(opResizeDB)
+	e.writeLength(uint64(len(snap.Entries)))
+	e.writeLength(uint64(snap.Expires))
+	for _, entry := range snap.Entries {
+		if err := e.writeEntry(entry); err != nil {
+			return err
+		}
+	}
+	e.w.WriteByte(opEOF)
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc.crc)
+	_, err := w.Write(sum[:])
+	return err
+}
+
+type encoder struct {
+	w *bufio.Writer
+}
+
+func (e *encoder) writeEntry(entry store.SnapshotEntry) error {
+	if !entry.ExpireAt.IsZero() {
+		e.w.WriteByte(opExpireTimeMS)
+		var ms [8]byte
+		binary.LittleEndian.PutUint64(ms[:], uint64(entry.ExpireAt.UnixMilli()))
+		e.w.Write(ms[:])
+	}
+	valueType, ok := typeOf(entry.Value)
+	if !ok {
+		return fmt.Errorf("rdb: can't encode value of key %q (%T)", entry.Key, entry.Value)
+	}
+	e.w.WriteByte(valueType)
+	e.writeString(entry.Key)
+	e.writeValue(entry.Value)
+	return nil
+}
+
+// typeOf returns the value type byte written for v.
+func typeOf(v any) (byte, bool) {
+	switch v.(type) {
+	case string:
+		return typeString, true
+	case []string:
+		return typeList, true
+	}
+	return 0, false
+}
+
+func (e *encoder) writeValue(v any) {
+	switch v := v.(type) {
+	case string:
+		e.writeString(v)
+	case []string:
+		e.writeLength(uint64(len(v)))
+		for _, elem := range v {
+			e.writeString(elem)
+		}
+	}
+}
+
+func (e *encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.w.WriteByte(len6Bit | byte(n))
+	case n < 1<<14:
+		e.w.WriteByte(len14Bit | byte(n>>8))
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		e.w.WriteByte(len32Bit)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		e.w.Write(b[:])
+	default:
+		e.w.WriteByte(len64Bit)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		e.w.Write(b[:])
+	}
+}
+
+// writeString stores s as an integer when it is the canonical form of one
+// that fits 32 bits, as redis-server does. Longer strings are stored LZF
+// compressed when that saves space, as with redis-server's default
+// rdbcompression yes, and as raw bytes otherwise.
+func (e *encoder) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			switch {
+			case n >= math.MinInt8 && n <= math.MaxInt8:
+				e.w.WriteByte(encValue | encInt8)
+				e.w.WriteByte(byte(int8(n)))
+			case n >= math.MinInt16 && n <= math.MaxInt16:
+				e.w.WriteByte(encValue | encInt16)
+				var b [2]byte
+				binary.LittleEndian.PutUint16(b[:], uint16(int16(n)))
+				e.w.Write(b[:])
+			default:
+				e.w.WriteByte(encValue | encInt32)
+				var b [4]byte
+				binary.LittleEndian.PutUint32(b[:], uint32(int32(n)))
+				e.w.Write(b[:])
+			}
+			return
+		}
+	}
+	if len(s) > 20 {
+		if compressed := lzfCompress([]byte(s)); compressed != nil {
+			e.w.WriteByte(encValue | encLZF)
+			e.writeLength(uint64(len(compressed)))
+			e.writeLength(uint64(len(s)))
+			e.w.Write(compressed)
+			return
+		}
+	}
+	e.writeLength(uint64(len(s)))
+	e.w.WriteString(s)
+}
@@ -0,0 +1,202 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"redis/app/store"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripSnapshot holds every type Write supports, strings in each of
+// their encodings, and keys with and without deadlines.
+func roundTripSnapshot() *store.Snapshot {
+	random := make([]byte, 300)
+	rand.New(rand.NewSource(1)).Read(random)
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	entries := []store.SnapshotEntry{
+		{Key: "empty", Value: ""},
+		{Key: "int8", Value: "-128"},
+		{Key: "int16", Value: "32767"},
+		{Key: "int32", Value: "-2147483648"},
+		{Key: "int64", Value: "2147483648"},
+		{Key: "padded", Value: "007"},
+		{Key: "plus", Value: "+1"},
+		{Key: "binary", Value: "line1\r\nline2\x00"},
+		{Key: "short", Value: strings.Repeat("x", 20)},
+		{Key: "lzf", Value: strings.Repeat("compressible ", 100)},
+		{Key: "incompressible", Value: string(random)},
+		{Key: "len14", Value: string(random[:100])},
+		{Key: "len32", Value: strings.Repeat(string(random), 100)},
+		{Key: "list", Value: []string{"a", "1", "", strings.Repeat("y", 100)}},
+		{Key: "long list", Value: strings.Split(strings.Repeat("e,", 1000), ",")},
+		{Key: "expiring", Value: "v", ExpireAt: deadline},
+		{Key: "expiring list", Value: []string{"v"}, ExpireAt: deadline},
+		{Key: strings.Repeat("k", 100), Value: "long key"},
+	}
+	return &store.Snapshot{Entries: entries, Expires: 2}
+}
+
+func TestWriteRead(t *testing.T) {
+	snap := roundTripSnapshot()
+	var buf bytes.Buffer
+	if err := Write(&buf, snap, []Aux{{"redis-ver", "7.2.0"}, {"ctime", "1700000000"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Expires != snap.Expires || len(got.Entries) != len(snap.Entries) {
+		t.Fatalf("read %d entries, %d expiring, want %d, %d", len(got.Entries), got.Expires, len(snap.Entries), snap.Expires)
+	}
+	for i, want := range snap.Entries {
+		if e := got.Entries[i]; e.Key != want.Key || !reflect.DeepEqual(e.Value, want.Value) || !e.ExpireAt.Equal(want.ExpireAt) {
+			t.Errorf("entry %d: read %q = %.40q expiring %v, want %q = %.40q expiring %v", i, e.Key, e.Value, e.ExpireAt, want.Key, want.Value, want.ExpireAt)
+		}
+	}
+}
+
+// TestWriteDropsExpired checks a key whose deadline passes between writing
+// and reading is not loaded.
+func TestWriteDropsExpired(t *testing.T) {
+	snap := &store.Snapshot{Entries: []store.SnapshotEntry{
+		{Key: "gone", Value: "v", ExpireAt: time.Now().Add(-time.Millisecond)},
+		{Key: "kept", Value: "v"},
+	}, Expires: 1}
+	var buf bytes.Buffer
+	if err := Write(&buf, snap, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 1 || got.Entries[0].Key != "kept" || got.Expires != 0 {
+		t.Errorf("read %+v", got)
+	}
+}
+
+// base64Digits is 64 bytes with nothing for LZF to compress.
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// TestWriteEncodings checks the bytes written for each string encoding
+// against the ones redis-server writes.
+func TestWriteEncodings(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"", "\x00"},
+		{"abc", "\x03abc"},
+		{"0", "\xc0\x00"},
+		{"-1", "\xc0\xff"},
+		{"127", "\xc0\x7f"},
+		{"128", "\xc1\x80\x00"},
+		{"-32768", "\xc1\x00\x80"},
+		{"32768", "\xc2\x00\x80\x00\x00"},
+		{"2147483647", "\xc2\xff\xff\xff\x7f"},
+		{"2147483648", "\x0a2147483648"},
+		{"-0", "\x02-0"},
+		{"01", "\x0201"},
+		{base64Digits, "\x40\x40" + base64Digits},
+		// A literal run of one byte, then a reference of 63 bytes back one.
+		{strings.Repeat("a", 64), "\xc3\x05\x40\x40\x00a\xe0\x36\x00"},
+	} {
+		var buf bytes.Buffer
+		e := &encoder{w: bufio.NewWriter(&buf)}
+		e.writeString(tc.in)
+		e.w.Flush()
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%.20q written as %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLZFRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		// Draw from a small alphabet so there is something to compress.
+		in := make([]byte, 21+r.Intn(20000))
+		alphabet := 1 + r.Intn(8)
+		for j := range in {
+			in[j] = byte('a' + r.Intn(alphabet))
+		}
+		compressed := lzfCompress(in)
+		if compressed == nil {
+			if alphabet == 1 {
+				t.Fatalf("%d bytes of one letter didn't compress", len(in))
+			}
+			continue
+		}
+		if len(compressed) > len(in)-4 {
+			t.Fatalf("%d bytes compressed to %d", len(in), len(compressed))
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Fatalf("%d bytes didn't survive compression: %v", len(in), err)
+		}
+	}
+}
+
+// TestWriteChecksum checks the file ends in the CRC-64 of everything
+// before it, and that Read notices when the two don't match.
+func TestWriteChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, roundTripSnapshot(), nil); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	body, footer := file[:len(file)-8], file[len(file)-8:]
+	if body[len(body)-1] != opEOF {
+		t.Fatalf("the checksum follows %#x, want EOF", body[len(body)-1])
+	}
+	if got, want := binary.LittleEndian.Uint64(footer), crc64(0, body); got != want {
+		t.Fatalf("checksum %#x, want %#x", got, want)
+	}
+	// The check value of CRC-64/Jones as redis-server's crc64.c tests it.
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("crc64(123456789) = %#x", got)
+	}
+
+	corrupt := bytes.Clone(file)
+	corrupt[len(body)/2] ^= 1
+	if _, err := Read(bytes.NewReader(corrupt)); err == nil {
+		t.Error("read a file with a flipped bit")
+	}
+	corrupt = bytes.Clone(file)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := Read(bytes.NewReader(corrupt)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("wrong footer: got %v, want ErrChecksum", err)
+	}
+}
+
+// TestRedisCheckRDB cross-checks a written file with redis-server's own
+// checker, when it is installed.
+func TestRedisCheckRDB(t *testing.T) {
+	checker, err := exec.LookPath("redis-check-rdb")
+	if err != nil {
+		t.Skip("redis-check-rdb is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(f, roundTripSnapshot(), []Aux{{"redis-ver", "7.2.0"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(checker, path).CombinedOutput(); err != nil {
+		t.Fatalf("redis-check-rdb: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,298 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+var ErrProtocol = errors.New("resp: protocol error")
+
+const (
+	// maxDepth bounds how deeply aggregates may nest, so hostile input
+	// can't make Decode recurse without limit.
+	maxDepth = 64
+	// maxBulkLen bounds one string, like redis-server's
+	// proto-max-bulk-len.
+	maxBulkLen = 512 << 20
+	// preallocLimit caps the elements allocated up front for an
+	// aggregate; bigger ones grow as their elements actually arrive.
+	preallocLimit = 1024
+	// bulkChunk is how much of a bulk string bulk makes room for at a
+	// time.
+	bulkChunk = 64 * 1024
+)
+
+// Decoder reads RESP2 and RESP3 values, as sent by a server. Simple
+// strings and errors come back as SimpleString and Error, as do RESP3 blob
+// errors; null bulk strings, null arrays and the RESP3 null all decode to
+// Null or NullArray. Attributes are read and dropped, and the value they
+// annotate is returned in their place.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Decoder{r: br}
+	}
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Reader exposes the underlying reader, for callers that switch to reading
+// raw payloads after a reply, as replication does after FULLRESYNC.
+func (d *Decoder) Reader() *bufio.Reader {
+	return d.r
+}
+
+func (d *Decoder) Decode() (Value, error) {
+	return d.decode(0)
+}
+
+func (d *Decoder) decode(depth int) (Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", ErrProtocol)
+	}
+	line, err := d.line()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+	body := string(line[1:])
+	switch line[0] {
+	case '+':
+		return SimpleString(body), nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer %q", ErrProtocol, body)
+		}
+		return Integer(n), nil
+	case ',':
+		f, err := parseDouble(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid double %q", ErrProtocol, body)
+		}
+		return Double(f), nil
+	case '#':
+		switch body {
+		case "t":
+			return Boolean(true), nil
+		case "f":
+			return Boolean(false), nil
+		}
+		return nil, fmt.Errorf("%w: invalid boolean %q", ErrProtocol, body)
+	case '(':
+		if !isBigNumber(body) {
+			return nil, fmt.Errorf("%w: invalid big number %q", ErrProtocol, body)
+		}
+		return BigNumber(body), nil
+	case '_':
+		if body != "" {
+			return nil, fmt.Errorf("%w: invalid null %q", ErrProtocol, body)
+		}
+		return Null{}, nil
+	case '$':
+		b, err := d.bulk(body)
+		switch {
+		case err != nil:
+			return nil, err
+		case b == nil:
+			return Null{}, nil
+		}
+		return BulkString(b), nil
+	case '!':
+		b, err := d.bulk(body)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, fmt.Errorf("%w: null blob error", ErrProtocol)
+		}
+		return Error(b), nil
+	case '=':
+		b, err := d.bulk(body)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 4 || b[3] != ':' {
+			return nil, fmt.Errorf("%w: invalid verbatim string", ErrProtocol)
+		}
+		return Verbatim{Format: string(b[:3]), Text: string(b[4:])}, nil
+	case '*':
+		n, err := d.length(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return NullArray{}, nil
+		}
+		items, err := d.items(n, depth)
+		if err != nil {
+			return nil, err
+		}
+		return Array(items), nil
+	case '~':
+		n, err := d.aggregateLength(body)
+		if err != nil {
+			return nil, err
+		}
+		items, err := d.items(n, depth)
+		if err != nil {
+			return nil, err
+		}
+		return Set(items), nil
+	case '>':
+		n, err := d.aggregateLength(body)
+		if err != nil {
+			return nil, err
+		}
+		items, err := d.items(n, depth)
+		if err != nil {
+			return nil, err
+		}
+		return Push(items), nil
+	case '%':
+		n, err := d.aggregateLength(body)
+		if err != nil {
+			return nil, err
+		}
+		return d.pairs(n, depth)
+	case '|':
+		n, err := d.aggregateLength(body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := d.pairs(n, depth); err != nil {
+			return nil, err
+		}
+		return d.decode(depth)
+	}
+	return nil, fmt.Errorf("%w: unexpected type byte %q", ErrProtocol, line[0])
+}
+
+// bulk reads the payload of a length-prefixed string given its header,
+// returning nil for a length of -1.
+func (d *Decoder) bulk(header string) ([]byte, error) {
+	n, err := d.length(header)
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	if n > maxBulkLen {
+		return nil, fmt.Errorf("%w: bulk string too long", ErrProtocol)
+	}
+	// Grow the buffer as the payload arrives rather than to n up front, so
+	// a header announcing a huge string costs nothing until it is sent.
+	buf := make([]byte, 0, min(n+2, bulkChunk))
+	for len(buf) < n+2 {
+		chunk := min(n+2-len(buf), bulkChunk)
+		buf = slices.Grow(buf, chunk)
+		read, err := io.ReadFull(d.r, buf[len(buf):len(buf)+chunk])
+		buf = buf[:len(buf)+read]
+		if err != nil {
+			return nil, err
+		}
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return buf[:n], nil
+}
+
+// items reads the n elements of an aggregate at depth.
+func (d *Decoder) items(n, depth int) ([]Value, error) {
+	items := make([]Value, 0, min(n, preallocLimit))
+	for i := 0; i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// pairs reads the n key/value pairs of a map or attribute at depth.
+func (d *Decoder) pairs(n, depth int) (Map, error) {
+	m := make(Map, 0, min(n, preallocLimit))
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, KeyValue{Key: k, Value: v})
+	}
+	return m, nil
+}
+
+// line reads up to CRLF and returns what came before it.
+func (d *Decoder) line() ([]byte, error) {
+	line, err := d.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: line not terminated by CRLF", ErrProtocol)
+	}
+	return line[:len(line)-2], nil
+}
+
+// aggregateLength is length for the RESP3 aggregates, which have no null
+// form.
+func (d *Decoder) aggregateLength(s string) (int, error) {
+	n, err := d.length(s)
+	if err == nil && n < 0 {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, s)
+	}
+	return n, err
+}
+
+func (d *Decoder) length(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, s)
+	}
+	return n, nil
+}
+
+func parseDouble(s string) (float64, error) {
+	switch s {
+	case "inf":
+		s = "+Inf"
+	case "-inf":
+		s = "-Inf"
+	case "nan":
+		s = "NaN"
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// isBigNumber reports whether s is an optionally signed run of digits.
+func isBigNumber(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRejects(t *testing.T) {
+	for _, input := range []string{
+		"*-2\r\n",
+		"$-2\r\n",
+		"~-1\r\n",
+		"%-1\r\n",
+		"$3\r\nabcd\r\n",
+		"#x\r\n",
+		"(12a\r\n",
+		"_x\r\n",
+		"=3\r\ntxt\r\n",
+		"!-1\r\n",
+		"?\r\n",
+		"+OK\n",
+		strings.Repeat("*1\r\n", maxDepth+2) + ":1\r\n",
+	} {
+		if v, err := NewDecoder(strings.NewReader(input)).Decode(); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q decoded to %#v, %v, want a protocol error", input, v, err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	full := "*2\r\n%1\r\n+k\r\n$5\r\nhello\r\n~1\r\n:1\r\n"
+	for i := 0; i < len(full); i++ {
+		_, err := NewDecoder(strings.NewReader(full[:i])).Decode()
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q: got %v, want an EOF or protocol error", full[:i], err)
+		}
+	}
+}
+
+// FuzzDecode feeds the decoder arbitrary bytes. It must return a value or
+// an error without panicking or running away with memory, and whatever
+// it returns must encode back to a stream that decodes to the same.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"+OK\r\n",
+		"-ERR bad\r\n",
+		":-42\r\n",
+		"$5\r\nhello\r\n",
+		"$0\r\n\r\n",
+		"$-1\r\n",
+		"*-1\r\n",
+		"_\r\n",
+		",1.5\r\n",
+		",-inf\r\n",
+		"#t\r\n",
+		"(-123456789012345678901234567890\r\n",
+		"=7\r\ntxt:abc\r\n",
+		"!5\r\nERR x\r\n",
+		// Nested aggregates.
+		"*2\r\n*1\r\n:1\r\n%1\r\n+k\r\n~2\r\n#f\r\n_\r\n",
+		">2\r\n$7\r\nmessage\r\n*0\r\n",
+		"|1\r\n+ttl\r\n:3\r\n$1\r\nv\r\n",
+		strings.Repeat("*1\r\n", maxDepth) + ":1\r\n",
+		// Negative and huge lengths.
+		"*-2\r\n",
+		"$-3\r\n",
+		"%-1\r\n",
+		"*99999999999\r\n",
+		"$2147483647\r\nab\r\n",
+		// Truncated frames.
+		"*3\r\n$1\r\na\r\n",
+		"$10\r\nabc",
+		"%2\r\n+k\r\n",
+		"+OK",
+		":12\r",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			return
+		}
+		wire := encodeProto(v, 3)
+		again, err := NewDecoder(bytes.NewReader(wire)).Decode()
+		if err != nil {
+			t.Fatalf("%q decoded to %#v, which encodes to %q that doesn't decode: %v", data, v, wire, err)
+		}
+		if !bytes.Equal(encodeProto(again, 3), wire) {
+			t.Fatalf("%q decoded to %#v, which doesn't survive a round trip", data, v)
+		}
+	})
+}
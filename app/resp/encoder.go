@@ -0,0 +1,180 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var newlinesToSpaces = strings.NewReplacer("\r", " ", "\n", " ")
+
+// Encoder buffers RESP values for one connection. bufio.Writer's errors
+// are sticky, so callers encode without checking and learn about a broken
+// connection from Flush.
+type Encoder struct {
+	bw  *bufio.Writer
+	num []byte
+	// Proto is the protocol version replies are encoded for, 2 or 3.
+	Proto int
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{bw: bufio.NewWriterSize(w, 16*1024), Proto: 2}
+}
+
+func (e *Encoder) Flush() error {
+	return e.bw.Flush()
+}
+
+// Buffered reports how many bytes are waiting for Flush.
+func (e *Encoder) Buffered() int {
+	return e.bw.Buffered()
+}
+
+func (e *Encoder) Encode(v Value) {
+	switch v := v.(type) {
+	case SimpleString:
+		e.line('+', string(v))
+	case Error:
+		// A newline would end the error early, so like redis-server it
+		// becomes a space. A blob error read by the Decoder can hold one.
+		e.line('-', newlinesToSpaces.Replace(string(v)))
+	case Integer:
+		e.prefixed(':', int64(v))
+	case BulkString:
+		e.prefixed('$', int64(len(v)))
+		e.bw.Write(v)
+		e.bw.WriteString("\r\n")
+	case Null:
+		if e.Proto >= 3 {
+			e.bw.WriteString("_\r\n")
+		} else {
+			e.bw.WriteString("$-1\r\n")
+		}
+	case NullArray:
+		if e.Proto >= 3 {
+			e.bw.WriteString("_\r\n")
+		} else {
+			e.bw.WriteString("*-1\r\n")
+		}
+	case Array:
+		e.prefixed('*', int64(len(v)))
+		for _, item := range v {
+			e.Encode(item)
+		}
+	case StringArray:
+		e.prefixed('*', int64(len(v)))
+		for _, s := range v {
+			e.prefixed('$', int64(len(s)))
+			e.bw.WriteString(s)
+			e.bw.WriteString("\r\n")
+		}
+	case Map:
+		if e.Proto >= 3 {
+			e.prefixed('%', int64(len(v)))
+		} else {
+			e.prefixed('*', int64(2*len(v)))
+		}
+		for _, kv := range v {
+			e.Encode(kv.Key)
+			e.Encode(kv.Value)
+		}
+	case Double:
+		s := formatDouble(float64(v))
+		if e.Proto >= 3 {
+			e.line(',', s)
+		} else {
+			e.Encode(BulkString(s))
+		}
+	case Boolean:
+		switch {
+		case e.Proto < 3 && bool(v):
+			e.bw.WriteString(":1\r\n")
+		case e.Proto < 3:
+			e.bw.WriteString(":0\r\n")
+		case bool(v):
+			e.bw.WriteString("#t\r\n")
+		default:
+			e.bw.WriteString("#f\r\n")
+		}
+	case BigNumber:
+		if e.Proto >= 3 {
+			e.line('(', string(v))
+		} else {
+			e.Encode(BulkString(v))
+		}
+	case Verbatim:
+		if e.Proto >= 3 {
+			e.prefixed('=', int64(len(v.Format)+1+len(v.Text)))
+			e.bw.WriteString(v.Format)
+			e.bw.WriteByte(':')
+			e.bw.WriteString(v.Text)
+			e.bw.WriteString("\r\n")
+		} else {
+			e.Encode(BulkString(v.Text))
+		}
+	case Set:
+		e.aggregate('~', v)
+	case Push:
+		e.aggregate('>', v)
+	}
+}
+
+// aggregate writes a RESP3 aggregate of items with the given type byte,
+// or a plain array for RESP2.
+func (e *Encoder) aggregate(prefix byte, items []Value) {
+	if e.Proto < 3 {
+		prefix = '*'
+	}
+	e.prefixed(prefix, int64(len(items)))
+	for _, item := range items {
+		e.Encode(item)
+	}
+}
+
+func (e *Encoder) line(prefix byte, s string) {
+	e.bw.WriteByte(prefix)
+	e.bw.WriteString(s)
+	e.bw.WriteString("\r\n")
+}
+
+// prefixed writes a type byte, a decimal number and CRLF without going
+// through fmt.
+func (e *Encoder) prefixed(prefix byte, n int64) {
+	e.bw.WriteByte(prefix)
+	e.num = strconv.AppendInt(e.num[:0], n, 10)
+	e.bw.Write(e.num)
+	e.bw.WriteString("\r\n")
+}
+
+// formatDouble spells infinities the way RESP3 does; RESP2 clients see the
+// same text.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+// AppendCommand appends args to dst as a RESP array of bulk strings, the
+// form commands take in the AOF and on the replication stream.
+func AppendCommand(dst []byte, args []string) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(args)), 10)
+	dst = append(dst, "\r\n"...)
+	for _, arg := range args {
+		dst = append(dst, '$')
+		dst = strconv.AppendInt(dst, int64(len(arg)), 10)
+		dst = append(dst, "\r\n"...)
+		dst = append(dst, arg...)
+		dst = append(dst, "\r\n"...)
+	}
+	return dst
+}
@@ -0,0 +1,176 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func encodeProto(v Value, proto int) []byte {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Proto = proto
+	e.Encode(v)
+	e.Flush()
+	return buf.Bytes()
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		v            Value
+		resp2, resp3 string
+	}{
+		{SimpleString("OK"), "+OK\r\n", "+OK\r\n"},
+		{Error("ERR bad"), "-ERR bad\r\n", "-ERR bad\r\n"},
+		{Integer(-42), ":-42\r\n", ":-42\r\n"},
+		{BulkString(""), "$0\r\n\r\n", "$0\r\n\r\n"},
+		{BulkString("a\r\nb"), "$4\r\na\r\nb\r\n", "$4\r\na\r\nb\r\n"},
+		{Null{}, "$-1\r\n", "_\r\n"},
+		{NullArray{}, "*-1\r\n", "_\r\n"},
+		{Array{}, "*0\r\n", "*0\r\n"},
+		{Array{Integer(1), Array{BulkString("x")}}, "*2\r\n:1\r\n*1\r\n$1\r\nx\r\n", "*2\r\n:1\r\n*1\r\n$1\r\nx\r\n"},
+		{StringArray{"a", ""}, "*2\r\n$1\r\na\r\n$0\r\n\r\n", "*2\r\n$1\r\na\r\n$0\r\n\r\n"},
+		{Map{{SimpleString("k"), Integer(1)}}, "*2\r\n+k\r\n:1\r\n", "%1\r\n+k\r\n:1\r\n"},
+		{Double(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{Double(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{Boolean(true), ":1\r\n", "#t\r\n"},
+		{Boolean(false), ":0\r\n", "#f\r\n"},
+		{BigNumber("-123"), "$4\r\n-123\r\n", "(-123\r\n"},
+		{Verbatim{"txt", "hi"}, "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{Set{Integer(1)}, "*1\r\n:1\r\n", "~1\r\n:1\r\n"},
+		{Push{BulkString("m")}, "*1\r\n$1\r\nm\r\n", ">1\r\n$1\r\nm\r\n"},
+	} {
+		if got := encodeProto(tc.v, 2); string(got) != tc.resp2 {
+			t.Errorf("RESP2 %#v = %q, want %q", tc.v, got, tc.resp2)
+		}
+		if got := encodeProto(tc.v, 3); string(got) != tc.resp3 {
+			t.Errorf("RESP3 %#v = %q, want %q", tc.v, got, tc.resp3)
+		}
+	}
+}
+
+// randomValue builds a value of any type the decoder can return, nested
+// at most depth deep.
+func randomValue(r *rand.Rand, depth int) Value {
+	kinds := 13
+	if depth == 0 {
+		kinds = 9 // leave out the aggregates
+	}
+	switch r.Intn(kinds) {
+	case 0:
+		return SimpleString(randomLine(r))
+	case 1:
+		return Error("ERR " + randomLine(r))
+	case 2:
+		return Integer(r.Int63() - r.Int63())
+	case 3:
+		return BulkString(randomBytes(r))
+	case 4:
+		return Null{}
+	case 5:
+		return Double(r.NormFloat64() * math.Pow(10, float64(r.Intn(40)-20)))
+	case 6:
+		return Boolean(r.Intn(2) == 0)
+	case 7:
+		return BigNumber("-" + strconv.FormatUint(r.Uint64(), 10) + "0000000000")
+	case 8:
+		return Verbatim{Format: "txt", Text: string(randomBytes(r))}
+	case 9:
+		return Array(randomValues(r, depth))
+	case 10:
+		return Set(randomValues(r, depth))
+	case 11:
+		return Push(randomValues(r, depth))
+	default:
+		m := make(Map, r.Intn(4))
+		for i := range m {
+			m[i] = KeyValue{randomValue(r, depth-1), randomValue(r, depth-1)}
+		}
+		return m
+	}
+}
+
+func randomValues(r *rand.Rand, depth int) []Value {
+	items := make([]Value, r.Intn(5))
+	for i := range items {
+		items[i] = randomValue(r, depth-1)
+	}
+	return items
+}
+
+// randomBytes returns arbitrary bytes, CR and LF included.
+func randomBytes(r *rand.Rand) []byte {
+	b := make([]byte, r.Intn(20))
+	r.Read(b)
+	return b
+}
+
+// randomLine returns text that may go in a simple string or error.
+func randomLine(r *rand.Rand) string {
+	b := randomBytes(r)
+	for i := range b {
+		b[i] = ' ' + b[i]%95
+	}
+	return string(b)
+}
+
+// TestRoundTrip encodes random nested values for RESP3, where every type
+// has its own form, and decodes them back.
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var stream []byte
+	var want []Value
+	for i := 0; i < 2000; i++ {
+		v := randomValue(r, 4)
+		wire := encodeProto(v, 3)
+		got, err := NewDecoder(bytes.NewReader(wire)).Decode()
+		if err != nil {
+			t.Fatalf("decoding %q: %v", wire, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("%q decoded to %#v, want %#v", wire, got, v)
+		}
+		stream = append(stream, wire...)
+		want = append(want, v)
+	}
+	// Back to back on one stream, no value reads into the next.
+	d := NewDecoder(bytes.NewReader(stream))
+	for i, v := range want {
+		got, err := d.Decode()
+		if err != nil || !reflect.DeepEqual(got, v) {
+			t.Fatalf("value %d of the stream: got %#v, %v", i, got, err)
+		}
+	}
+}
+
+// TestRoundTripRESP2 checks the RESP2 forms of the RESP3-only types
+// decode to what a RESP2 client expects.
+func TestRoundTripRESP2(t *testing.T) {
+	for _, tc := range []struct {
+		v, want Value
+	}{
+		{NullArray{}, NullArray{}},
+		{StringArray{"a", ""}, Array{BulkString("a"), BulkString("")}},
+		{Map{{BulkString("k"), Integer(1)}}, Array{BulkString("k"), Integer(1)}},
+		{Double(0.1), BulkString("0.10000000000000001")},
+		{Boolean(true), Integer(1)},
+		{BigNumber("12"), BulkString("12")},
+		{Verbatim{"txt", "hi"}, BulkString("hi")},
+		{Set{Null{}}, Array{Null{}}},
+	} {
+		got, err := NewDecoder(bytes.NewReader(encodeProto(tc.v, 2))).Decode()
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%#v decoded to %#v, %v, want %#v", tc.v, got, err, tc.want)
+		}
+	}
+}
+
+func TestEncodeErrorWithNewlines(t *testing.T) {
+	got := encodeProto(Error("ERR line1\r\nline2\n\xff"), 3)
+	if want := "-ERR line1  line2 \xff\r\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
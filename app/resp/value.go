@@ -0,0 +1,75 @@
+// Package resp implements the Redis serialization protocol: typed reply
+// values, an Encoder that writes them in RESP2 or RESP3 and a Decoder that
+// reads them back.
+package resp
+
+// Value is a RESP value. The set of implementations is closed; it is
+// exactly the types declared in this file.
+type Value interface {
+	value()
+}
+
+type (
+	SimpleString string
+	// Error is sent verbatim on the wire, so it must carry its own prefix
+	// (ERR, WRONGTYPE, ...).
+	Error      string
+	Integer    int64
+	BulkString []byte
+	// Null is the reply for "no value": a nil bulk string in RESP2.
+	Null struct{}
+	// NullArray is what commands that answer with an array send instead
+	// of one, such as a BLPOP that timed out: a nil array in RESP2.
+	NullArray struct{}
+	Array     []Value
+	// StringArray is an array of bulk strings. It goes out exactly like
+	// the equivalent Array, without a BulkString boxed per element, which
+	// matters for replies with many elements.
+	StringArray []string
+	// Map is the RESP3 map type. RESP2 clients get its keys and values
+	// flattened into an array.
+	Map []KeyValue
+	// Double is the RESP3 double type. RESP2 clients get it as a bulk
+	// string.
+	Double float64
+	// Boolean is the RESP3 boolean type. RESP2 clients get 1 or 0.
+	Boolean bool
+	// BigNumber is the RESP3 big number type, kept as its decimal digits.
+	// RESP2 clients get it as a bulk string.
+	BigNumber string
+	// Verbatim is the RESP3 verbatim string: text with a three-letter
+	// format such as txt or mkd. RESP2 clients get the text as a bulk
+	// string.
+	Verbatim struct {
+		Format, Text string
+	}
+	// Set is the RESP3 set type and Push the RESP3 out-of-band push.
+	// RESP2 clients get both as arrays.
+	Set  []Value
+	Push []Value
+)
+
+type KeyValue struct {
+	Key, Value Value
+}
+
+func (SimpleString) value() {}
+func (Error) value()        {}
+func (Integer) value()      {}
+func (BulkString) value()   {}
+func (Null) value()         {}
+func (NullArray) value()    {}
+func (Array) value()        {}
+func (StringArray) value()  {}
+func (Map) value()          {}
+func (Double) value()       {}
+func (Boolean) value()      {}
+func (BigNumber) value()    {}
+func (Verbatim) value()     {}
+func (Set) value()          {}
+func (Push) value()         {}
+
+// BulkStrings builds an array of bulk strings. The array shares items.
+func BulkStrings(items []string) StringArray {
+	return StringArray(items)
+}
@@ -0,0 +1,187 @@
+// Package server runs the whole server: it loads the dataset, listens for
+// clients and serves them until closed. main is a thin wrapper around it,
+// and anything that wants a server in-process, such as an integration
+// test, can use it the same way.
+//
+// Settings live in the config package and are shared by the process, so
+// servers run side by side also share their configuration. The addresses
+// they listen on are their own: each takes them from its Config, and
+// reports the ports it is bound to in INFO and to its master.
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/handler"
+	"redis/app/logging"
+	"redis/app/store"
+	"strconv"
+	"sync"
+)
+
+// Config says how to start a Server.
+type Config struct {
+	// Addr is the address to listen on for plaintext clients, ":0" for a
+	// free port. Empty means the bind and port settings.
+	Addr string
+	// TLSAddr is the address to listen on for TLS clients, which use the
+	// tls-* settings. Empty means the bind and tls-port settings, and no
+	// TLS listener if tls-port is 0.
+	TLSAddr string
+	// Settings are applied before starting, as if given on the command
+	// line: {"save": "", "appendonly": "yes"}.
+	Settings map[string]string
+	// Logger receives the server's log. Nil means one writing to the
+	// logfile setting at the loglevel setting.
+	Logger *slog.Logger
+	// Clock is what key deadlines and blocking timeouts are measured
+	// against. Nil means the system clock; a test can pass a
+	// clock.Manual to expire keys without waiting.
+	Clock clock.Clock
+}
+
+type Server struct {
+	cfg Config
+	srv *handler.Server
+	log *slog.Logger
+	// out is the log file opened for a nil Config.Logger.
+	out *logging.Output
+
+	started   bool
+	served    chan struct{}
+	serveErr  error
+	closeOnce sync.Once
+}
+
+// New applies cfg's settings and returns a server ready to Start.
+func New(cfg Config) (*Server, error) {
+	for name, v := range cfg.Settings {
+		if err := config.SetInitial(name, v); err != nil {
+			return nil, fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	s := &Server{cfg: cfg, log: cfg.Logger, served: make(chan struct{})}
+	if s.log == nil {
+		out, err := logging.Open(config.LogFile.Load())
+		if err != nil {
+			return nil, fmt.Errorf("opening the log file: %w", err)
+		}
+		s.out = out
+		s.log = logging.New(out, &config.LogLevel)
+	}
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	s.srv = handler.NewServer(store.NewMemory(clk), s.log, clk)
+	return s, nil
+}
+
+// Logger returns the logger the server writes to.
+func (s *Server) Logger() *slog.Logger {
+	return s.log
+}
+
+// ReopenLog reopens the log file, for after it was rotated. It does
+// nothing if the log goes to stdout or to a Config.Logger.
+func (s *Server) ReopenLog() error {
+	if s.out == nil {
+		return nil
+	}
+	return s.out.Reopen()
+}
+
+// Use adds a hook around every command clients send, as
+// handler.Server.Use describes. Add hooks before Start for them to see
+// every command.
+func (s *Server) Use(h handler.Hook) {
+	s.srv.Use(h)
+}
+
+// UseReplayed is Use for a hook that also sees the commands replayed
+// from the AOF and those sent by a master.
+func (s *Server) UseReplayed(h handler.Hook) {
+	s.srv.UseReplayed(h)
+}
+
+// Start loads the dataset from disk, binds the listeners and serves
+// clients in the background. It returns once the server is accepting
+// connections.
+func (s *Server) Start() error {
+	addr := s.cfg.Addr
+	if addr == "" {
+		addr = net.JoinHostPort(config.Bind.Load(), strconv.FormatInt(config.Port.Load(), 10))
+	}
+	tlsAddr := s.cfg.TLSAddr
+	if tlsAddr == "" && config.TLSPort.Load() != 0 {
+		tlsAddr = net.JoinHostPort(config.Bind.Load(), strconv.FormatInt(config.TLSPort.Load(), 10))
+	}
+	s.log.Info("Server starting", "pid", os.Getpid(), "run_id", s.srv.RunID(), "addr", addr,
+		"dir", config.Dir.Load(), "appendonly", config.AppendOnly.Load(), "maxmemory", config.MaxMemory.Load(),
+		"loglevel", logging.LevelName(config.LogLevel.Level()))
+	if err := handler.RenameCommands(); err != nil {
+		return fmt.Errorf("renaming commands: %w", err)
+	}
+	if err := s.srv.LoadData(); err != nil {
+		return fmt.Errorf("loading the DB: %w", err)
+	}
+	if err := s.srv.Listen(addr); err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	// With port 0 the listener picked the port; report the one in use.
+	s.log.Info("Ready to accept connections", "addr", s.srv.Addr().String())
+
+	if tlsAddr != "" {
+		// Don't leave the plaintext port bound if TLS can't start.
+		cfg, err := handler.TLSConfig()
+		if err != nil {
+			s.srv.CloseListeners()
+			return fmt.Errorf("configuring TLS: %w", err)
+		}
+		if err := s.srv.ListenTLS(tlsAddr, cfg); err != nil {
+			s.srv.CloseListeners()
+			return fmt.Errorf("listening on %s: %w", tlsAddr, err)
+		}
+		s.log.Info("Ready to accept TLS connections", "addr", s.srv.TLSAddr().String())
+	}
+
+	s.started = true
+	go func() {
+		defer close(s.served)
+		s.serveErr = s.srv.Serve()
+	}()
+	return nil
+}
+
+// Addr returns the address plaintext clients connect to, with the real
+// port when Addr asked for port 0.
+func (s *Server) Addr() net.Addr {
+	return s.srv.Addr()
+}
+
+// TLSAddr returns the address TLS clients connect to, nil if the server
+// doesn't take them.
+func (s *Server) TLSAddr() net.Addr {
+	return s.srv.TLSAddr()
+}
+
+// Wait blocks until the server stops, whether through Close, a SHUTDOWN
+// command or a signal handled by the caller.
+func (s *Server) Wait() error {
+	if !s.started {
+		return nil
+	}
+	<-s.served
+	return s.serveErr
+}
+
+// Close shuts the server down as SHUTDOWN does and returns once it has
+// stopped. Calling it again does nothing more.
+func (s *Server) Close() error {
+	s.closeOnce.Do(s.srv.Shutdown)
+	return s.Wait()
+}
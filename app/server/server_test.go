@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"redis/app/clock"
+	"redis/app/config"
+	"redis/app/resp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// start runs a server on a free local port for the rest of the test,
+// with nothing written outside the test's directory. The settings it
+// applies are put back afterwards.
+func start(t *testing.T, clk clock.Clock) *Server {
+	t.Helper()
+	settings := map[string]string{"save": "", "appendonly": "no", "dir": t.TempDir()}
+	for name := range settings {
+		old, _ := config.Get(name)
+		t.Cleanup(func() { config.SetInitial(name, old) })
+	}
+	s, err := New(Config{
+		Addr:     "127.0.0.1:0",
+		Settings: settings,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Clock:    clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	dec  *resp.Decoder
+}
+
+func connect(t *testing.T, s *Server) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, dec: resp.NewDecoder(conn)}
+}
+
+func (c *client) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write(resp.AppendCommand(nil, args)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() resp.Value {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	v, err := c.dec.Decode()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return v
+}
+
+// expect sends a command and fails the test unless its reply encodes to
+// the same bytes as want.
+func (c *client) expect(want resp.Value, args ...string) {
+	c.t.Helper()
+	c.send(args...)
+	if got := c.read(); !bytes.Equal(wire(got), wire(want)) {
+		c.t.Errorf("%q = %q, want %q", args, wire(got), wire(want))
+	}
+}
+
+func wire(v resp.Value) []byte {
+	var buf bytes.Buffer
+	e := resp.NewEncoder(&buf)
+	e.Encode(v)
+	e.Flush()
+	return buf.Bytes()
+}
+
+// info returns one field of an INFO section.
+func (c *client) info(section, field string) string {
+	c.t.Helper()
+	c.send("INFO", section)
+	text, _ := c.read().(resp.BulkString)
+	for _, line := range strings.Split(string(text), "\r\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			return v
+		}
+	}
+	c.t.Fatalf("INFO %s has no %s", section, field)
+	return ""
+}
+
+func TestPingSetGet(t *testing.T) {
+	c := connect(t, start(t, nil))
+	c.expect(resp.SimpleString("PONG"), "PING")
+	c.expect(resp.SimpleString("OK"), "SET", "k", "v")
+	c.expect(resp.BulkString("v"), "GET", "k")
+	c.expect(resp.Null{}, "GET", "missing")
+}
+
+func TestExpiry(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	c := connect(t, start(t, clk))
+	c.expect(resp.SimpleString("OK"), "SET", "k", "v", "PX", "100")
+	clk.Advance(99 * time.Millisecond)
+	c.expect(resp.BulkString("v"), "GET", "k")
+	c.expect(resp.Integer(1), "PTTL", "k")
+	clk.Advance(time.Millisecond)
+	c.expect(resp.Null{}, "GET", "k")
+}
+
+func TestLPushBLPOP(t *testing.T) {
+	s := start(t, nil)
+	c := connect(t, s)
+	c.expect(resp.Integer(2), "LPUSH", "q", "a", "b")
+	c.expect(resp.Array{resp.BulkString("q"), resp.BulkString("b")}, "BLPOP", "q", "0")
+
+	waiter := connect(t, s)
+	waiter.send("BLPOP", "empty", "0")
+	for deadline := time.Now().Add(5 * time.Second); c.info("clients", "blocked_clients") != "1"; {
+		if time.Now().After(deadline) {
+			t.Fatal("BLPOP didn't block")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The waiter takes the element, leaving the list empty.
+	c.expect(resp.Integer(0), "LPUSH", "empty", "x")
+	if got, want := waiter.read(), (resp.Array{resp.BulkString("empty"), resp.BulkString("x")}); !bytes.Equal(wire(got), wire(want)) {
+		t.Errorf("BLPOP = %q, want %q", wire(got), wire(want))
+	}
+	c.expect(resp.StringArray{"a"}, "LRANGE", "q", "0", "-1")
+}
+
+// TestServersReportTheirOwnPort runs two servers in one process. Each
+// must report the port it listens on, not the other's or the setting's.
+func TestServersReportTheirOwnPort(t *testing.T) {
+	for _, s := range []*Server{start(t, nil), start(t, nil)} {
+		_, port, _ := net.SplitHostPort(s.Addr().String())
+		if got := connect(t, s).info("server", "tcp_port"); got != port {
+			t.Errorf("server on %s reports tcp_port %s", s.Addr(), got)
+		}
+	}
+	if v, _ := config.Get("port"); v != "6379" {
+		t.Errorf("port setting changed to %s", v)
+	}
+}
+
+// TestRunIDIsPerServer checks run_id stays put for the life of a server,
+// whichever connection asks, and that two servers in one process each
+// get their own.
+func TestRunIDIsPerServer(t *testing.T) {
+	var ids []string
+	for _, s := range []*Server{start(t, nil), start(t, nil)} {
+		id := connect(t, s).info("server", "run_id")
+		if len(id) != 40 {
+			t.Errorf("run_id %q isn't 40 characters", id)
+		}
+		c := connect(t, s)
+		c.expect(resp.SimpleString("OK"), "SET", "k", "v")
+		if again := c.info("server", "run_id"); again != id {
+			t.Errorf("run_id changed from %s to %s", id, again)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("both servers have run_id %s", ids[0])
+	}
+}
+
+// TestFailedTLSStartFreesPort starts a server whose TLS setup fails, for
+// want of a certificate, after the plaintext port is bound. Start must
+// release that port again.
+func TestFailedTLSStartFreesPort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	settings := map[string]string{"save": "", "appendonly": "no", "dir": t.TempDir(), "tls-cert-file": "", "tls-key-file": ""}
+	for name := range settings {
+		old, _ := config.Get(name)
+		t.Cleanup(func() { config.SetInitial(name, old) })
+	}
+	s, err := New(Config{
+		Addr:     addr,
+		TLSAddr:  "127.0.0.1:0",
+		Settings: settings,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err == nil {
+		s.Close()
+		t.Fatal("Start succeeded without a certificate")
+	}
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("%s is still bound after the failed Start: %v", addr, err)
+	}
+	l.Close()
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"redis/app/types"
+	"time"
+)
+
+// Clients blocked on a list queue up per key in arrival order. Pushers
+// serve them under the write lock by popping the element themselves and
+// sending it on the request's buffered channel, so a woken client never has
+// to race anyone for the value. A request is owned by whoever removes it
+// from the queue: the pusher (which then always delivers) or the waiter on
+// timeout or disconnect (after which no pusher can see it).
+
+func (m *Memory) PopOrWait(key string, timeout time.Duration) (string, *types.BlockingRequest, error) {
+	m.lock()
+	defer m.unlock()
+	list, err := m.listFor(key, m.clock.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	if list != nil {
+		m.propagate("LPOP", key)
+		return m.popFront(key, list), nil, nil
+	}
+	req := &types.BlockingRequest{
+		Key:     key,
+		Ch:      make(chan string, 1),
+		Timeout: timeout,
+	}
+	m.blocked[key] = append(m.blocked[key], req)
+	m.blockedCount++
+	return "", req, nil
+}
+
+func (m *Memory) CancelWait(req *types.BlockingRequest) (string, bool) {
+	m.lock()
+	waiting := m.removeBlocked(req)
+	m.unlock()
+	if waiting {
+		return "", false
+	}
+	return <-req.Ch, true
+}
+
+// removeBlocked drops req from the queue and reports whether it was still
+// there. Callers must hold the write lock.
+func (m *Memory) removeBlocked(req *types.BlockingRequest) bool {
+	queue := m.blocked[req.Key]
+	for i, r := range queue {
+		if r == req {
+			m.blocked[req.Key] = append(queue[:i:i], queue[i+1:]...)
+			m.blockedCount--
+			if len(m.blocked[req.Key]) == 0 {
+				delete(m.blocked, req.Key)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// serveBlocked hands elements from the head of the list at key to the
+// clients blocked on it, oldest first, one element each, until either runs
+// out. Callers must hold the write lock.
+func (m *Memory) serveBlocked(key string) {
+	for len(m.blocked[key]) > 0 {
+		e, _ := m.keys.get(key)
+		if e == nil {
+			return
+		}
+		list := ownList(e)
+		req := m.blocked[key][0]
+		m.blocked[key] = m.blocked[key][1:]
+		m.blockedCount--
+		if len(m.blocked[key]) == 0 {
+			delete(m.blocked, key)
+		}
+		head, _ := list.Index(0)
+		select {
+		case req.Ch <- head:
+			m.propagate("LPOP", key)
+			m.popFront(key, list)
+		default:
+			// This waiter was already handed an element; try the next one.
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"hash/maphash"
+	"iter"
+	"math/bits"
+	"math/rand/v2"
+	"redis/app/types"
+)
+
+// minBuckets is the size a dict starts at and never shrinks below.
+const minBuckets = 4
+
+// dict is the keyspace's hash table. It is ours rather than a Go map so
+// that SCAN can walk it with a cursor: buckets are chained, their count is
+// a power of two and a key's bucket is the low bits of its hash, which is
+// what the reverse binary cursor relies on. The table doubles once it
+// holds as many keys as buckets and halves when under an eighth full,
+// rehashing everything at once; nothing sees it mid-resize, since callers
+// hold the keyspace lock.
+type dict struct {
+	buckets []*dictEntry
+	used    int
+	seed    maphash.Seed
+}
+
+type dictEntry struct {
+	key   string
+	value *types.Entry
+	next  *dictEntry
+}
+
+func newDict() *dict {
+	return &dict{buckets: make([]*dictEntry, minBuckets), seed: maphash.MakeSeed()}
+}
+
+func (d *dict) bucket(key string) int {
+	return int(maphash.String(d.seed, key) & uint64(len(d.buckets)-1))
+}
+
+func (d *dict) len() int {
+	return d.used
+}
+
+func (d *dict) get(key string) (*types.Entry, bool) {
+	for de := d.buckets[d.bucket(key)]; de != nil; de = de.next {
+		if de.key == key {
+			return de.value, true
+		}
+	}
+	return nil, false
+}
+
+// set stores e under key, replacing the entry already there if any.
+func (d *dict) set(key string, e *types.Entry) {
+	i := d.bucket(key)
+	for de := d.buckets[i]; de != nil; de = de.next {
+		if de.key == key {
+			de.value = e
+			return
+		}
+	}
+	d.buckets[i] = &dictEntry{key: key, value: e, next: d.buckets[i]}
+	d.used++
+	if d.used >= len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+}
+
+func (d *dict) delete(key string) bool {
+	for p := &d.buckets[d.bucket(key)]; *p != nil; p = &(*p).next {
+		if (*p).key == key {
+			*p = (*p).next
+			d.used--
+			if len(d.buckets) > minBuckets && d.used*8 < len(d.buckets) {
+				d.resize(len(d.buckets) / 2)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dict) resize(n int) {
+	old := d.buckets
+	d.buckets = make([]*dictEntry, n)
+	for _, de := range old {
+		for de != nil {
+			next := de.next
+			i := d.bucket(de.key)
+			de.next = d.buckets[i]
+			d.buckets[i] = de
+			de = next
+		}
+	}
+}
+
+// all iterates over every entry. The dict mustn't change meanwhile.
+func (d *dict) all() iter.Seq2[string, *types.Entry] {
+	return d.from(0)
+}
+
+// sample iterates over every entry like all, but starting from a random
+// bucket, for callers that want a few keys picked at random.
+func (d *dict) sample() iter.Seq2[string, *types.Entry] {
+	return d.from(rand.IntN(len(d.buckets)))
+}
+
+func (d *dict) from(start int) iter.Seq2[string, *types.Entry] {
+	return func(yield func(string, *types.Entry) bool) {
+		for n := range len(d.buckets) {
+			for de := d.buckets[(start+n)&(len(d.buckets)-1)]; de != nil; de = de.next {
+				if !yield(de.key, de.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scan calls fn for each entry in the bucket cursor names and returns the
+// cursor of the next bucket, 0 once the walk is over. Walks start at 0.
+//
+// The cursor counts up with its bits reversed, so it runs through a
+// bucket's high bits before its low ones. When the table doubles, bucket
+// i splits into i and i+size, which both come after i in that order;
+// when it halves, the two merge into i, visited again if the walk had only
+// done one of them. Either way a key present for the whole walk is
+// returned at least once, though possibly more than once.
+func (d *dict) scan(cursor uint64, fn func(key string, e *types.Entry)) uint64 {
+	mask := uint64(len(d.buckets) - 1)
+	for de := d.buckets[cursor&mask]; de != nil; de = de.next {
+		fn(de.key, de.value)
+	}
+	cursor |= ^mask
+	return bits.Reverse64(bits.Reverse64(cursor) + 1)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"redis/app/config"
+	"redis/app/types"
+)
+
+// Strings up to this length are what redis-server embeds in the object
+// header.
+const embstrMaxLen = 44
+
+// listpackMaxBytes caps a listpack whose limit is given as an element
+// count, like redis-server's SIZE_SAFETY_LIMIT.
+const listpackMaxBytes = 8 * 1024
+
+// encoding names the representation redis-server would use for e's value.
+func encoding(e *types.Entry) string {
+	switch v := e.Value.(type) {
+	case int64:
+		return "int"
+	case string:
+		if len(v) <= embstrMaxLen {
+			return "embstr"
+		}
+		return "raw"
+	case []byte:
+		return "raw"
+	case *types.List:
+		if v.Quicklist() {
+			return "quicklist"
+		}
+		return "listpack"
+	}
+	return "unknown"
+}
+
+// listpackLimits turns list-max-listpack-size into a maximum element count,
+// zero for none, and a maximum size in bytes.
+func listpackLimits() (count, bytes int) {
+	n := config.ListMaxListpackSize.Load()
+	if n >= 0 {
+		return int(max(n, 1)), listpackMaxBytes
+	}
+	return 0, 4096 << (min(-n, 5) - 1)
+}
+
+// newList returns an empty list whose chunks follow
+// list-max-listpack-size.
+func newList() *types.List {
+	l := types.NewList()
+	l.SetChunkLimits(listpackLimits())
+	return l
+}
+
+// fitsListpack reports whether l is within the listpack limits divided by
+// div.
+func fitsListpack(l *types.List, count, bytes, div int) bool {
+	if count > 0 && l.Len() > count/div {
+		return false
+	}
+	return l.Bytes()+l.Len() <= bytes/div
+}
+
+// updateListEncoding converts l to a quicklist once it outgrows the
+// listpack limits, and back once it is down to half of them, as
+// redis-server does. The gap keeps a list at the limit from converting on
+// every push and pop. It also passes on any change to the limits, which
+// bound the list's chunks too.
+func updateListEncoding(l *types.List) {
+	count, bytes := listpackLimits()
+	l.SetChunkLimits(count, bytes)
+	if l.Quicklist() {
+		if fitsListpack(l, count, bytes, 2) {
+			l.SetQuicklist(false)
+		}
+	} else if !fitsListpack(l, count, bytes, 1) {
+		l.SetQuicklist(true)
+	}
+}
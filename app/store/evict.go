@@ -0,0 +1,169 @@
+package store
+
+import (
+	"math/rand"
+	"redis/app/config"
+	"redis/app/types"
+	"time"
+)
+
+// Memory usage is an estimate of the dataset size: key and value bytes
+// plus a fixed overhead per key and per list element. It is what maxmemory
+// is checked against, and what MEMORY USAGE reports. A list element's
+// overhead is the lengths packed around it and its share of its chunk.
+const (
+	keyOverhead         = 48
+	listElementOverhead = 4
+)
+
+func entrySize(key string, e *types.Entry) int64 {
+	return estimateSize(key, e, 0)
+}
+
+// estimateSize is entrySize with the elements of a list measured from
+// its first samples elements and extrapolated; 0 samples measures them
+// all.
+func estimateSize(key string, e *types.Entry, samples int) int64 {
+	size := int64(keyOverhead + len(key))
+	switch v := e.Value.(type) {
+	case string:
+		size += int64(len(v))
+	case []byte:
+		size += int64(cap(v))
+	case int64:
+		size += 8
+	case *types.List:
+		if samples == 0 || samples >= v.Len() {
+			size += int64(v.Len()*listElementOverhead + v.Bytes())
+			break
+		}
+		sampled := 0
+		for i := 0; i < samples; i++ {
+			elem, _ := v.Index(i)
+			sampled += listElementOverhead + len(elem)
+		}
+		size += int64(sampled) * int64(v.Len()) / int64(samples)
+	}
+	return size
+}
+
+// MemoryUsage estimates the bytes key takes without counting as an
+// access to it.
+func (m *Memory) MemoryUsage(key string, samples int) (size int64, ok bool) {
+	m.readLive(key, func(e *types.Entry) {
+		if e != nil {
+			size, ok = estimateSize(key, e, samples), true
+		}
+	})
+	return size, ok
+}
+
+func (m *Memory) UsedMemory() int64 {
+	return m.usedMemory.Load()
+}
+
+func (m *Memory) FreeMemoryIfNeeded() bool {
+	limit := config.MaxMemory.Load()
+	if limit == 0 || m.usedMemory.Load() <= limit {
+		return true
+	}
+	policy := config.MaxMemoryPolicy()
+	if policy == config.PolicyNoEviction {
+		return false
+	}
+	volatileOnly := policy == config.PolicyVolatileLRU || policy == config.PolicyVolatileLFU
+
+	m.lock()
+	defer m.unlock()
+	for m.usedMemory.Load() > limit {
+		key, ok := m.evictionCandidate(volatileOnly, config.IsLFU())
+		if !ok {
+			return false
+		}
+		m.remove(key)
+		m.evictedKeys.Add(1)
+		m.propagate("DEL", key)
+	}
+	return true
+}
+
+// evictionCandidate samples maxmemory-samples keys, from a random point
+// in the keyspace on, and returns the best one to evict: the least
+// recently used, or under LFU the least frequently used. Callers must hold
+// the write lock.
+func (m *Memory) evictionCandidate(volatileOnly, lfu bool) (string, bool) {
+	samples := int(config.MaxMemorySamples.Load())
+	now := m.clock.Now()
+	var best string
+	var bestScore int64
+	found := 0
+	for key, e := range m.keys.sample() {
+		if volatileOnly && e.ExpiryTime.IsZero() {
+			continue
+		}
+		score := e.LastAccess.Load()
+		if lfu {
+			score = int64(lfuCounter(e, now))
+		}
+		if found == 0 || score < bestScore {
+			best, bestScore = key, score
+		}
+		found++
+		if found == samples {
+			break
+		}
+	}
+	return best, found > 0
+}
+
+// touch records a read or write of e for both LRU and LFU eviction.
+func touch(e *types.Entry, now time.Time) {
+	e.LastAccess.Store(now.UnixMilli())
+	for {
+		old := e.Freq.Load()
+		counter := lfuLogIncr(lfuDecay(old, now))
+		if e.Freq.CompareAndSwap(old, types.PackFreq(now, counter)) {
+			return
+		}
+	}
+}
+
+// lfuCounter returns e's access counter after applying pending decay,
+// without counting an access.
+func lfuCounter(e *types.Entry, now time.Time) uint8 {
+	return lfuDecay(e.Freq.Load(), now)
+}
+
+// lfuDecay lowers the counter by one for every lfu-decay-time minutes that
+// passed since it was last decayed.
+func lfuDecay(freq uint32, now time.Time) uint8 {
+	last, counter := types.UnpackFreq(freq)
+	decayTime := config.LFUDecayTime.Load()
+	if decayTime == 0 {
+		return counter
+	}
+	current := uint32(now.Unix()/60) & 0xffffff
+	elapsed := int64((current - last) & 0xffffff)
+	periods := elapsed / decayTime
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// lfuLogIncr increments the counter with a probability that shrinks as it
+// grows, so eight bits cover everything from a handful of hits to millions.
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	base := float64(counter) - types.LFUInitVal
+	if base < 0 {
+		base = 0
+	}
+	p := 1.0 / (base*float64(config.LFULogFactor.Load()) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Keys with a TTL are tracked in a min-heap ordered by deadline, so the
+// sweeper only ever touches keys that are actually due. Overwriting,
+// re-expiring or persisting a key doesn't search the heap; it gives the
+// entry a new version instead, and heap items whose version no longer
+// matches the stored entry are discarded when they surface.
+const (
+	activeExpireBatch  = 200
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+type expiryItem struct {
+	deadline time.Time
+	key      string
+	version  uint64
+}
+
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// trackExpiry returns a fresh version for an entry about to be stored and,
+// if it has a deadline, schedules it. Callers must hold the write lock.
+func (m *Memory) trackExpiry(key string, deadline time.Time) uint64 {
+	m.lastVersion++
+	if !deadline.IsZero() {
+		heap.Push(&m.expiries, expiryItem{deadline: deadline, key: key, version: m.lastVersion})
+	}
+	return m.lastVersion
+}
+
+// ActiveExpireCycle deletes due keys in batches, releasing the lock between
+// batches so a large wave of expirations doesn't stall clients. Whatever is
+// left when the budget runs out is picked up on the next cycle. It does
+// nothing while expired keys are kept.
+func (m *Memory) ActiveExpireCycle() int {
+	if m.keepExpired.Load() {
+		return 0
+	}
+	deadline := time.Now().Add(activeExpireBudget)
+	total := 0
+	for {
+		popped, expired := m.expireBatch(activeExpireBatch)
+		total += expired
+		if popped < activeExpireBatch || time.Now().After(deadline) {
+			return total
+		}
+	}
+}
+
+// expireBatch pops up to n due heap items and reports how many it popped
+// and how many of those still named a key, which it deleted.
+func (m *Memory) expireBatch(n int) (popped, expired int) {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	for popped < n && len(m.expiries) > 0 && !now.Before(m.expiries[0].deadline) {
+		item := heap.Pop(&m.expiries).(expiryItem)
+		popped++
+		if e, ok := m.keys.get(item.key); ok && e.Version == item.version {
+			m.deleteExpired(item.key)
+			expired++
+		}
+	}
+	return popped, expired
+}
+
+// deleteExpired removes a key whose TTL has passed. Both the lazy path in
+// lookups and the active sweeper go through here so that side effects of
+// expiry live in one place. Callers must hold the write lock.
+func (m *Memory) deleteExpired(key string) {
+	m.remove(key)
+	m.expiredKeys.Add(1)
+	m.propagate("DEL", key)
+}
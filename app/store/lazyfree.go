@@ -0,0 +1,67 @@
+package store
+
+import "redis/app/types"
+
+// Values with more elements than this are freed in the background when
+// unlinked; smaller ones cost less to free than to hand over.
+const lazyfreeThreshold = 64
+
+// lazyfreeQueue bounds how many values wait for the freer; past it,
+// values are freed inline rather than blocking under the lock.
+const lazyfreeQueue = 1024
+
+func (m *Memory) Unlink(keys ...string) int {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	unlinked := []string{"UNLINK"}
+	for _, key := range keys {
+		e := m.writeLive(key, now)
+		if e == nil || !m.remove(key) {
+			continue
+		}
+		unlinked = append(unlinked, key)
+		// A list a snapshot is reading is left to the collector.
+		if l, ok := e.Value.(*types.List); ok && l.Len() > lazyfreeThreshold && !l.Pinned() {
+			m.freeLazily(l)
+		}
+	}
+	if len(unlinked) > 1 {
+		m.propagate(unlinked...)
+	}
+	return len(unlinked) - 1
+}
+
+// freeLazily passes l to the background freer. Callers must hold the
+// write lock.
+func (m *Memory) freeLazily(l *types.List) {
+	m.lazyfreeOnce.Do(func() {
+		m.lazyfree = make(chan *types.List, lazyfreeQueue)
+		go m.lazyfreeLoop()
+	})
+	m.lazyfreePending.Add(1)
+	m.lazyfreeDone.Add(1)
+	select {
+	case m.lazyfree <- l:
+	default:
+		m.free(l)
+	}
+}
+
+func (m *Memory) lazyfreeLoop() {
+	for l := range m.lazyfree {
+		m.free(l)
+	}
+}
+
+// free drops a lazily freed list's elements, so that what it referenced is
+// garbage without the collector having to walk the list.
+func (m *Memory) free(l *types.List) {
+	l.Clear()
+	m.lazyfreePending.Add(-1)
+	m.lazyfreeDone.Done()
+}
+
+func (m *Memory) DrainLazyFree() {
+	m.lazyfreeDone.Wait()
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"redis/app/types"
+	"strconv"
+	"time"
+)
+
+func (m *Memory) LPush(key string, values ...string) (int, error) {
+	return m.push("LPUSH", key, values, (*types.List).PushFront)
+}
+
+func (m *Memory) RPush(key string, values ...string) (int, error) {
+	return m.push("RPUSH", key, values, (*types.List).PushBack)
+}
+
+// push appends values one at a time, then lets blocked clients take what
+// they're waiting for. The reply is the length left after that, which is
+// what redis-server reports too.
+func (m *Memory) push(cmd, key string, values []string, pushOne func(*types.List, string)) (int, error) {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	e := m.writeLive(key, now)
+	if e == nil {
+		e = m.add(key, newList(), time.Time{}, now)
+	}
+	if _, ok := e.Value.(*types.List); !ok {
+		return 0, ErrWrongType
+	}
+	list := ownList(e)
+	touch(e, now)
+	for _, v := range values {
+		pushOne(list, v)
+		m.usedMemory.Add(int64(listElementOverhead + len(v)))
+	}
+	updateListEncoding(list)
+	m.propagate(append([]string{cmd, key}, values...)...)
+	m.serveBlocked(key)
+	e, _ = m.keys.get(key)
+	return listLen(e), nil
+}
+
+// listFor returns the list at key ready to be modified, nil if there is
+// none. Callers must hold the write lock.
+func (m *Memory) listFor(key string, now time.Time) (*types.List, error) {
+	e := m.writeLive(key, now)
+	if e == nil {
+		return nil, nil
+	}
+	if _, ok := e.Value.(*types.List); !ok {
+		return nil, ErrWrongType
+	}
+	touch(e, now)
+	return ownList(e), nil
+}
+
+// ownList returns the list in e ready to be modified: if a snapshot is
+// still reading it, e gets a copy of its own first. Callers must hold the
+// write lock.
+func ownList(e *types.Entry) *types.List {
+	list := e.Value.(*types.List)
+	if list.Pinned() {
+		list = list.Clone()
+		e.Value = list
+	}
+	return list
+}
+
+// popFront removes the head of the list at key and drops the key once the
+// list is empty. Callers must hold the write lock.
+func (m *Memory) popFront(key string, list *types.List) string {
+	v, _ := list.PopFront()
+	m.usedMemory.Add(-int64(listElementOverhead + len(v)))
+	if list.Len() == 0 {
+		m.remove(key)
+	} else {
+		updateListEncoding(list)
+	}
+	return v
+}
+
+func (m *Memory) LPop(key string, count int) ([]string, bool, error) {
+	m.lock()
+	defer m.unlock()
+	list, err := m.listFor(key, m.clock.Now())
+	if list == nil {
+		return nil, false, err
+	}
+	count = min(count, list.Len())
+	out := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		out = append(out, m.popFront(key, list))
+	}
+	switch {
+	case len(out) == 1:
+		m.propagate("LPOP", key)
+	case len(out) > 1:
+		m.propagate("LPOP", key, strconv.Itoa(len(out)))
+	}
+	return out, true, nil
+}
+
+func (m *Memory) LRange(key string, start, stop int) (out []string, err error) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		list, ok := e.Value.(*types.List)
+		if !ok {
+			err = ErrWrongType
+			return
+		}
+		touch(e, m.clock.Now())
+		out = list.Range(start, stop)
+	})
+	return out, err
+}
+
+func (m *Memory) LLen(key string) (n int, err error) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		if _, ok := e.Value.(*types.List); !ok {
+			err = ErrWrongType
+			return
+		}
+		touch(e, m.clock.Now())
+		n = listLen(e)
+	})
+	return n, err
+}
+
+func listLen(e *types.Entry) int {
+	if e == nil {
+		return 0
+	}
+	return e.Value.(*types.List).Len()
+}
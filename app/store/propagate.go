@@ -0,0 +1,39 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// Propagator is told about every change to the keyspace as a command that
+// reproduces it, so the AOF and replicas can replay exactly what happened
+// rather than what was asked for: a SET with a relative TTL arrives with
+// an absolute deadline, an expired key as a DEL, an element handed to a
+// blocked client as an LPOP. seq numbers the changes in the order they
+// were made. The propagator runs under the write lock, so it must be quick
+// and must not call back into the store.
+type Propagator func(seq uint64, args []string)
+
+func (m *Memory) SetPropagator(p Propagator) {
+	m.lock()
+	defer m.unlock()
+	m.propagator = p
+}
+
+func (m *Memory) SetKeepExpired(keep bool) {
+	m.keepExpired.Store(keep)
+}
+
+// propagate records one change. Callers must hold the write lock.
+func (m *Memory) propagate(args ...string) {
+	m.seq++
+	if m.propagator != nil {
+		m.propagator(m.seq, args)
+	}
+}
+
+// propagateDeadline records key's new TTL. Callers must hold the write
+// lock.
+func (m *Memory) propagateDeadline(key string, deadline time.Time) {
+	m.propagate("PEXPIREAT", key, strconv.FormatInt(deadline.UnixMilli(), 10))
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"redis/app/types"
+	"time"
+)
+
+// SnapshotEntry is one key of a Snapshot. Value is a string or, for
+// lists, a []string.
+type SnapshotEntry struct {
+	Key      string
+	Value    any
+	ExpireAt time.Time
+}
+
+// Snapshot is a point-in-time copy of the keyspace, for persistence to
+// work through without holding the lock.
+type Snapshot struct {
+	Entries []SnapshotEntry
+	// Expires counts the entries with a deadline.
+	Expires int
+	// Seq is the sequence number of the last change the snapshot
+	// includes; see Propagator.
+	Seq uint64
+}
+
+// Snapshot copies every live key as of one point in time. Only walking
+// the keys holds the read lock. Strings are copied during the walk, since
+// APPEND and SETRANGE change them in place; lists are pinned and copied
+// once the lock is released. Writers that get to a pinned list meanwhile
+// change a copy instead, so the extra memory is proportional to the lists
+// written during the copy.
+func (m *Memory) Snapshot() *Snapshot {
+	m.rlock()
+	now := m.clock.Now()
+	snap := &Snapshot{Entries: make([]SnapshotEntry, 0, m.keys.len()), Seq: m.seq}
+	var lists []int
+	for key, e := range m.keys.all() {
+		if e.Expired(now) {
+			continue
+		}
+		value := e.Value
+		if l, ok := value.(*types.List); ok {
+			l.Pin()
+			lists = append(lists, len(snap.Entries))
+		} else {
+			value = exportValue(value)
+		}
+		snap.Entries = append(snap.Entries, SnapshotEntry{Key: key, Value: value, ExpireAt: e.ExpiryTime})
+		if !e.ExpiryTime.IsZero() {
+			snap.Expires++
+		}
+	}
+	m.runlock()
+
+	for _, i := range lists {
+		l := snap.Entries[i].Value.(*types.List)
+		snap.Entries[i].Value = exportValue(l)
+		l.Unpin()
+	}
+	return snap
+}
+
+// Load adds the entries of snap to the keyspace, replacing any keys that
+// already exist.
+func (m *Memory) Load(snap *Snapshot) {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	for _, entry := range snap.Entries {
+		m.add(entry.Key, importValue(entry.Value), entry.ExpireAt, now)
+	}
+}
+
+// exportValue copies a stored value into the form snapshots use.
+func exportValue(v any) any {
+	if l, ok := v.(*types.List); ok {
+		return l.Range(0, -1)
+	}
+	s, _ := types.AsString(v)
+	return s
+}
+
+// importValue builds a stored value from the form snapshots use.
+func importValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return types.StringValue(v)
+	case []string:
+		list := newList()
+		for _, elem := range v {
+			list.PushBack(elem)
+		}
+		updateListEncoding(list)
+		return list
+	}
+	return v
+}
+
+func (m *Memory) Dump(key string) (value any, ok bool) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		touch(e, m.clock.Now())
+		value, ok = exportValue(e.Value), true
+	})
+	return value, ok
+}
+
+func (m *Memory) Peek(key string) (value any, ok bool) {
+	m.readLive(key, func(e *types.Entry) {
+		if e != nil {
+			value, ok = exportValue(e.Value), true
+		}
+	})
+	return value, ok
+}
+
+func (m *Memory) Restore(key string, value any, deadline time.Time, replace bool) error {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	exists := m.writeLive(key, now) != nil
+	if exists && !replace {
+		return ErrBusyKey
+	}
+	if !deadline.IsZero() && !deadline.After(now) {
+		if exists {
+			m.remove(key)
+			m.propagate("DEL", key)
+		}
+		return nil
+	}
+	m.add(key, importValue(value), deadline, now)
+	switch v := value.(type) {
+	case string:
+		m.propagate("SET", key, v)
+	case []string:
+		if exists {
+			m.propagate("DEL", key)
+		}
+		m.propagate(append([]string{"RPUSH", key}, v...)...)
+	}
+	if !deadline.IsZero() {
+		m.propagateDeadline(key, deadline)
+	}
+	return nil
+}
+
+// Clear deletes every key, as a replica does before loading its master's
+// snapshot.
+func (m *Memory) Clear() {
+	m.lock()
+	defer m.unlock()
+	m.keys = newDict()
+	m.expiries = nil
+	m.expires = 0
+	m.usedMemory.Store(0)
+}
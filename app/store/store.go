@@ -0,0 +1,515 @@
+// Package store holds the keyspace. All locking happens in here: callers
+// only ever get copies of values back, so no handler can forget a lock or
+// race another connection on a shared map.
+//
+// Each Store method is one critical section, deciding and acting under
+// the same lock, so a command that maps onto one method is atomic. A
+// command needing several methods to appear as one change must run them
+// through Atomic rather than one after another.
+package store
+
+import (
+	"errors"
+	"redis/app/clock"
+	"redis/app/types"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrBusyKey   = errors.New("BUSYKEY Target key name already exists.")
+	// ErrStringTooLong refuses to grow a string past maxStringLen.
+	ErrStringTooLong = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// SetOptions modify how Set stores a string.
+type SetOptions struct {
+	// ExpireAt is the key's deadline; the zero time means no TTL.
+	ExpireAt time.Time
+}
+
+// KeyInfo is the metadata OBJECT and DEBUG OBJECT report about a key.
+type KeyInfo struct {
+	Type     string
+	Encoding string
+	Idle     time.Duration
+	Freq     uint8
+}
+
+// Stats are the keyspace counters INFO reports.
+type Stats struct {
+	Keys           int64
+	Expires        int64 // keys with a TTL
+	BlockedClients int64
+	ExpiredKeys    int64
+	EvictedKeys    int64
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+	// LazyfreePending counts unlinked values not yet freed.
+	LazyfreePending int64
+}
+
+// Store is the keyspace as seen by command handlers.
+type Store interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string, opts SetOptions)
+	// SetIfTTLBelow sets key to value with deadline expireAt if key is
+	// missing or expires within threshold, and reports whether it did. A
+	// key without a TTL is left alone.
+	SetIfTTLBelow(key, value string, threshold time.Duration, expireAt time.Time) bool
+	// Append adds value to the end of the string at key, which it creates
+	// if missing, and returns the new length.
+	Append(key, value string) (int, error)
+	// SetRange writes value over the string at key from offset on, padding
+	// with zero bytes as needed, and returns the new length. A missing key
+	// is created unless value is empty.
+	SetRange(key string, offset int, value string) (int, error)
+	// GetRange returns the part of the string at key from start to end
+	// inclusive, negative offsets counting back from its end.
+	GetRange(key string, start, end int) (string, error)
+	StrLen(key string) (int, error)
+	Delete(keys ...string) int
+	// Unlink deletes keys like Delete, but leaves freeing large values to
+	// a background goroutine.
+	Unlink(keys ...string) int
+	// DrainLazyFree waits until every value Unlink handed off is freed.
+	DrainLazyFree()
+	// Expire sets the deadline of an existing key, deleting it right away
+	// if the deadline has already passed. It reports whether key existed.
+	Expire(key string, deadline time.Time) bool
+	Persist(key string) bool
+	// Deadline returns the key's expiry, zero if it has none.
+	Deadline(key string) (time.Time, bool)
+	// Touch counts an access to each of keys that exists, without reading
+	// them, and returns how many did.
+	Touch(keys ...string) int
+	// Info inspects a key without counting as an access to it.
+	Info(key string) (KeyInfo, bool)
+	// MemoryUsage estimates the bytes key and its value take, like
+	// UsedMemory does, measuring list elements from a sample of samples
+	// of them, or all of them for 0.
+	MemoryUsage(key string, samples int) (int64, bool)
+
+	LPush(key string, values ...string) (int, error)
+	RPush(key string, values ...string) (int, error)
+	// LPop removes up to count elements from the head of the list. The
+	// boolean reports whether the key existed.
+	LPop(key string, count int) ([]string, bool, error)
+	LRange(key string, start, stop int) ([]string, error)
+	LLen(key string) (int, error)
+	// PopOrWait pops the head of the list at key, or if there is nothing to
+	// pop, queues a request that a later push will hand an element to.
+	PopOrWait(key string, timeout time.Duration) (string, *types.BlockingRequest, error)
+	// CancelWait withdraws a request from PopOrWait. If a push already
+	// served it, the element it was handed is returned instead.
+	CancelWait(req *types.BlockingRequest) (string, bool)
+
+	// Dump returns the value at key in the form Snapshot uses.
+	Dump(key string) (any, bool)
+	// Peek is Dump without counting as an access to key.
+	Peek(key string) (any, bool)
+	// Restore stores a value in the form Snapshot uses at key, failing
+	// with ErrBusyKey if key exists and replace isn't set. A deadline
+	// that has passed deletes key instead.
+	Restore(key string, value any, deadline time.Time, replace bool) error
+	// Snapshot copies the whole keyspace for persistence.
+	Snapshot() *Snapshot
+	// Load adds the keys of a snapshot, as read from a dump.
+	Load(snap *Snapshot)
+	// Clear empties the keyspace.
+	Clear()
+	// SetPropagator installs the hook that is told about every change.
+	SetPropagator(p Propagator)
+	// SetKeepExpired makes keys whose deadline has passed look absent to
+	// reads without deleting them, and stops active expiry, as a replica
+	// does: it leaves deleting them to the DEL its master propagates.
+	SetKeepExpired(keep bool)
+	// ForEach calls fn for every live key until fn returns false.
+	ForEach(fn func(key string) bool)
+	// Scan continues a walk over the keyspace from cursor, starting at 0:
+	// it returns the live keys of the next few buckets, stopping once it
+	// has about count, and the cursor to go on from, 0 when the walk is
+	// over. A key that exists throughout a walk is returned at least once
+	// however the keyspace changes meanwhile, though maybe more than once.
+	Scan(cursor uint64, count int) ([]string, uint64)
+	Len() int
+
+	UsedMemory() int64
+	Stats() Stats
+	// ResetStats zeroes the event counters in Stats.
+	ResetStats()
+	// FreeMemoryIfNeeded evicts keys per maxmemory-policy until usage is
+	// under maxmemory, reporting false if that isn't possible.
+	FreeMemoryIfNeeded() bool
+	// ActiveExpireCycle deletes keys whose deadline has passed and returns
+	// how many it deleted.
+	ActiveExpireCycle() int
+	// Atomic runs fn with exclusive access to the keyspace; fn must only
+	// use tx, not the Store it was called on.
+	Atomic(fn func(tx Store))
+	// Stall holds the write lock for d, stopping every other command, to
+	// simulate a slow operation.
+	Stall(d time.Duration)
+}
+
+// Memory is the in-memory Store. Strings and lists share one keyspace
+// under one RWMutex; commands that only read take the read lock.
+type Memory struct {
+	*keyspace
+	// held is set on the view Atomic hands its callback: the lock is
+	// already held for it, so its methods don't take it.
+	held bool
+}
+
+type keyspace struct {
+	mu          sync.RWMutex
+	clock       clock.Clock
+	keys        *dict
+	expiries    expiryHeap
+	lastVersion uint64
+	blocked     map[string][]*types.BlockingRequest
+	propagator  Propagator
+	// seq counts changes to the keyspace; Snapshot records it.
+	seq uint64
+	// keepExpired is set on a replica; see SetKeepExpired.
+	keepExpired atomic.Bool
+	// expires and blockedCount are guarded by mu.
+	expires      int64
+	blockedCount int64
+
+	usedMemory     atomic.Int64
+	evictedKeys    atomic.Int64
+	expiredKeys    atomic.Int64
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+
+	// lazyfree carries unlinked values to the goroutine that frees them.
+	lazyfree        chan *types.List
+	lazyfreeOnce    sync.Once
+	lazyfreePending atomic.Int64
+	lazyfreeDone    sync.WaitGroup
+}
+
+// NewMemory returns an empty keyspace that tells the time by clk.
+func NewMemory(clk clock.Clock) *Memory {
+	return &Memory{keyspace: &keyspace{
+		clock:   clk,
+		keys:    newDict(),
+		blocked: make(map[string][]*types.BlockingRequest),
+	}}
+}
+
+func (m *Memory) lock() {
+	if !m.held {
+		m.mu.Lock()
+	}
+}
+
+func (m *Memory) unlock() {
+	if !m.held {
+		m.mu.Unlock()
+	}
+}
+
+func (m *Memory) rlock() {
+	if !m.held {
+		m.mu.RLock()
+	}
+}
+
+func (m *Memory) runlock() {
+	if !m.held {
+		m.mu.RUnlock()
+	}
+}
+
+// Atomic runs fn with the write lock held throughout, so that no other
+// caller sees the keyspace between the operations fn makes on tx.
+func (m *Memory) Atomic(fn func(tx Store)) {
+	m.lock()
+	defer m.unlock()
+	fn(&Memory{keyspace: m.keyspace, held: true})
+}
+
+// readLive runs fn with the live entry for key, or nil, under the read
+// lock. A key found expired is deleted first, which needs the write lock,
+// unless expired keys are being kept, in which case it is only hidden.
+func (m *Memory) readLive(key string, fn func(e *types.Entry)) {
+	now := m.clock.Now()
+	m.rlock()
+	e, ok := m.keys.get(key)
+	if !ok || !e.Expired(now) {
+		fn(e)
+		m.runlock()
+		return
+	}
+	if m.keepExpired.Load() {
+		fn(nil)
+		m.runlock()
+		return
+	}
+	m.runlock()
+
+	m.lock()
+	defer m.unlock()
+	fn(m.writeLive(key, now))
+}
+
+// writeLive returns the live entry for key, deleting it if it has expired.
+// While expired keys are kept, the only writer is the master, for which
+// the key still exists until it says otherwise, so it is returned as is.
+// Callers must hold the write lock.
+func (m *Memory) writeLive(key string, now time.Time) *types.Entry {
+	e, ok := m.keys.get(key)
+	if !ok {
+		return nil
+	}
+	if e.Expired(now) && !m.keepExpired.Load() {
+		m.deleteExpired(key)
+		return nil
+	}
+	return e
+}
+
+// add stores a fresh entry under key, replacing any previous value.
+// Callers must hold the write lock.
+func (m *Memory) add(key string, value any, expireAt time.Time, now time.Time) *types.Entry {
+	m.remove(key)
+	e := types.NewEntry(value, expireAt, m.trackExpiry(key, expireAt), now)
+	m.keys.set(key, e)
+	if !expireAt.IsZero() {
+		m.expires++
+	}
+	m.usedMemory.Add(entrySize(key, e))
+	return e
+}
+
+// remove deletes key. Callers must hold the write lock.
+func (m *Memory) remove(key string) bool {
+	e, ok := m.keys.get(key)
+	if !ok {
+		return false
+	}
+	m.usedMemory.Add(-entrySize(key, e))
+	if !e.ExpiryTime.IsZero() {
+		m.expires--
+	}
+	m.keys.delete(key)
+	return true
+}
+
+// countLookup records a read command's lookup for keyspace_hits and
+// keyspace_misses.
+func (m *Memory) countLookup(e *types.Entry) {
+	if e != nil {
+		m.keyspaceHits.Add(1)
+	} else {
+		m.keyspaceMisses.Add(1)
+	}
+}
+
+func (m *Memory) Get(key string) (value string, ok bool, err error) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		s, isString := types.AsString(e.Value)
+		if !isString {
+			err = ErrWrongType
+			return
+		}
+		touch(e, m.clock.Now())
+		value, ok = s, true
+	})
+	return value, ok, err
+}
+
+func (m *Memory) Set(key, value string, opts SetOptions) {
+	m.lock()
+	defer m.unlock()
+	m.add(key, types.StringValue(value), opts.ExpireAt, m.clock.Now())
+	m.propagate("SET", key, value)
+	if !opts.ExpireAt.IsZero() {
+		m.propagateDeadline(key, opts.ExpireAt)
+	}
+}
+
+func (m *Memory) SetIfTTLBelow(key, value string, threshold time.Duration, expireAt time.Time) bool {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	if e := m.writeLive(key, now); e != nil && (e.ExpiryTime.IsZero() || e.ExpiryTime.Sub(now) >= threshold) {
+		return false
+	}
+	m.add(key, types.StringValue(value), expireAt, now)
+	m.propagate("SET", key, value)
+	m.propagateDeadline(key, expireAt)
+	return true
+}
+
+func (m *Memory) Delete(keys ...string) int {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	deleted := []string{"DEL"}
+	for _, key := range keys {
+		if m.writeLive(key, now) != nil && m.remove(key) {
+			deleted = append(deleted, key)
+		}
+	}
+	if len(deleted) > 1 {
+		m.propagate(deleted...)
+	}
+	return len(deleted) - 1
+}
+
+func (m *Memory) Expire(key string, deadline time.Time) bool {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	e := m.writeLive(key, now)
+	if e == nil {
+		return false
+	}
+	if !deadline.After(now) && !m.keepExpired.Load() {
+		m.remove(key)
+		m.propagate("DEL", key)
+		return true
+	}
+	if e.ExpiryTime.IsZero() {
+		m.expires++
+	}
+	e.ExpiryTime = deadline
+	e.Version = m.trackExpiry(key, deadline)
+	m.propagateDeadline(key, deadline)
+	return true
+}
+
+func (m *Memory) Persist(key string) bool {
+	m.lock()
+	defer m.unlock()
+	e := m.writeLive(key, m.clock.Now())
+	if e == nil || e.ExpiryTime.IsZero() {
+		return false
+	}
+	m.expires--
+	e.ExpiryTime = time.Time{}
+	e.Version = m.trackExpiry(key, time.Time{})
+	m.propagate("PERSIST", key)
+	return true
+}
+
+func (m *Memory) Deadline(key string) (deadline time.Time, ok bool) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e != nil {
+			deadline, ok = e.ExpiryTime, true
+		}
+	})
+	return deadline, ok
+}
+
+func (m *Memory) Touch(keys ...string) int {
+	n := 0
+	for _, key := range keys {
+		m.readLive(key, func(e *types.Entry) {
+			m.countLookup(e)
+			if e != nil {
+				touch(e, m.clock.Now())
+				n++
+			}
+		})
+	}
+	return n
+}
+
+func (m *Memory) Info(key string) (info KeyInfo, ok bool) {
+	m.readLive(key, func(e *types.Entry) {
+		if e == nil {
+			return
+		}
+		now := m.clock.Now()
+		info = KeyInfo{
+			Type:     e.TypeName(),
+			Encoding: encoding(e),
+			Idle:     time.Duration(now.UnixMilli()-e.LastAccess.Load()) * time.Millisecond,
+			Freq:     lfuCounter(e, now),
+		}
+		ok = true
+	})
+	return info, ok
+}
+
+func (m *Memory) Stall(d time.Duration) {
+	m.lock()
+	defer m.unlock()
+	time.Sleep(d)
+}
+
+func (m *Memory) ForEach(fn func(key string) bool) {
+	m.rlock()
+	defer m.runlock()
+	now := m.clock.Now()
+	for key, e := range m.keys.all() {
+		if e.Expired(now) {
+			continue
+		}
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+func (m *Memory) Scan(cursor uint64, count int) ([]string, uint64) {
+	m.rlock()
+	defer m.runlock()
+	now := m.clock.Now()
+	var keys []string
+	// A sparse table has many empty buckets; bound how many one call
+	// looks at, as redis-server does.
+	for visited := 0; visited < 10*count && len(keys) < count; visited++ {
+		cursor = m.keys.scan(cursor, func(key string, e *types.Entry) {
+			if !e.Expired(now) {
+				keys = append(keys, key)
+			}
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, cursor
+}
+
+// Len counts keys including expired ones the sweeper hasn't reached yet,
+// as DBSIZE does.
+func (m *Memory) Len() int {
+	m.rlock()
+	defer m.runlock()
+	return m.keys.len()
+}
+
+func (m *Memory) Stats() Stats {
+	m.rlock()
+	defer m.runlock()
+	return Stats{
+		Keys:           int64(m.keys.len()),
+		Expires:        m.expires,
+		BlockedClients: m.blockedCount,
+		ExpiredKeys:    m.expiredKeys.Load(),
+		EvictedKeys:    m.evictedKeys.Load(),
+		KeyspaceHits:   m.keyspaceHits.Load(),
+		KeyspaceMisses: m.keyspaceMisses.Load(),
+
+		LazyfreePending: m.lazyfreePending.Load(),
+	}
+}
+
+func (m *Memory) ResetStats() {
+	m.expiredKeys.Store(0)
+	m.evictedKeys.Store(0)
+	m.keyspaceHits.Store(0)
+	m.keyspaceMisses.Store(0)
+}
@@ -0,0 +1,375 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"redis/app/clock"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+func TestTTL(t *testing.T) {
+	clk := clock.NewManual(epoch)
+	m := NewMemory(clk)
+	m.Set("k", "v", SetOptions{ExpireAt: epoch.Add(10 * time.Second)})
+	m.Set("forever", "v", SetOptions{})
+
+	if d, ok := m.Deadline("k"); !ok || !d.Equal(epoch.Add(10*time.Second)) {
+		t.Errorf("Deadline(k) = %v, %v", d, ok)
+	}
+	if d, ok := m.Deadline("forever"); !ok || !d.IsZero() {
+		t.Errorf("Deadline(forever) = %v, %v, want no deadline", d, ok)
+	}
+	if got := m.Stats().Expires; got != 1 {
+		t.Errorf("Expires = %d, want 1", got)
+	}
+
+	clk.Advance(9999 * time.Millisecond)
+	if v, ok, _ := m.Get("k"); !ok || v != "v" {
+		t.Fatalf("Get(k) a millisecond before its deadline = %q, %v", v, ok)
+	}
+	clk.Advance(time.Millisecond)
+	if _, ok, _ := m.Get("k"); ok {
+		t.Fatal("Get(k) found the key at its deadline")
+	}
+	if _, ok := m.Deadline("k"); ok {
+		t.Error("Deadline(k) found an expired key")
+	}
+	if m.Expire("k", clk.Now().Add(time.Second)) {
+		t.Error("Expire revived an expired key")
+	}
+	if got := m.Stats(); got.Expires != 0 || got.ExpiredKeys != 1 {
+		t.Errorf("Expires = %d, ExpiredKeys = %d, want 0 and 1", got.Expires, got.ExpiredKeys)
+	}
+
+	// Setting a key again drops its TTL; Persist does too.
+	m.Set("k", "v", SetOptions{ExpireAt: clk.Now().Add(time.Second)})
+	m.Set("k", "v2", SetOptions{})
+	if d, _ := m.Deadline("k"); !d.IsZero() {
+		t.Errorf("Set kept the old deadline %v", d)
+	}
+	m.Expire("k", clk.Now().Add(time.Second))
+	if !m.Persist("k") || m.Persist("k") {
+		t.Error("Persist should succeed once")
+	}
+	clk.Advance(time.Hour)
+	if v, ok, _ := m.Get("k"); !ok || v != "v2" {
+		t.Errorf("Get(k) after Persist = %q, %v", v, ok)
+	}
+
+	// A deadline already past deletes the key.
+	if !m.Expire("k", clk.Now()) {
+		t.Error("Expire(k, now) = false")
+	}
+	if m.Len() != 1 {
+		t.Errorf("Len() = %d, want only forever left", m.Len())
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	clk := clock.NewManual(epoch)
+	m := NewMemory(clk)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprint(i), "v", SetOptions{ExpireAt: epoch.Add(time.Duration(i%2+1) * time.Second)})
+	}
+	clk.Advance(time.Second)
+	if n := m.ActiveExpireCycle(); n != 50 {
+		t.Errorf("first cycle expired %d keys, want 50", n)
+	}
+	if m.Len() != 50 {
+		t.Errorf("Len() = %d, want 50", m.Len())
+	}
+	clk.Advance(time.Second)
+	m.ActiveExpireCycle()
+	if m.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", m.Len())
+	}
+}
+
+func TestTypeConflicts(t *testing.T) {
+	m := NewMemory(clock.NewManual(epoch))
+	m.Set("str", "v", SetOptions{})
+	if _, err := m.RPush("list", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, err := range map[string]error{
+		"LPush(str)":   second(m.LPush("str", "x")),
+		"RPush(str)":   second(m.RPush("str", "x")),
+		"LRange(str)":  second(m.LRange("str", 0, -1)),
+		"LLen(str)":    second(m.LLen("str")),
+		"Append(list)": second(m.Append("list", "x")),
+		"StrLen(list)": second(m.StrLen("list")),
+	} {
+		if !errors.Is(err, ErrWrongType) {
+			t.Errorf("%s: got %v, want ErrWrongType", name, err)
+		}
+	}
+	if _, _, err := m.Get("list"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Get(list): got %v, want ErrWrongType", err)
+	}
+	if _, _, err := m.LPop("str", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("LPop(str): got %v, want ErrWrongType", err)
+	}
+
+	// The failed calls changed nothing.
+	if v, _, _ := m.Get("str"); v != "v" {
+		t.Errorf("Get(str) = %q, want v", v)
+	}
+	if got, _ := m.LRange("list", 0, -1); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("LRange(list) = %q", got)
+	}
+
+	// Set replaces a value of any type.
+	m.Set("list", "now a string", SetOptions{})
+	if v, ok, err := m.Get("list"); !ok || err != nil || v != "now a string" {
+		t.Errorf("Get(list) after Set = %q, %v, %v", v, ok, err)
+	}
+}
+
+func second[T any](_ T, err error) error {
+	return err
+}
+
+// TestConcurrentAccess mixes writes, reads and pops on a few keys from
+// many goroutines. Under -race it catches any path that skips the lock;
+// at the end the counts must add up.
+func TestConcurrentAccess(t *testing.T) {
+	m := NewMemory(clock.Real)
+	const workers, rounds = 16, 500
+	var pushed, popped sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	for w := 0; w < workers; w++ {
+		pushed.Add(1)
+		popped.Add(1)
+		go func() {
+			defer pushed.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprint("str", i%4)
+				switch i % 4 {
+				case 0:
+					m.Set(key, "v", SetOptions{ExpireAt: time.Now().Add(time.Millisecond)})
+				case 1:
+					m.Get(key)
+				case 2:
+					m.Append(key, "x")
+				default:
+					m.Delete(key)
+				}
+				m.RPush("list", fmt.Sprint(w, ":", i))
+			}
+		}()
+		go func() {
+			defer popped.Done()
+			for n := 0; n < rounds; {
+				got, _, err := m.LPop("list", 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, v := range got {
+					seen[v]++
+					n++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	pushed.Wait()
+	popped.Wait()
+	if len(seen) != workers*rounds {
+		t.Errorf("popped %d distinct elements, want %d", len(seen), workers*rounds)
+	}
+	for v, n := range seen {
+		if n != 1 {
+			t.Errorf("%s popped %d times", v, n)
+		}
+	}
+	if n, _ := m.LLen("list"); n != 0 {
+		t.Errorf("LLen(list) = %d, want 0", n)
+	}
+}
+
+// TestScanDuringWrites runs full SCAN iterations while a writer grows
+// and shrinks the table under them. Keys there the whole time must each
+// come back at least once per iteration, however the table resizes.
+func TestScanDuringWrites(t *testing.T) {
+	m := NewMemory(clock.Real)
+	const stable, churn = 1000, 5000
+	for i := 0; i < stable; i++ {
+		m.Set(fmt.Sprint("stable:", i), "v", SetOptions{})
+	}
+	stop := make(chan struct{})
+	var cycles atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			for i := 0; i < churn; i++ {
+				m.Set(fmt.Sprint("churn:", i), "v", SetOptions{})
+			}
+			for i := 0; i < churn; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.Delete(fmt.Sprint("churn:", i))
+			}
+			cycles.Add(1)
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// Keep going until the writer has been through a few grow and shrink
+	// cycles, which a slow machine may not manage in the first rounds.
+	for round := 0; round < 20 || cycles.Load() < 3; round++ {
+		seen := make(map[string]bool)
+		for cursor := uint64(0); ; {
+			var keys []string
+			keys, cursor = m.Scan(cursor, 10)
+			for _, k := range keys {
+				seen[k] = true
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		for i := 0; i < stable; i++ {
+			if key := fmt.Sprint("stable:", i); !seen[key] {
+				t.Fatalf("round %d: SCAN never returned %s", round, key)
+			}
+		}
+	}
+}
+
+// TestReadsDuringAppend reads a string while APPEND grows it in place.
+// Chunk i is 64 copies of letter i%26, so a read that caught an APPEND
+// halfway, or a buffer being reused under it, shows a torn chunk.
+func TestReadsDuringAppend(t *testing.T) {
+	m := NewMemory(clock.Real)
+	const chunk, chunks = 64, 2000
+	check := func(op, v string) {
+		if len(v)%chunk != 0 {
+			t.Fatalf("%s returned %d bytes, not whole chunks", op, len(v))
+		}
+		for i := 0; i < len(v); i += chunk {
+			if want := strings.Repeat(string(rune('a'+i/chunk%26)), chunk); v[i:i+chunk] != want {
+				t.Fatalf("%s: chunk %d is %q", op, i/chunk, v[i:i+chunk])
+			}
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < chunks; i++ {
+			if _, err := m.Append("s", strings.Repeat(string(rune('a'+i%26)), chunk)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if n, _ := m.StrLen("s"); n != chunk*chunks {
+				t.Errorf("StrLen(s) = %d, want %d", n, chunk*chunks)
+			}
+			return
+		default:
+		}
+		v, _, err := m.Get("s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("Get", v)
+		v, err = m.GetRange("s", 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("GetRange", v)
+	}
+}
+
+// TestGrowBytesCopiesLinearly builds a 64MB string 1KB at a time. The
+// bytes copied by reallocations must stay within a constant factor of
+// its length, where a fixed growth step would copy it quadratically.
+func TestGrowBytesCopiesLinearly(t *testing.T) {
+	const chunk, size = 1 << 10, 64 << 20
+	var b []byte
+	copied, reallocs := 0, 0
+	for len(b) < size {
+		before := cap(b)
+		b = growBytes(b, len(b)+chunk)
+		if cap(b) != before {
+			copied += len(b) - chunk
+			reallocs++
+		}
+	}
+	if copied > 5*size {
+		t.Errorf("copied %d bytes building %d, more than 5 times over", copied, size)
+	}
+	if reallocs > 64 {
+		t.Errorf("reallocated %d times", reallocs)
+	}
+}
+
+// BenchmarkAppend builds strings of 2.5MB to 10MB from 1KB appends. The
+// bytes copied per chunk stay bounded as the string gets longer, so
+// ns/chunk should only creep up as the string outgrows the CPU caches.
+func BenchmarkAppend(b *testing.B) {
+	data := strings.Repeat("x", 1024)
+	for _, chunks := range []int{2500, 5000, 10000} {
+		b.Run(fmt.Sprint(chunks), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := NewMemory(clock.Real)
+				for j := 0; j < chunks; j++ {
+					m.Append("s", data)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*chunks), "ns/chunk")
+		})
+	}
+}
+
+// BenchmarkGetParallel measures GET throughput from a fixed number of
+// goroutines. Reads share the lock, so given the cores ns/op falls as
+// goroutines are added, where one exclusive mutex kept it flat.
+func BenchmarkGetParallel(b *testing.B) {
+	m := NewMemory(clock.Real)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+		m.Set(keys[i], "value", SetOptions{})
+	}
+	for _, goroutines := range []int{1, 8, 32} {
+		b.Run(fmt.Sprint(goroutines), func(b *testing.B) {
+			per := (b.N + goroutines - 1) / goroutines
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < per; i++ {
+						m.Get(keys[(g*per+i)%len(keys)])
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"redis/app/types"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxStringLen is the longest string APPEND and SETRANGE will make,
+	// redis-server's default proto-max-bulk-len.
+	maxStringLen = 512 << 20
+	// maxPrealloc bounds the spare capacity growBytes adds to a short
+	// string.
+	maxPrealloc = 1 << 20
+)
+
+// ownBytes returns the string in e as a []byte that may be changed in
+// place, converting it if it is still a string or an int64. Callers must
+// hold the write lock.
+func ownBytes(e *types.Entry) []byte {
+	if b, ok := e.Value.([]byte); ok {
+		return b
+	}
+	s, _ := types.AsString(e.Value)
+	return []byte(s)
+}
+
+// growBytes returns b lengthened to n, the new bytes zero. When it has
+// to reallocate it leaves as much room again, up to maxPrealloc, as
+// redis-server does for its strings. Past that it leaves a quarter of n
+// spare: redis-server's realloc can often extend a large block without
+// copying it, but Go always copies, and a fixed step would make building
+// a long string from APPENDs quadratic. Either way the string is copied
+// a logarithmic number of times rather than on each APPEND.
+func growBytes(b []byte, n int) []byte {
+	if n <= len(b) {
+		return b
+	}
+	if n <= cap(b) {
+		old := len(b)
+		b = b[:n]
+		clear(b[old:])
+		return b
+	}
+	grown := make([]byte, n, n+max(min(n, maxPrealloc), n/4))
+	copy(grown, b)
+	return grown
+}
+
+// updateBytes stores b as e's value, accounting for the change in size.
+// Callers must hold the write lock.
+func (m *Memory) updateBytes(key string, e *types.Entry, b []byte) {
+	before := entrySize(key, e)
+	e.Value = b
+	m.usedMemory.Add(entrySize(key, e) - before)
+}
+
+// stringEntry returns the live string entry at key, or nil, for a write.
+// Callers must hold the write lock.
+func (m *Memory) stringEntry(key string, now time.Time) (*types.Entry, error) {
+	e := m.writeLive(key, now)
+	if e == nil {
+		return nil, nil
+	}
+	if e.TypeName() != "string" {
+		return nil, ErrWrongType
+	}
+	return e, nil
+}
+
+func (m *Memory) Append(key, value string) (int, error) {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	e, err := m.stringEntry(key, now)
+	if err != nil {
+		return 0, err
+	}
+	if e == nil {
+		m.add(key, types.StringValue(value), time.Time{}, now)
+		m.propagate("APPEND", key, value)
+		return len(value), nil
+	}
+	b := ownBytes(e)
+	n := len(b) + len(value)
+	if n > maxStringLen {
+		return 0, ErrStringTooLong
+	}
+	b = growBytes(b, n)
+	copy(b[n-len(value):], value)
+	m.updateBytes(key, e, b)
+	touch(e, now)
+	m.propagate("APPEND", key, value)
+	return n, nil
+}
+
+func (m *Memory) SetRange(key string, offset int, value string) (int, error) {
+	m.lock()
+	defer m.unlock()
+	now := m.clock.Now()
+	e, err := m.stringEntry(key, now)
+	if err != nil {
+		return 0, err
+	}
+	var b []byte
+	if e != nil {
+		b = ownBytes(e)
+	}
+	if value == "" {
+		return len(b), nil
+	}
+	if offset+len(value) > maxStringLen {
+		return 0, ErrStringTooLong
+	}
+	if e == nil {
+		e = m.add(key, []byte(nil), time.Time{}, now)
+	}
+	b = growBytes(b, offset+len(value))
+	copy(b[offset:], value)
+	m.updateBytes(key, e, b)
+	touch(e, now)
+	m.propagate("SETRANGE", key, strconv.Itoa(offset), value)
+	return len(b), nil
+}
+
+func (m *Memory) GetRange(key string, start, end int) (value string, err error) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		var n int
+		switch v := e.Value.(type) {
+		case string:
+			n = len(v)
+		case []byte:
+			n = len(v)
+		case int64:
+			n = len(strconv.FormatInt(v, 10))
+		default:
+			err = ErrWrongType
+			return
+		}
+		touch(e, m.clock.Now())
+		if start < 0 && end < 0 && start > end {
+			return
+		}
+		if start < 0 {
+			start = max(n+start, 0)
+		}
+		if end < 0 {
+			end = max(n+end, 0)
+		}
+		end = min(end, n-1)
+		if start > end {
+			return
+		}
+		// Only the requested bytes are copied out of a []byte.
+		switch v := e.Value.(type) {
+		case []byte:
+			value = string(v[start : end+1])
+		default:
+			s, _ := types.AsString(v)
+			value = s[start : end+1]
+		}
+	})
+	return value, err
+}
+
+func (m *Memory) StrLen(key string) (n int, err error) {
+	m.readLive(key, func(e *types.Entry) {
+		m.countLookup(e)
+		if e == nil {
+			return
+		}
+		switch v := e.Value.(type) {
+		case string:
+			n = len(v)
+		case []byte:
+			n = len(v)
+		case int64:
+			n = len(strconv.FormatInt(v, 10))
+		default:
+			err = ErrWrongType
+			return
+		}
+		touch(e, m.clock.Now())
+	})
+	return n, err
+}
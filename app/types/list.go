@@ -0,0 +1,358 @@
+package types
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// defaultChunkBytes is the size a chunk is kept to until SetChunkLimits
+// says otherwise, list-max-listpack-size's default of 8kb.
+const defaultChunkBytes = 8 * 1024
+
+// List is a double-ended queue of strings. Like redis-server's quicklist,
+// it packs its elements into chunks of bytes rather than keeping a string
+// header and an allocation for each: an element is its length, its bytes
+// and its length again, so a chunk can be read and popped from either
+// end. The chunks sit in a ring buffer, so pushing or popping at either
+// end is amortized O(1).
+type List struct {
+	chunks  []*chunk
+	head    int
+	nchunks int
+	n       int
+	bytes   int
+	// maxCount and maxBytes bound the chunks pushes fill; maxCount 0
+	// leaves the count unbounded.
+	maxCount, maxBytes int
+	// quicklist records that the list outgrew list-max-listpack-size; the
+	// store flips it as the list grows and shrinks.
+	quicklist bool
+	// pins counts snapshots still reading the list without a lock. A
+	// pinned list must not change; writers modify a Clone instead.
+	pins atomic.Int32
+}
+
+// chunk holds count elements in buf[start:]. The bytes before start are
+// room for pushing at the front.
+type chunk struct {
+	buf   []byte
+	start int
+	count int
+}
+
+func NewList() *List {
+	return &List{maxBytes: defaultChunkBytes}
+}
+
+func (l *List) Len() int {
+	return l.n
+}
+
+// Bytes returns the combined length of all elements.
+func (l *List) Bytes() int {
+	return l.bytes
+}
+
+// Quicklist reports whether the list is past the size kept as a listpack.
+func (l *List) Quicklist() bool {
+	return l.quicklist
+}
+
+func (l *List) SetQuicklist(v bool) {
+	l.quicklist = v
+}
+
+// SetChunkLimits bounds the chunks later pushes fill to count elements,
+// 0 for no bound, and bytes of packed elements. An element too big for
+// any chunk gets one of its own. Chunks already full are left as they
+// are.
+func (l *List) SetChunkLimits(count, bytes int) {
+	l.maxCount, l.maxBytes = count, bytes
+}
+
+func (l *List) PushFront(v string) {
+	size := frameSize(len(v))
+	c := l.chunk(0)
+	if c == nil || !l.fits(c, size) {
+		c = l.newChunk(c, true)
+		l.growChunks()
+		l.head = (l.head - 1 + len(l.chunks)) % len(l.chunks)
+		l.chunks[l.head] = c
+		l.nchunks++
+	}
+	if c.start < size {
+		// Leave room in front for as much again as the chunk holds, so a
+		// run of pushes copies it a logarithmic number of times.
+		used := len(c.buf) - c.start
+		room := max(size, used, cap(c.buf)-used)
+		buf := make([]byte, room+used)
+		copy(buf[room:], c.buf[c.start:])
+		c.buf, c.start = buf, room
+	}
+	c.start -= size
+	appendElem(c.buf[c.start:c.start], v)
+	c.count++
+	l.n++
+	l.bytes += len(v)
+}
+
+func (l *List) PushBack(v string) {
+	size := frameSize(len(v))
+	c := l.chunk(l.nchunks - 1)
+	if c == nil || !l.fits(c, size) {
+		c = l.newChunk(c, false)
+		l.growChunks()
+		l.chunks[(l.head+l.nchunks)%len(l.chunks)] = c
+		l.nchunks++
+	}
+	c.buf = appendElem(c.buf, v)
+	c.count++
+	l.n++
+	l.bytes += len(v)
+}
+
+func (l *List) PopFront() (string, bool) {
+	if l.n == 0 {
+		return "", false
+	}
+	c := l.chunks[l.head]
+	elem, next := c.elem(c.start)
+	c.start = next
+	if c.count--; c.count == 0 {
+		l.chunks[l.head] = nil
+		l.head = (l.head + 1) % len(l.chunks)
+		l.nchunks--
+	}
+	l.n--
+	l.bytes -= len(elem)
+	return string(elem), true
+}
+
+func (l *List) PopBack() (string, bool) {
+	if l.n == 0 {
+		return "", false
+	}
+	i := (l.head + l.nchunks - 1) % len(l.chunks)
+	c := l.chunks[i]
+	elem, prev := c.lastElem()
+	v := string(elem)
+	c.buf = c.buf[:prev]
+	if c.count--; c.count == 0 {
+		l.chunks[i] = nil
+		l.nchunks--
+	}
+	l.n--
+	l.bytes -= len(v)
+	return v, true
+}
+
+// Index returns the element at i, counting from the tail when i is
+// negative, as LINDEX does.
+func (l *List) Index(i int) (string, bool) {
+	if i < 0 {
+		i += l.n
+	}
+	if i < 0 || i >= l.n {
+		return "", false
+	}
+	var v string
+	l.walk(i, func(elem []byte) bool {
+		v = string(elem)
+		return false
+	})
+	return v, true
+}
+
+// Range returns a copy of the elements from start to stop inclusive, using
+// LRANGE's rules: negative indexes count from the tail and out-of-range
+// indexes are clamped.
+func (l *List) Range(start, stop int) []string {
+	if start < 0 {
+		start = max(l.n+start, 0)
+	}
+	if stop < 0 {
+		stop = l.n + stop
+	}
+	if stop >= l.n {
+		stop = l.n - 1
+	}
+	if start > stop || start >= l.n {
+		return nil
+	}
+	out := make([]string, 0, stop-start+1)
+	l.walk(start, func(elem []byte) bool {
+		out = append(out, string(elem))
+		return len(out) < cap(out)
+	})
+	return out
+}
+
+// Pin marks the list as being read by a snapshot until Unpin.
+func (l *List) Pin() {
+	l.pins.Add(1)
+}
+
+func (l *List) Unpin() {
+	l.pins.Add(-1)
+}
+
+// Pinned reports whether a snapshot is reading the list.
+func (l *List) Pinned() bool {
+	return l.pins.Load() > 0
+}
+
+// Clone returns an unpinned copy of the list.
+func (l *List) Clone() *List {
+	c := &List{
+		chunks:    make([]*chunk, max(l.nchunks, 8)),
+		nchunks:   l.nchunks,
+		n:         l.n,
+		bytes:     l.bytes,
+		maxCount:  l.maxCount,
+		maxBytes:  l.maxBytes,
+		quicklist: l.quicklist,
+	}
+	for i := 0; i < l.nchunks; i++ {
+		src := l.chunk(i)
+		c.chunks[i] = &chunk{buf: append([]byte(nil), src.buf[src.start:]...), count: src.count}
+	}
+	return c
+}
+
+// Clear removes every element.
+func (l *List) Clear() {
+	clear(l.chunks)
+	*l = List{maxCount: l.maxCount, maxBytes: l.maxBytes}
+}
+
+// chunk returns the i-th chunk from the front, nil if there is none.
+func (l *List) chunk(i int) *chunk {
+	if i < 0 || i >= l.nchunks {
+		return nil
+	}
+	return l.chunks[(l.head+i)%len(l.chunks)]
+}
+
+// newChunk returns a chunk to push onto, at the front or the back, once
+// full can take no more; full is nil when the list is empty. full is
+// trimmed, and since a list that filled one chunk is likely to fill the
+// next, that one gets room for as much up front.
+func (l *List) newChunk(full *chunk, front bool) *chunk {
+	if full == nil {
+		return &chunk{}
+	}
+	full.trim()
+	room := min(l.maxBytes, 2*(len(full.buf)-full.start))
+	if front {
+		return &chunk{buf: make([]byte, room), start: room}
+	}
+	return &chunk{buf: make([]byte, 0, room)}
+}
+
+// fits reports whether c can take another element of size packed bytes.
+func (l *List) fits(c *chunk, size int) bool {
+	if l.maxCount > 0 && c.count >= l.maxCount {
+		return false
+	}
+	return len(c.buf)-c.start+size <= l.maxBytes
+}
+
+// walk calls fn with the elements from the one at i on, stopping once fn
+// returns false. The bytes fn gets are only valid during the call.
+func (l *List) walk(i int, fn func(elem []byte) bool) {
+	k := 0
+	if i < l.n/2 {
+		for ; i >= l.chunk(k).count; k++ {
+			i -= l.chunk(k).count
+		}
+	} else {
+		// Find the chunk from the tail, which is nearer.
+		fromTail := l.n - 1 - i
+		for k = l.nchunks - 1; fromTail >= l.chunk(k).count; k-- {
+			fromTail -= l.chunk(k).count
+		}
+		i = l.chunk(k).count - 1 - fromTail
+	}
+	for ; k < l.nchunks; k++ {
+		c := l.chunk(k)
+		off := c.start
+		for ; i > 0; i-- {
+			_, off = c.elem(off)
+		}
+		for off < len(c.buf) {
+			var elem []byte
+			elem, off = c.elem(off)
+			if !fn(elem) {
+				return
+			}
+		}
+	}
+}
+
+// growChunks doubles the chunk ring when it is full, unwrapping it so the
+// chunks start at index 0 again.
+func (l *List) growChunks() {
+	if l.nchunks < len(l.chunks) {
+		return
+	}
+	chunks := make([]*chunk, max(2*len(l.chunks), 8))
+	for i := 0; i < l.nchunks; i++ {
+		chunks[i] = l.chunk(i)
+	}
+	l.chunks = chunks
+	l.head = 0
+}
+
+// elem returns the element packed at off and the offset of the next one.
+func (c *chunk) elem(off int) ([]byte, int) {
+	n, k := binary.Uvarint(c.buf[off:])
+	data := off + k
+	return c.buf[data : data+int(n)], data + int(n) + k
+}
+
+// lastElem returns the chunk's last element and the offset it starts at.
+func (c *chunk) lastElem() ([]byte, int) {
+	// The trailing length is the leading one's bytes in reverse, so it is
+	// read from its last byte back.
+	n, shift, end := 0, 0, len(c.buf)
+	for {
+		end--
+		b := c.buf[end]
+		n |= int(b&0x7f) << shift
+		shift += 7
+		if b < 0x80 {
+			break
+		}
+	}
+	return c.buf[end-n : end], end - n - (len(c.buf) - end)
+}
+
+// trim drops the spare capacity of a chunk that pushes are done with,
+// unless there is too little to be worth a copy.
+func (c *chunk) trim() {
+	if used := len(c.buf) - c.start; cap(c.buf)-used > used/8 {
+		c.buf = append(make([]byte, 0, used), c.buf[c.start:]...)
+		c.start = 0
+	}
+}
+
+// frameSize is how many bytes an element of n bytes takes packed.
+func frameSize(n int) int {
+	k := 1
+	for m := n; m >= 0x80; m >>= 7 {
+		k++
+	}
+	return n + 2*k
+}
+
+// appendElem packs v onto the end of buf.
+func appendElem(buf []byte, v string) []byte {
+	var length [binary.MaxVarintLen64]byte
+	k := binary.PutUvarint(length[:], uint64(len(v)))
+	buf = append(buf, length[:k]...)
+	buf = append(buf, v...)
+	for i := k - 1; i >= 0; i-- {
+		buf = append(buf, length[i])
+	}
+	return buf
+}
@@ -0,0 +1,270 @@
+package types
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// fill pushes n elements "0", "1", ... to the front one at a time, the
+// way LPUSH does, and returns the list and the order LRANGE 0 -1 should
+// report: the last one pushed first.
+func fill(n int) (*List, []string) {
+	l := NewList()
+	want := make([]string, n)
+	for i := 0; i < n; i++ {
+		l.PushFront(strconv.Itoa(i))
+		want[n-1-i] = strconv.Itoa(i)
+	}
+	return l, want
+}
+
+func TestListRangeOrder(t *testing.T) {
+	// Small enough for one chunk, and big enough for many.
+	for _, n := range []int{3, 10000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			l, want := fill(n)
+			if got := l.Range(0, -1); !slices.Equal(got, want) {
+				t.Fatalf("Range(0, -1) differs from LPUSH order, first elements %q", got[:min(len(got), 3)])
+			}
+			for _, tc := range []struct{ start, stop int }{
+				{0, 0}, {1, 2}, {-2, -1}, {-n - 5, 1}, {n - 1, n + 5}, {2, 1}, {n, n + 1},
+			} {
+				got := l.Range(tc.start, tc.stop)
+				start, stop := tc.start, tc.stop
+				if start < 0 {
+					start = max(n+start, 0)
+				}
+				if stop < 0 {
+					stop += n
+				}
+				stop = min(stop, n-1)
+				var exp []string
+				if start <= stop {
+					exp = want[start : stop+1]
+				}
+				if !slices.Equal(got, exp) {
+					t.Errorf("Range(%d, %d) = %q, want %q", tc.start, tc.stop, got, exp)
+				}
+			}
+			for _, i := range []int{0, n / 2, n - 1, -1, -n} {
+				v, ok := l.Index(i)
+				j := i
+				if j < 0 {
+					j += n
+				}
+				if !ok || v != want[j] {
+					t.Errorf("Index(%d) = %q, %v, want %q", i, v, ok, want[j])
+				}
+			}
+			if _, ok := l.Index(n); ok {
+				t.Errorf("Index(%d) found an element", n)
+			}
+		})
+	}
+}
+
+func TestListPopBothEnds(t *testing.T) {
+	l, want := fill(5000)
+	l.PushBack("tail")
+	want = append(want, "tail")
+	for len(want) > 0 {
+		v, _ := l.PopFront()
+		if v != want[0] {
+			t.Fatalf("PopFront() = %q, want %q", v, want[0])
+		}
+		want = want[1:]
+		if len(want) == 0 {
+			break
+		}
+		v, _ = l.PopBack()
+		if v != want[len(want)-1] {
+			t.Fatalf("PopBack() = %q, want %q", v, want[len(want)-1])
+		}
+		want = want[:len(want)-1]
+		if l.Len() != len(want) {
+			t.Fatalf("Len() = %d, want %d", l.Len(), len(want))
+		}
+	}
+	if _, ok := l.PopFront(); ok {
+		t.Error("PopFront() on an empty list found an element")
+	}
+	if _, ok := l.PopBack(); ok {
+		t.Error("PopBack() on an empty list found an element")
+	}
+}
+
+// TestListMatchesSlice runs random pushes and pops at both ends against
+// a plain slice, under chunk limits from one element per chunk to none,
+// with elements from empty to bigger than any chunk. Every so often it
+// compares the whole list, a few Index calls and a Clone.
+func TestListMatchesSlice(t *testing.T) {
+	for _, limits := range []struct{ count, bytes int }{
+		{0, defaultChunkBytes}, {1, defaultChunkBytes}, {4, defaultChunkBytes}, {0, 64}, {128, 1 << 20},
+	} {
+		t.Run(fmt.Sprintf("count=%d,bytes=%d", limits.count, limits.bytes), func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			l := NewList()
+			l.SetChunkLimits(limits.count, limits.bytes)
+			var want []string
+			for op := 0; op < 20000; op++ {
+				switch r.Intn(5) {
+				case 0, 1:
+					v := randomElem(r)
+					if r.Intn(2) == 0 {
+						l.PushFront(v)
+						want = slices.Insert(want, 0, v)
+					} else {
+						l.PushBack(v)
+						want = append(want, v)
+					}
+				case 2:
+					v, ok := l.PopFront()
+					if len(want) == 0 {
+						if ok {
+							t.Fatalf("op %d: PopFront() on an empty list = %q", op, v)
+						}
+						continue
+					}
+					if !ok || v != want[0] {
+						t.Fatalf("op %d: PopFront() = %q, %v, want %q", op, v, ok, want[0])
+					}
+					want = want[1:]
+				case 3:
+					v, ok := l.PopBack()
+					if len(want) == 0 {
+						if ok {
+							t.Fatalf("op %d: PopBack() on an empty list = %q", op, v)
+						}
+						continue
+					}
+					if !ok || v != want[len(want)-1] {
+						t.Fatalf("op %d: PopBack() = %q, %v, want %q", op, v, ok, want[len(want)-1])
+					}
+					want = want[:len(want)-1]
+				case 4:
+					if op%50 != 0 {
+						continue
+					}
+					checkList(t, l, want)
+					checkList(t, l.Clone(), want)
+				}
+				if l.Len() != len(want) {
+					t.Fatalf("op %d: Len() = %d, want %d", op, l.Len(), len(want))
+				}
+			}
+			checkList(t, l, want)
+		})
+	}
+}
+
+// randomElem returns an element of 0 to 20 bytes, or now and then one of
+// a few kilobytes.
+func randomElem(r *rand.Rand) string {
+	n := r.Intn(21)
+	if r.Intn(50) == 0 {
+		n = 1000 + r.Intn(10000)
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b)
+}
+
+func checkList(t *testing.T, l *List, want []string) {
+	t.Helper()
+	if got := l.Range(0, -1); !slices.Equal(got, want) {
+		t.Fatalf("Range(0, -1) has %d elements that differ from the %d expected", len(got), len(want))
+	}
+	bytes := 0
+	for _, v := range want {
+		bytes += len(v)
+	}
+	if l.Bytes() != bytes {
+		t.Fatalf("Bytes() = %d, want %d", l.Bytes(), bytes)
+	}
+	for _, i := range []int{0, len(want) / 2, len(want) - 1} {
+		if i < 0 {
+			continue
+		}
+		if v, ok := l.Index(i); !ok || v != want[i] {
+			t.Fatalf("Index(%d) = %q, %v, want %q", i, v, ok, want[i])
+		}
+	}
+}
+
+// TestListMemory holds a million 10-byte elements. Packed into chunks
+// they must take at most half the heap a []string of the same elements
+// needs, a string header and an allocation each.
+func TestListMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a million-element list")
+	}
+	const n = 1000000
+	elem := func(i int) string { return fmt.Sprintf("elem%06d", i) }
+
+	before := heapInUse()
+	strs := make([]string, n)
+	for i := range strs {
+		strs[i] = elem(i)
+	}
+	sliceBytes := heapInUse() - before
+	runtime.KeepAlive(strs)
+	strs = nil
+
+	before = heapInUse()
+	l := NewList()
+	for i := 0; i < n; i++ {
+		l.PushBack(elem(i))
+	}
+	listBytes := heapInUse() - before
+	runtime.KeepAlive(l)
+
+	t.Logf("[]string: %.1fMB, List: %.1fMB", float64(sliceBytes)/(1<<20), float64(listBytes)/(1<<20))
+	if listBytes*2 > sliceBytes {
+		t.Errorf("the list takes %d bytes, more than half the %d of a []string", listBytes, sliceBytes)
+	}
+}
+
+// heapInUse returns the bytes of live heap objects after a collection.
+func heapInUse() int64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
+
+// BenchmarkPopPush pops the head of a million-element list and pushes a
+// new element at the tail, the steady state of a queue.
+func BenchmarkPopPush(b *testing.B) {
+	l := NewList()
+	for i := 0; i < 1000000; i++ {
+		l.PushBack("0123456789")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.PopFront()
+		l.PushBack("0123456789")
+	}
+}
+
+// BenchmarkPushFront pushes n elements to the head of a list. The time
+// per element stays flat as n grows; with the copy-on-prepend slice it
+// grew with n.
+func BenchmarkPushFront(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l := NewList()
+				for j := 0; j < n; j++ {
+					l.PushFront("element")
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/elem")
+		})
+	}
+}
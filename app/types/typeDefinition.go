@@ -1,11 +1,95 @@
 package types
 
-import "time"
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
 
+// Entry is one key's value and metadata. Value holds a string, an int64
+// for a string that is the canonical form of one, a []byte for a string
+// APPEND or SETRANGE changes in place, or a *List.
 type Entry struct {
-	Value string
+	Value      any
 	ExpiryTime time.Time
-} 
+	// Version changes whenever the entry is replaced or its TTL changes,
+	// which lets the expiry heap recognise its own stale items.
+	Version uint64
+	// LastAccess is the unix time in milliseconds of the last read or
+	// write, and Freq the LFU state: the minute of the last decay in the
+	// upper 24 bits and a logarithmic access counter in the low 8. Both
+	// are updated atomically because readers only hold a read lock, and
+	// both are always maintained so the eviction policy can change at any
+	// time.
+	LastAccess atomic.Int64
+	Freq       atomic.Uint32
+}
+
+// NewEntry returns an entry for value stamped as accessed at now.
+func NewEntry(value any, expiry time.Time, version uint64, now time.Time) *Entry {
+	e := &Entry{Value: value, ExpiryTime: expiry, Version: version}
+	e.LastAccess.Store(now.UnixMilli())
+	e.Freq.Store(PackFreq(now, LFUInitVal))
+	return e
+}
+
+// Expired reports whether the entry has a deadline that is not after now.
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.ExpiryTime.IsZero() && !now.Before(e.ExpiryTime)
+}
+
+// TypeName returns the name TYPE reports for the value.
+func (e *Entry) TypeName() string {
+	switch e.Value.(type) {
+	case string, int64, []byte:
+		return "string"
+	case *List:
+		return "list"
+	}
+	return "none"
+}
+
+// StringValue returns what to store for the string s: an int64 if s is
+// exactly how that integer formats, which takes less memory, else s.
+func StringValue(s string) any {
+	if len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			return n
+		}
+	}
+	return s
+}
+
+// AsString returns the string held by a value from StringValue, or by a
+// []byte. The latter is copied, so the result stays as it is when the
+// value is next changed in place.
+func AsString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	}
+	return "", false
+}
+
+// LFUInitVal is the counter given to new entries so they aren't evicted
+// before they had a chance to be accessed.
+const LFUInitVal = 5
+
+// PackFreq builds an Entry.Freq value.
+func PackFreq(now time.Time, counter uint8) uint32 {
+	minutes := uint32(now.Unix()/60) & 0xffffff
+	return minutes<<8 | uint32(counter)
+}
+
+// UnpackFreq splits an Entry.Freq value into its decay minute and counter.
+func UnpackFreq(freq uint32) (minutes uint32, counter uint8) {
+	return freq >> 8, uint8(freq)
+}
+
 type BlockingRequest struct {
 	Key     string
 	Ch      chan string